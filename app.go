@@ -9,9 +9,12 @@ import (
 	"fmt"
 	"github.com/byteintellect/go_commons/config"
 	"github.com/byteintellect/go_commons/db"
+	"github.com/byteintellect/go_commons/db/migrate"
+	cfErrors "github.com/byteintellect/go_commons/errors"
 	"github.com/byteintellect/go_commons/logger"
 	"github.com/byteintellect/go_commons/monitoring"
 	"github.com/byteintellect/go_commons/tracing"
+	"github.com/byteintellect/go_commons/util"
 	"github.com/google/uuid"
 	grpcPrometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
@@ -22,10 +25,13 @@ import (
 	traceSdk "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"gorm.io/gorm"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"net"
@@ -33,15 +39,22 @@ import (
 	"os"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var (
-	requestIdCtxKey   = "X-CO-RELATION-ID"
+	requestIdCtxKey   = util.RequestIdCtxKey
 	httpPatternCtxKey = "X-HTTP-PATH"
 	gRPCMethodCtxKey  = "X-GRPC-HANDLER-METHOD"
 	serviceName       = fmt.Sprintf("%v_%v", os.Getenv("APP_NAME"), os.Getenv("APP_ENV"))
 	metricsPath       = "/metrics"
+	tenantMdKey       = "x-tenant-id"
+	requestIdMdKey    = strings.ToLower(util.RequestIdCtxKey)
+	errTenantMismatch = cfErrors.NewCFError(
+		cfErrors.WithCode("403"),
+		cfErrors.WithMessage("tenant does not match the token"),
+		cfErrors.WithStatus(403))
 )
 
 // ResponseWriter is a wrapper around http.ResponseWriter that provides extra information about
@@ -153,21 +166,30 @@ func (rw *responseWriterCloseNotifier) CloseNotify() <-chan bool {
 	return rw.ResponseWriter.(http.CloseNotifier).CloseNotify()
 }
 
+// gatewayMetadata forwards the request's handler, path pattern, tenant and correlation id to the gRPC server.
+func gatewayMetadata(ctx context.Context, request *http.Request) metadata.MD {
+	md := make(map[string]string)
+	if method, ok := runtime.RPCMethod(ctx); ok {
+		md["method"] = method
+		request.Header.Add("x-grpc-handler-method", method)
+	}
+	if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+		md["pattern"] = pattern
+		request.Header.Add("x-http-path", pattern)
+	}
+	if tenant := util.Tenant(request.Context()); tenant != "" {
+		md[tenantMdKey] = tenant
+	}
+	if requestId := util.RequestId(request.Context()); requestId != "" {
+		md[requestIdMdKey] = requestId
+	}
+	return metadata.New(md)
+}
+
 func GatewayOpts(cfg *config.BaseConfig, endPointFunc func(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) (err error)) ([]gateway.Option, error) {
 	return []gateway.Option{
 		gateway.WithGatewayOptions(
-			runtime.WithMetadata(func(ctx context.Context, request *http.Request) metadata.MD {
-				md := make(map[string]string)
-				if method, ok := runtime.RPCMethod(ctx); ok {
-					md["method"] = method
-					request.Header.Add("x-grpc-handler-method", method)
-				}
-				if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
-					md["pattern"] = pattern
-					request.Header.Add("x-http-path", pattern)
-				}
-				return metadata.New(md)
-			}),
+			runtime.WithMetadata(gatewayMetadata),
 			runtime.WithForwardResponseOption(forwardResponseOption),
 			runtime.WithIncomingHeaderMatcher(gateway.AtlasDefaultHeaderMatcher()),
 			runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
@@ -190,9 +212,31 @@ type BaseApp struct {
 	logger      *zap.Logger
 	tracer      *traceSdk.TracerProvider
 	db          *gorm.DB
+	replicas    *db.ReplicaSet
 	ctx         context.Context
 	grpcMetrics *grpcPrometheus.ServerMetrics
 	appTokens   []string
+	migrations  fs.FS
+	migrateDir  string
+	tenantCfg   config.TenantConfig
+	loaderOpts  []db.LoaderOption
+}
+
+type BaseAppOption func(a *BaseApp)
+
+// WithMigrations applies the pending migrations found in dir of fsys on startup, see migrate.Load.
+func WithMigrations(fsys fs.FS, dir string) BaseAppOption {
+	return func(a *BaseApp) {
+		a.migrations = fsys
+		a.migrateDir = dir
+	}
+}
+
+// WithLoaderOptions configures the loaders NewGrpcServer gives every call, see LoaderUnaryInterceptor.
+func WithLoaderOptions(opts ...db.LoaderOption) BaseAppOption {
+	return func(a *BaseApp) {
+		a.loaderOpts = opts
+	}
 }
 
 func (a *BaseApp) GrpcMetrics() *grpcPrometheus.ServerMetrics {
@@ -211,6 +255,16 @@ func (a *BaseApp) Db() *gorm.DB {
 	return a.db
 }
 
+// RunInTx runs fn in a transaction on the app's database, see db.UnitOfWork.
+func (a *BaseApp) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return db.RunInTx(ctx, a.db, fn)
+}
+
+// Replicas returns the app's read replica set, reads fall back to the primary when no replica is configured.
+func (a *BaseApp) Replicas() *db.ReplicaSet {
+	return a.replicas
+}
+
 func (a *BaseApp) Ctx() context.Context {
 	return a.ctx
 }
@@ -262,7 +316,7 @@ func (a *BaseApp) LogMiddleware(next http.Handler) http.Handler {
 		} else {
 			requestId = uuid.New().String()
 		}
-		newCtx := context.WithValue(request.Context(), requestIdCtxKey, requestId)
+		newCtx := util.WithRequestId(request.Context(), requestId)
 		a.logger.Info(requestId,
 			zap.String("method", request.Method),
 			zap.Int("status", cfResponseWriter.Status()),
@@ -272,6 +326,143 @@ func (a *BaseApp) LogMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// TenantMiddleware scopes the request to the tenant in the tenant_id claim of the verified bearer token, see
+// util.WithTenant. A tenant header is only accepted when it names the same tenant as the token, requests
+// without a tenant are rejected when the tenant config requires one. Without a jwt secret in the tenant
+// config the header is trusted as set by an upstream gateway.
+func (a *BaseApp) TenantMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		header := a.tenantCfg.Header
+		if header == "" {
+			header = util.TenantHeader
+		}
+		tenant, err := a.resolveTenant(request.Header.Get("Authorization"), request.Header.Get(header))
+		if err != nil {
+			a.WriteResp(request.Context(), err, http.StatusForbidden, writer)
+			return
+		}
+		if tenant == "" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+		next.ServeHTTP(writer, request.WithContext(util.WithTenant(request.Context(), tenant)))
+	})
+}
+
+// TenantUnaryInterceptor scopes gRPC calls to the tenant of the bearer token in the authorization metadata,
+// the tenant forwarded by the gateway is only accepted when it matches the token, see TenantMiddleware.
+func (a *BaseApp) TenantUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		tenant, err := a.resolveTenant(metadataValue(ctx, "authorization"), metadataValue(ctx, tenantMdKey))
+		if err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if tenant != "" {
+			ctx = util.WithTenant(ctx, tenant)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RequestIdUnaryInterceptor sets the correlation id of gRPC calls from the metadata forwarded by the gateway or
+// sent by the caller, generating one when missing like LogMiddleware, see util.WithRequestId.
+func (a *BaseApp) RequestIdUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		requestId := metadataValue(ctx, requestIdMdKey)
+		if requestId == "" {
+			requestId = uuid.New().String()
+		}
+		return handler(util.WithRequestId(ctx, requestId), req)
+	}
+}
+
+// ActorMiddleware sets the actor of the request, recorded in audit entries, to the user of the verified
+// bearer token, see util.WithActor.
+func (a *BaseApp) ActorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if claims, ok := a.bearerClaims(request.Header.Get("Authorization")); ok && claims.ExternalId != "" {
+			request = request.WithContext(util.WithActor(request.Context(), claims.ExternalId))
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// ActorUnaryInterceptor sets the actor of gRPC calls to the user of the bearer token in the authorization
+// metadata, see ActorMiddleware.
+func (a *BaseApp) ActorUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if claims, ok := a.bearerClaims(metadataValue(ctx, "authorization")); ok && claims.ExternalId != "" {
+			ctx = util.WithActor(ctx, claims.ExternalId)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func metadataValue(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// resolveTenant returns the tenant claimed by the bearer token, failing when claimed names another tenant or
+// when a tenant is required and the token has none. Without a jwt secret claimed is only returned when the
+// tenant config trusts the tenant header, it is ignored otherwise.
+func (a *BaseApp) resolveTenant(authorization, claimed string) (string, error) {
+	tenant := ""
+	if a.tenantCfg.JwtSecret == "" && a.tenantCfg.TrustTenantHeader {
+		tenant = claimed
+	}
+	if a.tenantCfg.JwtSecret != "" {
+		tenant = ""
+		if claims, ok := a.bearerClaims(authorization); ok {
+			tenant = claims.TenantId
+		}
+		if claimed != "" && claimed != tenant {
+			return "", errTenantMismatch
+		}
+	}
+	if tenant == "" && a.tenantCfg.Required {
+		return "", db.ErrMissingTenant
+	}
+	return tenant, nil
+}
+
+// bearerClaims verifies the bearer token of an authorization header with the tenant config's jwt secret.
+func (a *BaseApp) bearerClaims(authorization string) (*util.Claims, bool) {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if a.tenantCfg.JwtSecret == "" || token == "" || token == authorization {
+		return nil, false
+	}
+	return util.ValidateTokenExpiry(a.tenantCfg.JwtSecret, token)
+}
+
+// LoaderUnaryInterceptor gives every gRPC call its own entity loaders, so that FindByExternalId lookups made
+// while handling the call are batched and cached until it returns, see db.WithLoaders.
+func (a *BaseApp) LoaderUnaryInterceptor(opts ...db.LoaderOption) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(db.WithLoaders(ctx, opts...), req)
+	}
+}
+
+// NewGrpcServer creates a gRPC server whose calls carry the correlation id, tenant and actor of the request like
+// requests served through the gateway and get their own entity loaders, the app's interceptors run after any
+// interceptor set in opts.
+func (a *BaseApp) NewGrpcServer(opts ...grpc.ServerOption) *grpc.Server {
+	return grpc.NewServer(append(opts, grpc.ChainUnaryInterceptor(a.unaryInterceptors()...))...)
+}
+
+func (a *BaseApp) unaryInterceptors() []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		a.RequestIdUnaryInterceptor(),
+		a.TenantUnaryInterceptor(),
+		a.ActorUnaryInterceptor(),
+		a.LoaderUnaryInterceptor(a.loaderOpts...),
+	}
+}
+
 func (a *BaseApp) CommonMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
@@ -334,13 +525,32 @@ func (a *BaseApp) HandlerWithMetrics(next http.Handler) http.Handler {
 	})
 }
 
-func getDbDSN(cfg *config.BaseConfig) string {
-	dCfg := cfg.DatabaseConfig
-	return fmt.Sprintf("%v:%v@(%v:%v)/%v?parseTime=true",
-		dCfg.UserName, dCfg.Password, dCfg.HostName, dCfg.Port, dCfg.DatabaseName)
+func newReplicaSet(cfg *config.BaseConfig, primary *gorm.DB, traceProvider *traceSdk.TracerProvider, zapLogger *zap.Logger) (*db.ReplicaSet, error) {
+	var replicas []*gorm.DB
+	for _, rCfg := range cfg.DatabaseConfig.Replicas {
+		replica, err := db.NewGormReplicaConn(cfg.DatabaseConfig.ForReplica(rCfg), traceProvider, db.WithConnLogger(zapLogger))
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+	return db.NewReplicaSet(primary, replicas, cfg.DatabaseConfig.HealthCheckInterval), nil
 }
 
-func NewBaseApp(cfg *config.BaseConfig) (*BaseApp, error) {
+func (a *BaseApp) migrate() error {
+	migrator, err := migrate.NewFromFS(a.db, a.migrations, a.migrateDir)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(a.ctx)
+	if err != nil {
+		return err
+	}
+	a.logger.Info("applied migrations", zap.Int("count", applied))
+	return nil
+}
+
+func NewBaseApp(cfg *config.BaseConfig, opts ...BaseAppOption) (*BaseApp, error) {
 
 	// Initialize Logger
 	zapLogger, err := logger.InitLogger()
@@ -351,6 +561,7 @@ func NewBaseApp(cfg *config.BaseConfig) (*BaseApp, error) {
 	grpcMetrics := grpcPrometheus.NewServerMetrics()
 	prometheus.DefaultRegisterer.Register(grpcMetrics)
 	prometheus.DefaultRegisterer.Register(collectors.NewGoCollector())
+	monitoring.InitDb()
 
 	// Initialize Trace Provider connection
 	traceProvider, err := tracing.NewTracer(cfg.TraceProviderUrl)
@@ -362,20 +573,39 @@ func NewBaseApp(cfg *config.BaseConfig) (*BaseApp, error) {
 	// Initialize context
 	ctx := context.Background()
 
-	database, err := db.NewGormDbConn(cfg.ServerConfig.MetricsPort, cfg.DatabaseConfig.DatabaseName, getDbDSN(cfg), traceProvider)
+	database, err := db.NewGormDbConnForConfig(cfg.ServerConfig.MetricsPort, cfg.DatabaseConfig, traceProvider, db.WithConnLogger(zapLogger))
 	if err != nil {
 		zapLogger.Error("failed to initialize app due to db connection", zap.Error(err))
 		return nil, err
 	}
 
-	return &BaseApp{
+	replicas, err := newReplicaSet(cfg, database, traceProvider, zapLogger)
+	if err != nil {
+		zapLogger.Error("failed to initialize app due to db replica connection", zap.Error(err))
+		return nil, err
+	}
+
+	app := &BaseApp{
 		logger:      zapLogger,
 		appTokens:   cfg.AppTokens,
 		ctx:         ctx,
 		db:          database,
+		replicas:    replicas,
 		tracer:      traceProvider,
 		grpcMetrics: grpcMetrics,
-	}, nil
+		tenantCfg:   cfg.TenantConfig,
+	}
+	for _, opt := range opts {
+		opt(app)
+	}
+
+	if app.migrations != nil {
+		if err := app.migrate(); err != nil {
+			zapLogger.Error("failed to initialize app due to migrations", zap.Error(err))
+			return nil, err
+		}
+	}
+	return app, nil
 }
 
 func forwardResponseOption(ctx context.Context, w http.ResponseWriter, resp protoreflect.ProtoMessage) error {
@@ -399,6 +629,8 @@ func forwardResponseOption(ctx context.Context, w http.ResponseWriter, resp prot
 	return nil
 }
 
+// ServeExternal serves grpcServer and its gateway, create grpcServer with app.NewGrpcServer so that its calls
+// carry the correlation id, tenant and actor forwarded by the gateway.
 func ServeExternal(cfg *config.BaseConfig, app *BaseApp, grpcServer *grpc.Server, endPointFunc func(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) (err error)) error {
 	gatewayOpts, err := GatewayOpts(cfg, endPointFunc)
 	if err != nil {
@@ -420,7 +652,7 @@ func ServeExternal(cfg *config.BaseConfig, app *BaseApp, grpcServer *grpc.Server
 			w.Write([]byte("{\"status\": \"ok\"}"))
 		})),
 		// register middlewares
-		server.WithMiddlewares(app.LogMiddleware, app.RequestLoggerMiddleware, app.CommonMiddleware),
+		server.WithMiddlewares(app.LogMiddleware, app.TenantMiddleware, app.ActorMiddleware, app.RequestLoggerMiddleware, app.CommonMiddleware),
 	)
 	if err != nil {
 		return err
@@ -0,0 +1,271 @@
+package go_commons
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/config"
+	"github.com/byteintellect/go_commons/db"
+	"github.com/byteintellect/go_commons/util"
+	"github.com/byteintellect/protos_go/users/v1"
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gLogger "gorm.io/gorm/logger"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testJwtSecret = "secret"
+
+func testToken(t *testing.T, tenant string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &util.Claims{TenantId: tenant}).SignedString([]byte(testJwtSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+// callHandler is the implementation of the test service, called with the context of every call.
+type callHandler func(ctx context.Context) error
+
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: "test.Test",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Call",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(emptypb.Empty)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return new(emptypb.Empty), srv.(callHandler)(ctx)
+			}
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Test/Call"}, handler)
+		},
+	}},
+}
+
+// callServer serves handler from a server created by app.NewGrpcServer and calls it once with md.
+func callServer(t *testing.T, app *BaseApp, md metadata.MD, handler callHandler) error {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := app.NewGrpcServer()
+	server.RegisterService(&testServiceDesc, handler)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := metadata.NewOutgoingContext(context.Background(), md)
+	return conn.Invoke(ctx, "/test.Test/Call", new(emptypb.Empty), new(emptypb.Empty))
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           config.TenantConfig
+		authorization string
+		header        string
+		status        int
+		tenant        string
+	}{
+		{name: "untrusted header", header: "acme", status: http.StatusOK},
+		{name: "untrusted header required", cfg: config.TenantConfig{Required: true}, header: "acme", status: http.StatusForbidden},
+		{name: "trusted header", cfg: config.TenantConfig{TrustTenantHeader: true}, header: "acme", status: http.StatusOK, tenant: "acme"},
+		{name: "no tenant", status: http.StatusOK},
+		{name: "required without tenant", cfg: config.TenantConfig{Required: true}, status: http.StatusForbidden},
+		{name: "token", cfg: config.TenantConfig{JwtSecret: testJwtSecret}, authorization: testToken(t, "acme"), status: http.StatusOK, tenant: "acme"},
+		{name: "matching header", cfg: config.TenantConfig{JwtSecret: testJwtSecret}, authorization: testToken(t, "acme"), header: "acme", status: http.StatusOK, tenant: "acme"},
+		{name: "mismatching header", cfg: config.TenantConfig{JwtSecret: testJwtSecret}, authorization: testToken(t, "acme"), header: "globex", status: http.StatusForbidden},
+		{name: "header without token", cfg: config.TenantConfig{JwtSecret: testJwtSecret}, header: "acme", status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := &BaseApp{logger: zap.NewNop(), tenantCfg: test.cfg}
+			var tenant string
+			handler := app.TenantMiddleware(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				tenant = util.Tenant(request.Context())
+			}))
+			request := httptest.NewRequest(http.MethodGet, "/widgets", nil)
+			request = request.WithContext(util.WithRequestId(request.Context(), "request"))
+			if test.authorization != "" {
+				request.Header.Set("Authorization", test.authorization)
+			}
+			if test.header != "" {
+				request.Header.Set(util.TenantHeader, test.header)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status || tenant != test.tenant {
+				t.Fatalf("got status %v and tenant %q, expected %v and %q", recorder.Code, tenant, test.status, test.tenant)
+			}
+		})
+	}
+}
+
+func TestGrpcServerScopesCallsToTenant(t *testing.T) {
+	app := &BaseApp{logger: zap.NewNop(), tenantCfg: config.TenantConfig{JwtSecret: testJwtSecret}}
+	var tenant string
+	err := callServer(t, app, metadata.Pairs("authorization", testToken(t, "acme")), func(ctx context.Context) error {
+		tenant = util.Tenant(ctx)
+		return nil
+	})
+	if err != nil || tenant != "acme" {
+		t.Fatalf("call scoped to %q: %v", tenant, err)
+	}
+
+	err = callServer(t, app, metadata.Pairs("authorization", testToken(t, "acme"), tenantMdKey, "globex"), func(ctx context.Context) error {
+		return errors.New("handler called")
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a foreign tenant to be denied, got %v", err)
+	}
+}
+
+func TestGrpcServerTrustsTenantMetadataOnlyWhenConfigured(t *testing.T) {
+	for _, trusted := range []bool{false, true} {
+		app := &BaseApp{logger: zap.NewNop(), tenantCfg: config.TenantConfig{TrustTenantHeader: trusted}}
+		tenant := "unset"
+		err := callServer(t, app, metadata.Pairs(tenantMdKey, "acme"), func(ctx context.Context) error {
+			tenant = util.Tenant(ctx)
+			return nil
+		})
+		expected := ""
+		if trusted {
+			expected = "acme"
+		}
+		if err != nil || tenant != expected {
+			t.Fatalf("call scoped to %q with a trusted header %v, expected %q: %v", tenant, trusted, expected, err)
+		}
+	}
+}
+
+func TestGatewayForwardsRequestId(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/widgets", nil)
+	request = request.WithContext(util.WithTenant(util.WithRequestId(request.Context(), "request-1"), "acme"))
+	md := gatewayMetadata(request.Context(), request)
+	if requestId := md.Get(requestIdMdKey); len(requestId) != 1 || requestId[0] != "request-1" {
+		t.Fatalf("forwarded request id %v, expected request-1", requestId)
+	}
+	if tenant := md.Get(tenantMdKey); len(tenant) != 1 || tenant[0] != "acme" {
+		t.Fatalf("forwarded tenant %v, expected acme", tenant)
+	}
+}
+
+func TestAuditEntriesOfGrpcCallsCarryRequest(t *testing.T) {
+	database, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: gLogger.Default.LogMode(gLogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb, err := database.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would open its own in memory database
+	sqlDb.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		sqlDb.Close()
+	})
+	audit := db.NewAuditLog(database)
+	if err := audit.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &util.Claims{UserDto: usersv1.UserDto{ExternalId: "user-1"}}).
+		SignedString([]byte(testJwtSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &BaseApp{logger: zap.NewNop(), tenantCfg: config.TenantConfig{JwtSecret: testJwtSecret}}
+	record := func(ctx context.Context) error {
+		return audit.Record(ctx, &db.AuditEntry{EntityType: "widgets", EntityId: "widget-1", Action: db.AuditCreate})
+	}
+	md := metadata.Pairs(requestIdMdKey, "request-1", "authorization", "Bearer "+token)
+	if err := callServer(t, app, md, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := callServer(t, app, metadata.MD{}, record); err != nil {
+		t.Fatal(err)
+	}
+
+	err, entries := audit.History(context.Background(), "widgets", "widget-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", entries)
+	}
+	if entries[0].RequestId != "request-1" || entries[0].Actor != "user-1" {
+		t.Fatalf("recorded request id %q and actor %q, expected request-1 and user-1", entries[0].RequestId, entries[0].Actor)
+	}
+	// calls without a correlation id get a generated one
+	if entries[1].RequestId == "" || entries[1].RequestId == "request-1" {
+		t.Fatalf("recorded request id %q for a call without one", entries[1].RequestId)
+	}
+}
+
+func TestSqlLogsOfGrpcCallsCarryRequestId(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	database, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: db.NewZapGormLogger(zap.New(core), gLogger.Info, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb, err := database.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDb.Close()
+	})
+	app := &BaseApp{logger: zap.NewNop()}
+	err = callServer(t, app, metadata.Pairs(requestIdMdKey, "request-1"), func(ctx context.Context) error {
+		return database.WithContext(ctx).Exec("SELECT 1").Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.FilterMessage("sql").AllUntimed()
+	if len(entries) != 1 || entries[0].ContextMap()["request_id"] != "request-1" {
+		t.Fatalf("expected the statement to be logged with request-1, got %+v", entries)
+	}
+}
+
+func TestGrpcCallsGetTheirOwnLoaders(t *testing.T) {
+	app := &BaseApp{logger: zap.NewNop()}
+	repo := db.NewGORMRepository()
+	var loaders []*db.Loader
+	handler := func(ctx context.Context) error {
+		loader := db.LoaderFor(ctx, repo)
+		if loader == nil {
+			return errors.New("no loaders")
+		}
+		loaders = append(loaders, loader)
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := callServer(t, app, metadata.MD{}, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loaders[0] == loaders[1] {
+		t.Fatal("calls shared a loader")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/byteintellect/go_commons/entity"
+	"github.com/byteintellect/go_commons/util"
 	"github.com/go-redis/redis/extra/redisotel/v8"
 	"github.com/go-redis/redis/v8"
 	traceSdk "go.opentelemetry.io/otel/sdk/trace"
@@ -17,13 +18,29 @@ type RedisCache struct {
 	entityCreator entity.EntityCreator
 }
 
+// key namespaces externalId with the tenant in ctx, if any.
+func (r *RedisCache) key(ctx context.Context, externalId string) string {
+	if tenant := util.Tenant(ctx); tenant != "" {
+		return "tenant:" + tenant + ":" + externalId
+	}
+	return externalId
+}
+
+func (r *RedisCache) keys(ctx context.Context, externalIds []string) []string {
+	keys := make([]string, 0, len(externalIds))
+	for _, externalId := range externalIds {
+		keys = append(keys, r.key(ctx, externalId))
+	}
+	return keys
+}
+
 func (r *RedisCache) Put(ctx context.Context, base entity.Base) error {
-	cmd := r.Client.Set(ctx, base.GetExternalId(), base, 0)
+	cmd := r.Client.Set(ctx, r.key(ctx, base.GetExternalId()), base, 0)
 	return cmd.Err()
 }
 
 func (r *RedisCache) Get(ctx context.Context, externalId string) (entity.Base, error) {
-	cmd := r.Client.Get(ctx, externalId)
+	cmd := r.Client.Get(ctx, r.key(ctx, externalId))
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
@@ -49,7 +66,7 @@ func (r *RedisCache) MultiGet(ctx context.Context, externalIds []string) ([]enti
 }
 
 func (r *RedisCache) Delete(ctx context.Context, externalId string) error {
-	statusCmd := r.Client.Del(ctx, externalId)
+	statusCmd := r.Client.Del(ctx, r.key(ctx, externalId))
 	if statusCmd.Err() != nil {
 		return statusCmd.Err()
 	}
@@ -57,7 +74,7 @@ func (r *RedisCache) Delete(ctx context.Context, externalId string) error {
 }
 
 func (r *RedisCache) MultiDelete(ctx context.Context, externalIds []string) error {
-	statusCmd := r.Client.Del(ctx, externalIds...)
+	statusCmd := r.Client.Del(ctx, r.keys(ctx, externalIds)...)
 	if statusCmd.Err() != nil {
 		return statusCmd.Err()
 	}
@@ -65,14 +82,24 @@ func (r *RedisCache) MultiDelete(ctx context.Context, externalIds []string) erro
 }
 
 func (r *RedisCache) PutWithTtl(ctx context.Context, base entity.Base, duration time.Duration) error {
-	statusCmd := r.Client.Set(ctx, base.GetExternalId(), base, duration)
+	statusCmd := r.Client.Set(ctx, r.key(ctx, base.GetExternalId()), base, duration)
 	if statusCmd.Err() != nil {
 		return statusCmd.Err()
 	}
 	return nil
 }
 
+// DeleteAll flushes the database, or only the keys of the tenant in ctx.
 func (r *RedisCache) DeleteAll(ctx context.Context) error {
+	if util.Tenant(ctx) != "" {
+		iter := r.Client.Scan(ctx, 0, r.key(ctx, "*"), 0).Iterator()
+		for iter.Next(ctx) {
+			if err := r.Client.Del(ctx, iter.Val()).Err(); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
 	cmd := r.Client.FlushDB(ctx)
 	if cmd.Err() != nil {
 		return cmd.Err()
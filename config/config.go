@@ -5,6 +5,7 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	"gopkg.in/yaml.v3"
 	"os"
+	"time"
 )
 
 type BaseConfig struct {
@@ -15,6 +16,17 @@ type BaseConfig struct {
 	DatabaseConfig   DatabaseConfig `json:"database_config" yaml:"database_config"`
 	LogLevel         string         `yaml:"log_level" json:"log_level"`
 	TraceProviderUrl string         `yaml:"trace_provider_url" json:"trace_provider_url"`
+	TenantConfig     TenantConfig   `yaml:"tenant_config" json:"tenant_config"`
+}
+
+// TenantConfig controls how BaseApp.TenantMiddleware resolves the tenant of a request. With a JwtSecret the
+// tenant is the one of the bearer token. Without one the tenant header is ignored, any caller could name any
+// tenant with it, unless TrustTenantHeader is set for services only reachable through a gateway that sets it.
+type TenantConfig struct {
+	Header            string `yaml:"header" json:"header"` // defaults to X-TENANT-ID, must match the token when sent
+	JwtSecret         string `yaml:"jwt_secret" json:"jwt_secret" envconfig:"TENANT_JWT_SECRET"`
+	TrustTenantHeader bool   `yaml:"trust_tenant_header" json:"trust_tenant_header"`
+	Required          bool   `yaml:"required" json:"required"`
 }
 
 type ServerConfig struct {
@@ -35,12 +47,59 @@ type GatewayConfig struct {
 }
 
 type DatabaseConfig struct {
-	Type         string `yaml:"type" json:"type"`
-	HostName     string `yaml:"host_name" json:"host_name"`
-	Port         string `yaml:"port" json:"port"`
-	UserName     string `yaml:"user_name" json:"user_name"`
-	DatabaseName string `yaml:"database_name" json:"database_name"`
-	Password     string `yaml:"password" json:"password" envconfig:"DATABASE_PASSWORD"`
+	Type                string          `yaml:"type" json:"type"`
+	HostName            string          `yaml:"host_name" json:"host_name"`
+	Port                string          `yaml:"port" json:"port"`
+	UserName            string          `yaml:"user_name" json:"user_name"`
+	DatabaseName        string          `yaml:"database_name" json:"database_name"`
+	Password            string          `yaml:"password" json:"password" envconfig:"DATABASE_PASSWORD"`
+	Replicas            []ReplicaConfig `yaml:"replicas" json:"replicas"`
+	HealthCheckInterval time.Duration   `yaml:"health_check_interval" json:"health_check_interval"`
+
+	// connection pool, zero values keep the defaults of 100 open and idle connections idling for at most an hour
+	MaxOpenConns    int           `yaml:"max_open_conns" json:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" json:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" json:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" json:"conn_max_idle_time"`
+
+	// ConnectTimeout applies to MySQL and PostgreSQL, read and write timeouts to MySQL only
+	ConnectTimeout time.Duration `yaml:"connect_timeout" json:"connect_timeout"`
+	ReadTimeout    time.Duration `yaml:"read_timeout" json:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout" json:"write_timeout"`
+	TLS            TLSConfig     `yaml:"tls" json:"tls"`
+
+	// LogLevel is one of silent, error, warn or info (the default), slow queries are logged as warnings
+	LogLevel      string        `yaml:"log_level" json:"log_level"`
+	SlowThreshold time.Duration `yaml:"slow_threshold" json:"slow_threshold"`
+}
+
+type TLSConfig struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// CACertFile verifies the server certificate, CertFile and KeyFile authenticate the client
+	CACertFile         string `yaml:"ca_cert_file" json:"ca_cert_file"`
+	CertFile           string `yaml:"cert_file" json:"cert_file"`
+	KeyFile            string `yaml:"key_file" json:"key_file"`
+	ServerName         string `yaml:"server_name" json:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+}
+
+// ReplicaConfig describes a read replica, empty credentials default to the primary's.
+type ReplicaConfig struct {
+	HostName string `yaml:"host_name" json:"host_name"`
+	Port     string `yaml:"port" json:"port"`
+	UserName string `yaml:"user_name" json:"user_name"`
+	Password string `yaml:"password" json:"password"`
+}
+
+// ForReplica returns the config of a read replica, inheriting the primary's settings and credentials.
+func (d DatabaseConfig) ForReplica(replica ReplicaConfig) DatabaseConfig {
+	rCfg := d
+	rCfg.HostName, rCfg.Port = replica.HostName, replica.Port
+	if replica.UserName != "" {
+		rCfg.UserName, rCfg.Password = replica.UserName, replica.Password
+	}
+	rCfg.Replicas = nil
+	return rCfg
 }
 
 func ReadFile(filePath string, cfg interface{}) error {
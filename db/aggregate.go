@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"regexp"
+	"strconv"
+)
+
+type AggregateFunc string
+
+const (
+	AggCount AggregateFunc = "count"
+	AggSum   AggregateFunc = "sum"
+	AggMin   AggregateFunc = "min"
+	AggMax   AggregateFunc = "max"
+	AggAvg   AggregateFunc = "avg"
+)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Aggregation computes Func over Field, its result is keyed by Alias in AggregateRow.Values.
+type Aggregation struct {
+	Func  AggregateFunc
+	Field string
+	Alias string
+}
+
+func CountOf() Aggregation {
+	return Aggregation{Func: AggCount, Alias: string(AggCount)}
+}
+
+func SumOf(field string) Aggregation {
+	return Aggregation{Func: AggSum, Field: field, Alias: "sum_" + field}
+}
+
+func MinOf(field string) Aggregation {
+	return Aggregation{Func: AggMin, Field: field, Alias: "min_" + field}
+}
+
+func MaxOf(field string) Aggregation {
+	return Aggregation{Func: AggMax, Field: field, Alias: "max_" + field}
+}
+
+func AvgOf(field string) Aggregation {
+	return Aggregation{Func: AggAvg, Field: field, Alias: "avg_" + field}
+}
+
+// As renames the aggregation's result.
+func (a Aggregation) As(alias string) Aggregation {
+	a.Alias = alias
+	return a
+}
+
+// AggregateQuery computes Aggregations over the entities matching Conditions, one row per distinct
+// combination of the GroupBy fields, or a single row without GroupBy.
+type AggregateQuery struct {
+	Conditions   []Condition
+	GroupBy      []string
+	Aggregations []Aggregation
+}
+
+func NewAggregateQuery(aggregations ...Aggregation) *AggregateQuery {
+	return &AggregateQuery{Aggregations: aggregations}
+}
+
+func (q *AggregateQuery) Where(conditions ...Condition) *AggregateQuery {
+	q.Conditions = append(q.Conditions, conditions...)
+	return q
+}
+
+func (q *AggregateQuery) By(fields ...string) *AggregateQuery {
+	q.GroupBy = append(q.GroupBy, fields...)
+	return q
+}
+
+// Fields returns every field referenced by the query.
+func (q *AggregateQuery) Fields() []string {
+	fields := append([]string{}, q.GroupBy...)
+	for _, condition := range q.Conditions {
+		fields = append(fields, condition.Fields()...)
+	}
+	for _, aggregation := range q.Aggregations {
+		if aggregation.Field != "" {
+			fields = append(fields, aggregation.Field)
+		}
+	}
+	return fields
+}
+
+func (q *AggregateQuery) Validate() error {
+	if len(q.Aggregations) == 0 {
+		return fmt.Errorf("at least one aggregation is required")
+	}
+	for _, condition := range q.Conditions {
+		if err := condition.Validate(); err != nil {
+			return err
+		}
+	}
+	aliases := make(map[string]bool)
+	for _, field := range q.GroupBy {
+		aliases[field] = true
+	}
+	for _, aggregation := range q.Aggregations {
+		switch aggregation.Func {
+		case AggCount:
+		case AggSum, AggMin, AggMax, AggAvg:
+			if aggregation.Field == "" {
+				return fmt.Errorf("%v requires a field", aggregation.Func)
+			}
+		default:
+			return fmt.Errorf("unsupported aggregate function %v", aggregation.Func)
+		}
+		if !aliasPattern.MatchString(aggregation.Alias) {
+			return fmt.Errorf("invalid aggregation alias %v", aggregation.Alias)
+		}
+		if aliases[aggregation.Alias] {
+			return fmt.Errorf("duplicate aggregation alias %v", aggregation.Alias)
+		}
+		aliases[aggregation.Alias] = true
+	}
+	return nil
+}
+
+// AggregateRow holds the values of the GroupBy fields and the aggregation results, keyed by alias.
+// Min, max and avg over an empty group are reported as 0.
+type AggregateRow struct {
+	Group  map[string]interface{}
+	Values map[string]float64
+}
+
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("unexpected aggregate value %v of type %T", value, value)
+	}
+}
+
+// Count returns the number of entities matching conditions.
+func (r *GORMRepository) Count(ctx context.Context, conditions ...Condition) (error, int64) {
+	return retryRead(ctx, r.retryPolicy, "count", func(ctx context.Context) (error, int64) {
+		base := r.creator()
+		if err := r.checkConditions(base, conditions); err != nil {
+			return err, 0
+		}
+		var total int64
+		if err := r.filtered(ctx, base, conditions).Count(&total).Error; err != nil {
+			return err, 0
+		}
+		return nil, total
+	})
+}
+
+// Exists reports whether an entity matches conditions.
+func (r *GORMRepository) Exists(ctx context.Context, conditions ...Condition) (error, bool) {
+	return retryRead(ctx, r.retryPolicy, "exists", func(ctx context.Context) (error, bool) {
+		base := r.creator()
+		if err := r.checkConditions(base, conditions); err != nil {
+			return err, false
+		}
+		var ids []uint64
+		if err := r.filtered(ctx, base, conditions).Limit(1).Pluck("id", &ids).Error; err != nil {
+			return err, false
+		}
+		return nil, len(ids) > 0
+	})
+}
+
+func (r *GORMRepository) Aggregate(ctx context.Context, query *AggregateQuery) (error, []AggregateRow) {
+	return retryRead(ctx, r.retryPolicy, "aggregate", func(ctx context.Context) (error, []AggregateRow) {
+		if err := query.Validate(); err != nil {
+			return err, nil
+		}
+		base := r.creator()
+		if err := r.checkColumns(base, query.Fields()); err != nil {
+			return err, nil
+		}
+		tx := r.filtered(ctx, base, query.Conditions)
+		var selects []string
+		for _, field := range query.GroupBy {
+			selects = append(selects, tx.Statement.Quote(field))
+		}
+		for _, aggregation := range query.Aggregations {
+			expr := "COUNT(*)"
+			if aggregation.Func != AggCount {
+				expr = fmt.Sprintf("%v(%v)", aggregation.Func, tx.Statement.Quote(aggregation.Field))
+			}
+			selects = append(selects, fmt.Sprintf("%v AS %v", expr, tx.Statement.Quote(aggregation.Alias)))
+		}
+		tx = tx.Select(selects)
+		for _, field := range query.GroupBy {
+			// Group quotes the column itself
+			tx = tx.Group(field)
+		}
+		var results []map[string]interface{}
+		if err := tx.Find(&results).Error; err != nil {
+			return err, nil
+		}
+		var rows []AggregateRow
+		for _, result := range results {
+			row := AggregateRow{Group: make(map[string]interface{}), Values: make(map[string]float64)}
+			for _, field := range query.GroupBy {
+				row.Group[field] = result[field]
+			}
+			for _, aggregation := range query.Aggregations {
+				value, err := toFloat(result[aggregation.Alias])
+				if err != nil {
+					return err, nil
+				}
+				row.Values[aggregation.Alias] = value
+			}
+			rows = append(rows, row)
+		}
+		return nil, rows
+	})
+}
+
+// checkConditions validates conditions and checks they only reference searchable columns.
+func (r *GORMRepository) checkConditions(base entity.Base, conditions []Condition) error {
+	var fields []string
+	for _, condition := range conditions {
+		if err := condition.Validate(); err != nil {
+			return err
+		}
+		fields = append(fields, condition.Fields()...)
+	}
+	return r.checkColumns(base, fields)
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/util"
+	"sort"
+	"testing"
+)
+
+// seedWidgets creates the widgets of acme and globex and soft deletes one widget of acme.
+func seedWidgets(t *testing.T, repo *GORMRepository) {
+	t.Helper()
+	seeds := []struct {
+		tenant   string
+		name     string
+		quantity int
+	}{
+		{"acme", "bolt", 2},
+		{"acme", "bolt", 4},
+		{"acme", "nut", 10},
+		{"acme", "washer", 100},
+		{"globex", "bolt", 1000},
+	}
+	for _, seed := range seeds {
+		err, base := repo.Create(tenantCtx(seed.tenant), &widget{Name: seed.name, Quantity: seed.quantity})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seed.name == "washer" {
+			if err := repo.SoftDelete(tenantCtx(seed.tenant), base.GetExternalId()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestCountAndExists(t *testing.T) {
+	repo := newTestRepo(t, WithTenantColumn("tenant_id"))
+	seedWidgets(t, repo)
+	ctx := tenantCtx("acme")
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		conditions []Condition
+		count      int64
+	}{
+		{name: "tenant", ctx: ctx, count: 3},
+		{name: "conditions", ctx: ctx, conditions: []Condition{Eq("name", "bolt")}, count: 2},
+		{name: "no match", ctx: ctx, conditions: []Condition{Gt("quantity", 50)}, count: 0},
+		{name: "soft deleted", ctx: ctx, conditions: []Condition{Eq("name", "washer")}, count: 0},
+		{name: "other tenant", ctx: tenantCtx("globex"), conditions: []Condition{Eq("name", "bolt")}, count: 1},
+		{name: "all tenants", ctx: util.WithAllTenants(context.Background()), conditions: []Condition{Eq("name", "bolt")}, count: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, count := repo.Count(test.ctx, test.conditions...)
+			if err != nil || count != test.count {
+				t.Fatalf("counted %v, expected %v: %v", count, test.count, err)
+			}
+			err, exists := repo.Exists(test.ctx, test.conditions...)
+			if err != nil || exists != (test.count > 0) {
+				t.Fatalf("exists reported %v for %v entities: %v", exists, test.count, err)
+			}
+		})
+	}
+
+	if err, _ := repo.Count(context.Background()); !errors.Is(err, ErrMissingTenant) {
+		t.Fatalf("count without a tenant returned %v, expected ErrMissingTenant", err)
+	}
+	if err, _ := repo.Exists(ctx, Eq("secret", "x")); err == nil {
+		t.Fatal("expected an unknown column to fail")
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	repo := newTestRepo(t, WithTenantColumn("tenant_id"))
+	seedWidgets(t, repo)
+	ctx := tenantCtx("acme")
+
+	err, rows := repo.Aggregate(ctx, NewAggregateQuery(CountOf(), SumOf("quantity"), MinOf("quantity"), MaxOf("quantity"), AvgOf("quantity").As("average")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{"count": 3, "sum_quantity": 16, "min_quantity": 2, "max_quantity": 10, "average": 16.0 / 3}
+	if len(rows) != 1 || !sameValues(rows[0].Values, expected) {
+		t.Fatalf("aggregated %+v, expected %v", rows, expected)
+	}
+
+	err, rows = repo.Aggregate(ctx, NewAggregateQuery(CountOf(), SumOf("quantity")).By("name").Where(Lt("quantity", 50)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Group["name"].(string) < rows[j].Group["name"].(string)
+	})
+	if len(rows) != 2 || rows[0].Group["name"] != "bolt" || rows[1].Group["name"] != "nut" {
+		t.Fatalf("unexpected groups %+v", rows)
+	}
+	if !sameValues(rows[0].Values, map[string]float64{"count": 2, "sum_quantity": 6}) ||
+		!sameValues(rows[1].Values, map[string]float64{"count": 1, "sum_quantity": 10}) {
+		t.Fatalf("unexpected values %+v", rows)
+	}
+
+	// an empty selection still has a row, with a zero count and zero sums
+	err, rows = repo.Aggregate(ctx, NewAggregateQuery(CountOf(), SumOf("quantity")).Where(Eq("name", "washer")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || !sameValues(rows[0].Values, map[string]float64{"count": 0, "sum_quantity": 0}) {
+		t.Fatalf("aggregated soft deleted entities: %+v", rows)
+	}
+
+	if err, _ := repo.Aggregate(ctx, NewAggregateQuery(SumOf("secret"))); err == nil {
+		t.Fatal("expected an unknown column to fail")
+	}
+	if err, _ := repo.Aggregate(ctx, NewAggregateQuery(CountOf().As("name")).By("name")); err == nil {
+		t.Fatal("expected an alias clashing with a group to fail")
+	}
+}
+
+func sameValues(got, expected map[string]float64) bool {
+	if len(got) != len(expected) {
+		return false
+	}
+	for alias, value := range expected {
+		if diff := got[alias] - value; diff > 1e-9 || diff < -1e-9 {
+			return false
+		}
+	}
+	return true
+}
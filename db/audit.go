@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/byteintellect/go_commons/util"
+	"gorm.io/gorm"
+	"reflect"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate     AuditAction = "create"
+	AuditUpdate     AuditAction = "update"
+	AuditSoftDelete AuditAction = "soft_delete"
+	AuditRestore    AuditAction = "restore"
+	AuditHardDelete AuditAction = "hard_delete"
+
+	defaultAuditTable = "audit_entries"
+)
+
+var errAuditDisabled = errors.New("auditing is not enabled for this repository")
+
+// auditIgnoredColumns are maintained by the repository itself and left out of diffs.
+var auditIgnoredColumns = map[string]bool{
+	"updated_at":  true,
+	versionColumn: true,
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// FieldChanges is stored as a JSON document in the audit table.
+type FieldChanges []FieldChange
+
+func (c FieldChanges) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *FieldChanges) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return errors.New("invalid scan source")
+	}
+}
+
+type AuditEntry struct {
+	Id         uint64       `json:"id" gorm:"primaryKey;AUTO_INCREMENT"`
+	EntityType string       `json:"entity_type" gorm:"type:varchar(100);index:idx_audit_entity"`
+	EntityId   string       `json:"entity_id" gorm:"type:varchar(100);index:idx_audit_entity"`
+	Tenant     string       `json:"tenant" gorm:"type:varchar(100)"`
+	Action     AuditAction  `json:"action" gorm:"type:varchar(20)"`
+	Actor      string       `json:"actor" gorm:"type:varchar(255)"`
+	RequestId  string       `json:"request_id" gorm:"type:varchar(100)"`
+	Changes    FieldChanges `json:"changes" gorm:"type:text"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// AuditLog stores AuditEntry rows, writes join the transaction in the context so that an entry is only
+// kept when the change it describes is committed.
+type AuditLog struct {
+	db    *gorm.DB
+	table string
+}
+
+type AuditLogOption func(a *AuditLog)
+
+func WithAuditTable(table string) AuditLogOption {
+	return func(a *AuditLog) {
+		a.table = table
+	}
+}
+
+func NewAuditLog(db *gorm.DB, opts ...AuditLogOption) *AuditLog {
+	a := &AuditLog{
+		db:    db,
+		table: defaultAuditTable,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// AutoMigrate creates the audit table, services managing their schema with db/migrate can create it there instead.
+func (a *AuditLog) AutoMigrate() error {
+	return a.db.Table(a.table).AutoMigrate(&AuditEntry{})
+}
+
+// Record stores entry, the actor and request id are taken from ctx when not set.
+func (a *AuditLog) Record(ctx context.Context, entry *AuditEntry) error {
+	if entry.Actor == "" {
+		entry.Actor = util.Actor(ctx)
+	}
+	if entry.RequestId == "" {
+		entry.RequestId = util.RequestId(ctx)
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	return conn(ctx, a.db).Table(a.table).Create(entry).Error
+}
+
+// History returns the audit entries of an entity, oldest first, restricted further by scopes.
+func (a *AuditLog) History(ctx context.Context, entityType entity.DomainName, externalId string, scopes ...func(*gorm.DB) *gorm.DB) (error, []AuditEntry) {
+	var entries []AuditEntry
+	if err := conn(ctx, a.db).Table(a.table).Scopes(scopes...).
+		Where("entity_type = ? AND entity_id = ?", string(entityType), externalId).
+		Order("id").Find(&entries).Error; err != nil {
+		return err, nil
+	}
+	return nil, entries
+}
+
+// WithAuditLog records an AuditEntry for every Create, Update and delete of the repository.
+func WithAuditLog(audit *AuditLog) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.audit = audit
+	}
+}
+
+// History returns the audit entries of the entity, see WithAuditLog. Entries of a tenant scoped repository
+// are restricted to the tenant in ctx like its entities.
+func (r *GORMRepository) History(ctx context.Context, externalId string) (error, []AuditEntry) {
+	if r.audit == nil {
+		return errAuditDisabled, nil
+	}
+	return r.audit.History(ctx, r.creator().GetTable(), externalId, r.recordTenantScope(ctx))
+}
+
+// snapshot returns the column values of base, or nil when auditing is off.
+func (r *GORMRepository) snapshot(ctx context.Context, base entity.Base) (error, map[string]interface{}) {
+	if r.audit == nil {
+		return nil, nil
+	}
+	err, sch := r.parseSchema(base)
+	if err != nil {
+		return err, nil
+	}
+	values := make(map[string]interface{})
+	value := reflect.Indirect(reflect.ValueOf(base))
+	for _, field := range sch.Fields {
+		if field.DBName == "" || auditIgnoredColumns[field.DBName] {
+			continue
+		}
+		fieldValue, _ := field.ValueOf(ctx, value)
+		values[field.DBName] = normalizeAuditValue(fieldValue)
+	}
+	return nil, values
+}
+
+// diffSnapshots returns the columns whose value differs between before and after, in after's column order.
+func (r *GORMRepository) diffSnapshots(base entity.Base, before, after map[string]interface{}) []FieldChange {
+	if after == nil {
+		return nil
+	}
+	err, sch := r.parseSchema(base)
+	if err != nil {
+		return nil
+	}
+	var changes []FieldChange
+	for _, column := range sch.DBNames {
+		newValue, ok := after[column]
+		if !ok {
+			continue
+		}
+		oldValue := before[column]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: column, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+// normalizeAuditValue dereferences pointers and drops the monotonic clock of times so equal values compare equal.
+func normalizeAuditValue(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	for v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.UTC().Round(0)
+	}
+	return v.Interface()
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/util"
+	"testing"
+)
+
+func TestSoftDeleteAuditsEveryChangedColumn(t *testing.T) {
+	db := newTestDb(t)
+	audit := NewAuditLog(db)
+	if err := audit.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewGORMRepository(WithDb(db), WithCreator(newWidget), WithAuditLog(audit))
+	ctx := util.WithActor(context.Background(), "user-1")
+	err, created := repo.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SoftDelete(ctx, created.GetExternalId()); err != nil {
+		t.Fatal(err)
+	}
+
+	err, entries := repo.History(ctx, created.GetExternalId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Action != AuditSoftDelete {
+		t.Fatalf("expected a create and a soft delete, got %+v", entries)
+	}
+	if actor := entries[1].Actor; actor != "user-1" {
+		t.Fatalf("recorded actor %q, expected user-1", actor)
+	}
+	changed := map[string]bool{}
+	for _, change := range entries[1].Changes {
+		changed[change.Field] = true
+	}
+	if len(changed) != 2 || !changed[deletedAtColumn] || !changed["status"] {
+		t.Fatalf("expected deleted_at and status to change, got %+v", entries[1].Changes)
+	}
+}
+
+func TestCreateAuditsStoredValues(t *testing.T) {
+	db := newTestDb(t)
+	audit := NewAuditLog(db)
+	if err := audit.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewGORMRepository(WithDb(db), WithCreator(newWidget), WithAuditLog(audit))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err, entries := repo.History(ctx, created.GetExternalId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != AuditCreate {
+		t.Fatalf("expected a create, got %+v", entries)
+	}
+	recorded := map[string]FieldChange{}
+	for _, change := range entries[0].Changes {
+		recorded[change.Field] = change
+	}
+	// values decoded from the JSON of the entry
+	if id := recorded["id"]; id.Old != nil || id.New != float64(created.GetId()) {
+		t.Fatalf("recorded id %+v, expected %v", id, created.GetId())
+	}
+	if externalId := recorded["external_id"]; externalId.Old != nil || externalId.New != created.GetExternalId() {
+		t.Fatalf("recorded external id %+v, expected %v", externalId, created.GetExternalId())
+	}
+	if name := recorded["name"]; name.New != "bolt" {
+		t.Fatalf("recorded name %+v", name)
+	}
+}
+
+func TestHistoryIsTenantScoped(t *testing.T) {
+	db := newTestDb(t)
+	audit := NewAuditLog(db)
+	if err := audit.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewGORMRepository(WithDb(db), WithCreator(newWidget), WithAuditLog(audit), WithTenantColumn("tenant_id"))
+	err, created := repo.Create(tenantCtx("acme"), &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalId := created.GetExternalId()
+	allTenants := util.WithAllTenants(context.Background())
+	if err := repo.SoftDelete(allTenants, externalId); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.HardDelete(allTenants, externalId); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes made across tenants are recorded for the entity's tenant
+	if err, entries := repo.History(tenantCtx("acme"), externalId); err != nil || len(entries) != 3 {
+		t.Fatalf("owner got %v entries: %v", len(entries), err)
+	}
+	if err, entries := repo.History(tenantCtx("globex"), externalId); err != nil || len(entries) != 0 {
+		t.Fatalf("another tenant got %v entries: %v", len(entries), err)
+	}
+	if err, _ := repo.History(context.Background(), externalId); !errors.Is(err, ErrMissingTenant) {
+		t.Fatalf("history without a tenant returned %v, expected ErrMissingTenant", err)
+	}
+}
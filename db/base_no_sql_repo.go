@@ -11,5 +11,10 @@ type BaseNoSQLRepo interface {
 	ExactSearch(ctx context.Context, key string, value interface{}) (error, []entity.Base)
 	RangeSearch(ctx context.Context, key string, start, end interface{}) (error, []entity.Base)
 	TextSearch(ctx context.Context, value string) (error, []entity.Base)
+	SearchDSL(ctx context.Context, query ESClause, sort []SortField, from, size int) (error, *SearchResult)
+	FacetSearch(ctx context.Context, search *FacetSearch) (error, *FacetedResult)
 	IndexMappings(ctx context.Context) error
+	MappingDiff(ctx context.Context) (error, []MappingDifference)
+	Reindex(ctx context.Context, opts ...ReindexOption) (error, *ReindexResult)
+	NewBulkIndexer(ctx context.Context, opts ...ESBulkIndexerOption) *ESBulkIndexer
 }
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"github.com/byteintellect/go_commons/entity"
+	"time"
 )
 
 type BaseRepository interface {
@@ -11,10 +12,50 @@ type BaseRepository interface {
 	MultiGetByExternalId(ctx context.Context, externalIds []string) (error, []entity.Base)
 	Create(ctx context.Context, base entity.Base) (error, entity.Base)
 	Update(ctx context.Context, externalId string, updatedBase entity.Base) (error, entity.Base)
+	UpdateFields(ctx context.Context, externalId string, base entity.Base, paths []string) (error, entity.Base)
 	Search(ctx context.Context, params map[string]string) (error, []entity.Base)
+	SearchQuery(ctx context.Context, query *Query) (error, *SearchResult)
+	SearchAfter(ctx context.Context, query *Query, token string) (error, *CursorPage)
+	Count(ctx context.Context, conditions ...Condition) (error, int64)
+	Exists(ctx context.Context, conditions ...Condition) (error, bool)
+	Aggregate(ctx context.Context, query *AggregateQuery) (error, []AggregateRow)
+	Delete(ctx context.Context, externalId string) error
+	SoftDelete(ctx context.Context, externalId string) error
+	Restore(ctx context.Context, externalId string) error
+	HardDelete(ctx context.Context, externalId string) error
 	GetDb() interface{}
 }
 
+// BulkRepository is implemented by repositories that can write many entities per round trip.
+type BulkRepository interface {
+	BulkCreate(ctx context.Context, bases []entity.Base) (error, *BulkResult)
+	Upsert(ctx context.Context, bases []entity.Base) (error, *BulkResult)
+	BatchUpdate(ctx context.Context, bases []entity.Base) (error, *BulkResult)
+}
+
+// StreamingRepository is implemented by repositories that can walk large result sets without loading them in memory.
+type StreamingRepository interface {
+	Iterate(ctx context.Context, query *Query, fn func(base entity.Base) error) error
+}
+
+// TemporalRepository is implemented by repositories keeping a snapshot of every version of their entities.
+type TemporalRepository interface {
+	AsOf(ctx context.Context, externalId string, at time.Time) (error, entity.Base)
+	Versions(ctx context.Context, externalId string) (error, []EntityVersion)
+}
+
+type RowError struct {
+	Index      int
+	ExternalId string
+	Err        error
+}
+
+// BulkResult reports the outcome of a bulk write per row, Index refers to the position in the input.
+type BulkResult struct {
+	Succeeded []entity.Base
+	Failed    []RowError
+}
+
 type BaseDao struct {
 	BaseRepository
 }
@@ -1,9 +1,10 @@
 package db
 
 import (
+	"github.com/byteintellect/go_commons/config"
 	"github.com/byteintellect/gorm-opentelemetry"
 	traceSdk "go.opentelemetry.io/otel/sdk/trace"
-	"gorm.io/driver/mysql"
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 	gLogger "gorm.io/gorm/logger"
 	gProm "gorm.io/plugin/prometheus"
@@ -12,49 +13,135 @@ import (
 	"time"
 )
 
+const (
+	defaultMaxOpenConns    = 100
+	defaultMaxIdleConns    = 100
+	defaultConnMaxIdleTime = time.Hour
+)
+
+type connOptions struct {
+	logger *zap.Logger
+	lazy   bool
+}
+
+type ConnOption func(o *connOptions)
+
+// WithConnLogger routes GORM's logs through logger instead of stdout, see ZapGormLogger.
+func WithConnLogger(logger *zap.Logger) ConnOption {
+	return func(o *connOptions) {
+		o.logger = logger
+	}
+}
+
+// NewGormDbConn opens a MySQL connection, use NewGormDbConnForConfig to pick the dialect from config.
 func NewGormDbConn(metricsPort uint32, dbName, dsn string, traceProvider *traceSdk.TracerProvider) (*gorm.DB, error) {
-	dbLogger := gLogger.New(
+	return newGormDbConn(MySQL, metricsPort, config.DatabaseConfig{DatabaseName: dbName}, dsn, traceProvider)
+}
+
+// NewGormDbConnForConfig opens a connection using the dialect, pool and logging settings of cfg.
+func NewGormDbConnForConfig(metricsPort uint32, cfg config.DatabaseConfig, traceProvider *traceSdk.TracerProvider, opts ...ConnOption) (*gorm.DB, error) {
+	dialect, err := GetDialect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := DSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newGormDbConn(dialect, metricsPort, cfg, dsn, traceProvider, opts...)
+}
+
+// NewGormReplicaConn opens a connection to a read replica, metrics are only exported for the primary.
+// The replica is not pinged, a replica down at startup is left to the health checks of the ReplicaSet.
+func NewGormReplicaConn(cfg config.DatabaseConfig, traceProvider *traceSdk.TracerProvider, opts ...ConnOption) (*gorm.DB, error) {
+	dialect, err := GetDialect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := DSN(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, func(o *connOptions) {
+		o.lazy = true
+	})
+	return openGormDb(dialect, dsn, cfg, traceProvider, opts...)
+}
+
+func newGormDbConn(dialect Dialect, metricsPort uint32, cfg config.DatabaseConfig, dsn string, traceProvider *traceSdk.TracerProvider, opts ...ConnOption) (*gorm.DB, error) {
+	promPlugin := gProm.New(gProm.Config{
+		DBName:           cfg.DatabaseName,
+		StartServer:      true,
+		HTTPServerPort:   metricsPort,
+		MetricsCollector: dialect.metricsCollectors(),
+	})
+	db, err := openGormDb(dialect, dsn, cfg, traceProvider, opts...)
+	if err != nil {
+		return nil, err
+	}
+	db.Use(promPlugin)
+	return db, nil
+}
+
+func gormLogger(cfg config.DatabaseConfig, options connOptions) gLogger.Interface {
+	level := ParseLogLevel(cfg.LogLevel)
+	if options.logger != nil {
+		return NewZapGormLogger(options.logger, level, cfg.SlowThreshold)
+	}
+	slowThreshold := cfg.SlowThreshold
+	if slowThreshold <= 0 {
+		slowThreshold = defaultSlowThreshold
+	}
+	return gLogger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
 		gLogger.Config{
-			SlowThreshold:             time.Second,  // Slow SQL threshold
-			LogLevel:                  gLogger.Info, // Log level
-			IgnoreRecordNotFoundError: true,         // Ignore ErrRecordNotFound error for logger
-			Colorful:                  false,        // Disable color
+			SlowThreshold:             slowThreshold, // Slow SQL threshold
+			LogLevel:                  level,         // Log level
+			IgnoreRecordNotFoundError: true,          // Ignore ErrRecordNotFound error for logger
+			Colorful:                  false,         // Disable color
 		},
 	)
+}
+
+func openGormDb(dialect Dialect, dsn string, cfg config.DatabaseConfig, traceProvider *traceSdk.TracerProvider, opts ...ConnOption) (*gorm.DB, error) {
+	var options connOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 	// Initialize otel plugin with options
 	plugin := otelgorm.NewPlugin(
 		// include any options here
 		otelgorm.WithTracerProvider(traceProvider),
 	)
-
-	promPlugin := gProm.New(gProm.Config{
-		DBName:         dbName,
-		StartServer:    true,
-		HTTPServerPort: metricsPort,
-		MetricsCollector: []gProm.MetricsCollector{
-			&gProm.MySQL{
-				VariableNames: []string{"threads_running"},
-			},
-		},
-	})
-	// create new mysql database connection
+	dialector, err := dialect.open(dsn, cfg.TLS, options.lazy)
+	if err != nil {
+		return nil, err
+	}
+	// create new database connection for the dialect
 	if db, err := gorm.Open(
-		mysql.Open(dsn),
+		dialector,
 		&gorm.Config{
-			Logger: dbLogger,
+			Logger:               gormLogger(cfg, options),
+			DisableAutomaticPing: options.lazy,
 		}); err == nil {
 		db.Use(plugin)
-		db.Use(promPlugin)
 		rDb, err := db.DB()
 		if err != nil {
 			return nil, err
 		}
-		rDb.SetMaxOpenConns(100)
-		rDb.SetMaxIdleConns(100)
-		rDb.SetConnMaxIdleTime(time.Hour)
+		rDb.SetMaxOpenConns(orDefault(cfg.MaxOpenConns, defaultMaxOpenConns))
+		rDb.SetMaxIdleConns(orDefault(cfg.MaxIdleConns, defaultMaxIdleConns))
+		rDb.SetConnMaxIdleTime(orDefault(cfg.ConnMaxIdleTime, defaultConnMaxIdleTime))
+		rDb.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 		return db, nil
 	} else {
 		return nil, err
 	}
 }
+
+func orDefault[V int | time.Duration](value, defaultValue V) V {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,17 @@
+package db
+
+import "context"
+
+type contextKey string
+
+const includeDeletedCtxKey contextKey = "include-deleted"
+
+// WithDeleted returns a context under which repository reads also return soft deleted entities.
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeDeletedCtxKey, true)
+}
+
+func includeDeleted(ctx context.Context) bool {
+	included, _ := ctx.Value(includeDeletedCtxKey).(bool)
+	return included
+}
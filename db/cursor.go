@@ -0,0 +1,183 @@
+package db
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/byteintellect/go_commons/util"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	idColumn  = "id"
+	cursorSep = "."
+)
+
+var ErrInvalidCursor = errors.New("invalid continuation token")
+
+// Cursor is the position after the last row of a page, the values of the sort keys followed by the row's Id.
+type Cursor struct {
+	Sort   []string      `json:"s"`
+	Values []interface{} `json:"v"`
+	Id     uint64        `json:"id"`
+}
+
+// CursorPage is a single page of a keyset paginated search, NextToken is empty on the last page.
+type CursorPage struct {
+	Items     []entity.Base
+	NextToken string
+}
+
+// CursorCodec turns cursors into opaque signed continuation tokens and back.
+type CursorCodec struct {
+	signer *util.Signer
+}
+
+func NewCursorCodec(signer *util.Signer) *CursorCodec {
+	return &CursorCodec{signer: signer}
+}
+
+func (c *CursorCodec) Encode(cursor *Cursor) (error, string) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		return err, ""
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return nil, encoded + cursorSep + c.signer.GenerateSignature(encoded)
+}
+
+func (c *CursorCodec) Decode(token string) (error, *Cursor) {
+	parts := strings.Split(token, cursorSep)
+	if len(parts) != 2 || !c.signer.ValidateSignature(parts[0], parts[1]) {
+		return ErrInvalidCursor, nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ErrInvalidCursor, nil
+	}
+	var cursor Cursor
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	// keep numbers as written so large ids and sort keys survive the round trip
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil {
+		return ErrInvalidCursor, nil
+	}
+	return nil, &cursor
+}
+
+// keysetSort returns the sort keys used for keyset pagination, the query's sort fields with id as the final tiebreaker.
+func keysetSort(query *Query) []SortField {
+	var keys []SortField
+	idDesc := false
+	for _, sort := range query.Sort {
+		if sort.Field == idColumn {
+			idDesc = sort.Desc
+			continue
+		}
+		keys = append(keys, sort)
+	}
+	return append(keys, SortField{Field: idColumn, Desc: idDesc})
+}
+
+func sortSignature(keys []SortField) []string {
+	var signature []string
+	for _, key := range keys {
+		direction := "asc"
+		if key.Desc {
+			direction = "desc"
+		}
+		signature = append(signature, fmt.Sprintf("%v:%v", key.Field, direction))
+	}
+	return signature
+}
+
+// decodeCursor validates the token against the sort keys of the query it is being used with.
+func decodeCursor(codec *CursorCodec, token string, keys []SortField) (error, *Cursor) {
+	if token == "" {
+		return nil, nil
+	}
+	if codec == nil {
+		return errors.New("cursor pagination requires a cursor codec"), nil
+	}
+	err, cursor := codec.Decode(token)
+	if err != nil {
+		return err, nil
+	}
+	signature := sortSignature(keys)
+	if len(cursor.Sort) != len(signature) || len(cursor.Values) != len(keys)-1 {
+		return ErrInvalidCursor, nil
+	}
+	for i := range signature {
+		if cursor.Sort[i] != signature[i] {
+			return ErrInvalidCursor, nil
+		}
+	}
+	return nil, cursor
+}
+
+func encodeCursor(codec *CursorCodec, keys []SortField, values []interface{}, id uint64) (error, string) {
+	if codec == nil {
+		return errors.New("cursor pagination requires a cursor codec"), ""
+	}
+	for i, value := range values {
+		switch v := value.(type) {
+		case time.Time:
+			values[i] = v.Format(time.RFC3339Nano)
+		case *time.Time:
+			if v != nil {
+				values[i] = v.Format(time.RFC3339Nano)
+			}
+		}
+	}
+	return codec.Encode(&Cursor{Sort: sortSignature(keys), Values: values, Id: id})
+}
+
+// parseCursorTimes turns the values of time columns back into times, so that the driver converts them to the
+// database's time zone like the stored values.
+func parseCursorTimes(sch *schema.Schema, keys []SortField, cursor *Cursor) error {
+	for i, value := range cursor.Values {
+		field := sch.LookUpField(keys[i].Field)
+		if field == nil {
+			continue
+		}
+		if fieldType := field.FieldType; fieldType != timeType && fieldType != reflect.PtrTo(timeType) {
+			continue
+		}
+		formatted, ok := value.(string)
+		if !ok {
+			return ErrInvalidCursor
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, formatted)
+		if err != nil {
+			return ErrInvalidCursor
+		}
+		cursor.Values[i] = parsed
+	}
+	return nil
+}
+
+// keysetCondition builds (k1 > v1) OR (k1 = v1 AND k2 > v2) ... for the given cursor, flipping
+// the comparison for descending keys. Sort keys are expected to be non nullable.
+func keysetCondition(keys []SortField, cursor *Cursor) Condition {
+	values := append(append([]interface{}{}, cursor.Values...), cursor.Id)
+	var branches []Condition
+	for i, key := range keys {
+		var branch []Condition
+		for j := 0; j < i; j++ {
+			branch = append(branch, Eq(keys[j].Field, values[j]))
+		}
+		if key.Desc {
+			branch = append(branch, Lt(key.Field, values[i]))
+		} else {
+			branch = append(branch, Gt(key.Field, values[i]))
+		}
+		branches = append(branches, And(branch...))
+	}
+	return Or(branches...)
+}
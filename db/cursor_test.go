@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/byteintellect/go_commons/util"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorCodec(t *testing.T) {
+	codec := NewCursorCodec(util.NewSigner("secret"))
+	cursor := &Cursor{Sort: []string{"quantity:desc", "id:asc"}, Values: []interface{}{json.Number("3")}, Id: 18446744073709551615}
+	err, token := codec.Encode(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err, decoded := codec.Decode(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, cursor) {
+		t.Fatalf("got %+v, expected %+v", decoded, cursor)
+	}
+
+	payload, signature, _ := strings.Cut(token, cursorSep)
+	forged, _ := json.Marshal(&Cursor{Sort: cursor.Sort, Values: cursor.Values, Id: 1})
+	_, otherToken := NewCursorCodec(util.NewSigner("other")).Encode(cursor)
+	_, otherSignature, _ := strings.Cut(otherToken, cursorSep)
+	for name, tampered := range map[string]string{
+		"changed payload":   base64.RawURLEncoding.EncodeToString(forged) + cursorSep + signature,
+		"other key":         payload + cursorSep + otherSignature,
+		"missing signature": payload,
+		"extra part":        token + cursorSep + signature,
+		"empty":             "",
+	} {
+		if err, _ := codec.Decode(tampered); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%v: expected an invalid cursor, got %v", name, err)
+		}
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	keys := keysetSort(NewQuery().OrderBy("quantity", true).OrderBy("name", false))
+	cursor := &Cursor{Sort: sortSignature(keys), Values: []interface{}{3, "bolt"}, Id: 7}
+	stmt := dryRunDb(t).Table("widgets").
+		Clauses(clause.Where{Exprs: conditionsToClauses([]Condition{keysetCondition(keys, cursor)})}).
+		Find(&[]map[string]interface{}{}).Statement
+	sql := "SELECT * FROM `widgets` WHERE (`quantity` < ? OR (`quantity` = ? AND `name` > ?) OR (`quantity` = ? AND `name` = ? AND `id` > ?))"
+	if got := stmt.SQL.String(); got != sql {
+		t.Fatalf("got %v\nexpected %v", got, sql)
+	}
+	if vars := []interface{}{3, 3, "bolt", 3, "bolt", uint64(7)}; !reflect.DeepEqual(stmt.Vars, vars) {
+		t.Fatalf("got vars %v, expected %v", stmt.Vars, vars)
+	}
+}
+
+func TestDecodeCursorChecksSortKeys(t *testing.T) {
+	codec := NewCursorCodec(util.NewSigner("secret"))
+	keys := keysetSort(NewQuery().OrderBy("quantity", true))
+	err, token := encodeCursor(codec, keys, []interface{}{3}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, cursor := decodeCursor(codec, token, keys); err != nil || cursor.Id != 7 {
+		t.Fatalf("decoded %+v: %v", cursor, err)
+	}
+	for _, query := range []*Query{NewQuery().OrderBy("quantity", false), NewQuery().OrderBy("name", true), NewQuery()} {
+		if err, _ := decodeCursor(codec, token, keysetSort(query)); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("token accepted for sort %+v: %v", query.Sort, err)
+		}
+	}
+	if err, cursor := decodeCursor(codec, "", keys); err != nil || cursor != nil {
+		t.Fatalf("expected an empty token to start from the first row, got %+v: %v", cursor, err)
+	}
+}
+
+func TestCursorKeepsTimeZone(t *testing.T) {
+	codec := NewCursorCodec(util.NewSigner("secret"))
+	keys := keysetSort(NewQuery().OrderBy("created_at", false))
+	createdAt := time.Date(2022, 3, 1, 10, 30, 0, 123456789, time.FixedZone("IST", 5*3600+1800))
+	err, token := encodeCursor(codec, keys, []interface{}{createdAt}, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err, cursor := decodeCursor(codec, token, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err, sch := newTestRepo(t).parseSchema(&widget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parseCursorTimes(sch, keys, cursor); err != nil {
+		t.Fatal(err)
+	}
+	decoded, ok := cursor.Values[0].(time.Time)
+	if !ok || !decoded.Equal(createdAt) {
+		t.Fatalf("decoded %v, expected %v", cursor.Values[0], createdAt)
+	}
+	if _, offset := decoded.Zone(); offset != 5*3600+1800 {
+		t.Fatalf("decoded offset %v, expected +05:30", offset)
+	}
+
+	cursor.Values[0] = "yesterday"
+	if err := parseCursorTimes(sch, keys, cursor); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected an unparsable time to be invalid, got %v", err)
+	}
+}
+
+func TestSearchAfterPagesByTime(t *testing.T) {
+	repo := newTestRepo(t, WithCursorCodec(NewCursorCodec(util.NewSigner("secret"))))
+	ctx := context.Background()
+	for _, name := range []string{"bolt", "nut", "washer"} {
+		if err, _ := repo.Create(ctx, &widget{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := NewQuery().OrderBy("created_at", true).WithLimit(2)
+	var names []string
+	token := ""
+	for {
+		err, page := repo.SearchAfter(ctx, query, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range page.Items {
+			names = append(names, item.(*widget).Name)
+		}
+		if page.NextToken == "" {
+			break
+		}
+		token = page.NextToken
+	}
+	if !reflect.DeepEqual(names, []string{"washer", "nut", "bolt"}) {
+		t.Fatalf("paged %v", names)
+	}
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/byteintellect/go_commons/config"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gProm "gorm.io/plugin/prometheus"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// GetDialect returns the dialect selected by DatabaseConfig.Type, defaulting to MySQL.
+func GetDialect(cfg config.DatabaseConfig) (Dialect, error) {
+	switch Dialect(cfg.Type) {
+	case "", MySQL:
+		return MySQL, nil
+	case Postgres, "postgresql":
+		return Postgres, nil
+	case SQLite, "sqlite3":
+		return SQLite, nil
+	default:
+		return "", fmt.Errorf("unsupported database type %v", cfg.Type)
+	}
+}
+
+// DSN builds the connection string for the configured dialect, for SQLite DatabaseName is the database file.
+// MySQL TLS settings are registered with the driver under a name derived from the host, a Postgres TLS
+// ServerName cannot be expressed in the connection string and is applied when the connection is opened.
+func DSN(cfg config.DatabaseConfig) (string, error) {
+	dialect, err := GetDialect(cfg)
+	if err != nil {
+		return "", err
+	}
+	switch dialect {
+	case Postgres:
+		params := url.Values{}
+		params.Set("sslmode", "disable")
+		if cfg.TLS.Enabled {
+			// without a CA certificate the server certificate is verified against the system roots
+			params.Set("sslmode", "verify-full")
+			if cfg.TLS.InsecureSkipVerify {
+				params.Set("sslmode", "require")
+			}
+			if cfg.TLS.CACertFile != "" {
+				params.Set("sslrootcert", cfg.TLS.CACertFile)
+			}
+			if cfg.TLS.CertFile != "" {
+				params.Set("sslcert", cfg.TLS.CertFile)
+				params.Set("sslkey", cfg.TLS.KeyFile)
+			}
+		}
+		if cfg.ConnectTimeout > 0 {
+			// the timeout is in whole seconds, rounded up as 0 would disable it
+			params.Set("connect_timeout", strconv.Itoa(int(math.Ceil(cfg.ConnectTimeout.Seconds()))))
+		}
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(cfg.UserName, cfg.Password),
+			Host:     fmt.Sprintf("%v:%v", cfg.HostName, cfg.Port),
+			Path:     cfg.DatabaseName,
+			RawQuery: params.Encode(),
+		}
+		return dsn.String(), nil
+	case SQLite:
+		return cfg.DatabaseName, nil
+	default:
+		mCfg := mysqlDriver.NewConfig()
+		mCfg.Net = "tcp"
+		mCfg.Addr = fmt.Sprintf("%v:%v", cfg.HostName, cfg.Port)
+		mCfg.User, mCfg.Passwd = cfg.UserName, cfg.Password
+		mCfg.DBName = cfg.DatabaseName
+		mCfg.ParseTime = true
+		mCfg.Timeout, mCfg.ReadTimeout, mCfg.WriteTimeout = cfg.ConnectTimeout, cfg.ReadTimeout, cfg.WriteTimeout
+		if cfg.TLS.Enabled {
+			tlsCfg, err := tlsConfig(cfg.TLS)
+			if err != nil {
+				return "", err
+			}
+			name := fmt.Sprintf("%v_%v", cfg.HostName, cfg.Port)
+			if err := mysqlDriver.RegisterTLSConfig(name, tlsCfg); err != nil {
+				return "", err
+			}
+			mCfg.TLSConfig = name
+		}
+		return mCfg.FormatDSN(), nil
+	}
+}
+
+func tlsConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CACertFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", cfg.CACertFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// open returns the dialector of dsn, a lazy dialector does not connect until the first statement.
+func (d Dialect) open(dsn string, tlsCfg config.TLSConfig, lazy bool) (gorm.Dialector, error) {
+	switch d {
+	case Postgres:
+		if !tlsCfg.Enabled || tlsCfg.ServerName == "" {
+			return postgres.Open(dsn), nil
+		}
+		connCfg, err := pgx.ParseConfig(dsn)
+		if err != nil {
+			return nil, err
+		}
+		// verify the server certificate against ServerName instead of the host connected to
+		tlsConfig, err := tlsConfig(tlsCfg)
+		if err != nil {
+			return nil, err
+		}
+		connCfg.TLSConfig = tlsConfig
+		for _, fallback := range connCfg.Fallbacks {
+			if fallback.TLSConfig != nil {
+				fallback.TLSConfig = tlsConfig
+			}
+		}
+		return postgres.New(postgres.Config{Conn: stdlib.OpenDB(*connCfg)}), nil
+	case SQLite:
+		return sqlite.Open(dsn), nil
+	default:
+		// the MySQL dialector reads the server version on open unless told not to
+		return mysql.New(mysql.Config{DSN: dsn, SkipInitializeWithVersion: lazy}), nil
+	}
+}
+
+func (d Dialect) metricsCollectors() []gProm.MetricsCollector {
+	switch d {
+	case Postgres:
+		return []gProm.MetricsCollector{
+			&gProm.Postgres{},
+		}
+	case SQLite:
+		// only the connection pool stats are exported for SQLite
+		return nil
+	default:
+		return []gProm.MetricsCollector{
+			&gProm.MySQL{
+				VariableNames: []string{"threads_running"},
+			},
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"github.com/byteintellect/go_commons/config"
+	traceSdk "go.opentelemetry.io/otel/sdk/trace"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetDialect(t *testing.T) {
+	tests := []struct {
+		dbType  string
+		dialect Dialect
+		fails   bool
+	}{
+		{dbType: "", dialect: MySQL},
+		{dbType: "mysql", dialect: MySQL},
+		{dbType: "postgres", dialect: Postgres},
+		{dbType: "postgresql", dialect: Postgres},
+		{dbType: "sqlite", dialect: SQLite},
+		{dbType: "sqlite3", dialect: SQLite},
+		{dbType: "oracle", fails: true},
+	}
+	for _, test := range tests {
+		dialect, err := GetDialect(config.DatabaseConfig{Type: test.dbType})
+		if (err != nil) != test.fails || dialect != test.dialect {
+			t.Errorf("GetDialect(%q) = %q, %v", test.dbType, dialect, err)
+		}
+	}
+}
+
+func TestDSN(t *testing.T) {
+	cfg := config.DatabaseConfig{
+		HostName:       "db.internal",
+		Port:           "5432",
+		UserName:       "app",
+		Password:       "p@ss",
+		DatabaseName:   "widgets",
+		ConnectTimeout: 1500 * time.Millisecond,
+	}
+
+	cfg.Type = "postgres"
+	dsn, err := DSN(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := parsed.User.Password()
+	if parsed.Scheme != "postgres" || parsed.Host != "db.internal:5432" || parsed.Path != "/widgets" || password != "p@ss" {
+		t.Fatalf("unexpected postgres dsn %v", dsn)
+	}
+	if params := parsed.Query(); params.Get("sslmode") != "disable" || params.Get("connect_timeout") != "2" {
+		t.Fatalf("unexpected postgres parameters %v", params)
+	}
+
+	cfg.Type = "mysql"
+	if dsn, err := DSN(cfg); err != nil || dsn != "app:p@ss@tcp(db.internal:5432)/widgets?parseTime=true&timeout=1.5s" {
+		t.Fatalf("unexpected mysql dsn %v: %v", dsn, err)
+	}
+
+	cfg.Type = "sqlite"
+	if dsn, err := DSN(cfg); err != nil || dsn != "widgets" {
+		t.Fatalf("unexpected sqlite dsn %v: %v", dsn, err)
+	}
+
+	cfg.Type = "oracle"
+	if _, err := DSN(cfg); err == nil {
+		t.Fatal("expected an unsupported type to fail")
+	}
+}
+
+func TestOpenSelectsDialect(t *testing.T) {
+	cfg := config.DatabaseConfig{Type: "sqlite", DatabaseName: filepath.Join(t.TempDir(), "widgets.db")}
+	db, err := NewGormReplicaConn(cfg, traceSdk.NewTracerProvider())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDb.Close()
+	if name := db.Dialector.Name(); name != "sqlite" {
+		t.Fatalf("opened a %v connection, expected sqlite", name)
+	}
+	if err := sqlDb.Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
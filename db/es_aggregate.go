@@ -0,0 +1,196 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+const compositePageSize = 1000
+
+type esMetric struct {
+	Value *float64 `json:"value"`
+}
+
+type esCompositeBucket struct {
+	Key      map[string]interface{}     `json:"key"`
+	DocCount int64                      `json:"doc_count"`
+	Metrics  map[string]json.RawMessage `json:"-"`
+}
+
+func (b *esCompositeBucket) UnmarshalJSON(data []byte) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw["key"], &b.Key); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw["doc_count"], &b.DocCount); err != nil {
+		return err
+	}
+	b.Metrics = raw
+	return nil
+}
+
+type esComposite struct {
+	AfterKey map[string]interface{} `json:"after_key"`
+	Buckets  []esCompositeBucket    `json:"buckets"`
+}
+
+// Count returns the number of documents matching conditions using the count API.
+func (esr *ElasticsearchRepo) Count(ctx context.Context, conditions ...Condition) (error, int64) {
+	return esr.count(ctx, conditions, nil)
+}
+
+// Exists reports whether a document matches conditions, the count stops at the first match.
+func (esr *ElasticsearchRepo) Exists(ctx context.Context, conditions ...Condition) (error, bool) {
+	one := 1
+	err, count := esr.count(ctx, conditions, &one)
+	return err, count > 0
+}
+
+func (esr *ElasticsearchRepo) count(ctx context.Context, conditions []Condition, terminateAfter *int) (error, int64) {
+	for _, condition := range conditions {
+		if err := condition.Validate(); err != nil {
+			return err, 0
+		}
+	}
+	err, body := esr.searchBody(ctx, conditions, nil)
+	if err != nil {
+		return err, 0
+	}
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return err, 0
+	}
+	req := esapi.CountRequest{
+		Index:          []string{esr.index},
+		Body:           bytes.NewReader(bodyBytes),
+		TerminateAfter: terminateAfter,
+	}
+	res, err := req.Do(ctx, esr.client)
+	if err != nil {
+		return err, 0
+	}
+	defer res.Body.Close()
+	if !esr.sChecker.IsSuccessFul(res.StatusCode) {
+		return errors.New(fmt.Sprintf("Error while counting %v", res.String())), 0
+	}
+	var response struct {
+		Count int64 `json:"count"`
+	}
+	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
+		return &response
+	})
+	if err != nil {
+		return err, 0
+	}
+	return nil, response.Count
+}
+
+// Aggregate runs metric aggregations, grouped with a composite aggregation paged through until exhausted.
+func (esr *ElasticsearchRepo) Aggregate(ctx context.Context, query *AggregateQuery) (error, []AggregateRow) {
+	if err := query.Validate(); err != nil {
+		return err, nil
+	}
+	err, body := esr.searchBody(ctx, query.Conditions, nil)
+	if err != nil {
+		return err, nil
+	}
+	body["size"] = 0
+	metrics := make(map[string]interface{})
+	for _, aggregation := range query.Aggregations {
+		if aggregation.Func != AggCount {
+			metrics[aggregation.Alias] = map[string]interface{}{
+				string(aggregation.Func): map[string]interface{}{"field": aggregation.Field},
+			}
+		}
+	}
+	if len(query.GroupBy) == 0 {
+		body["track_total_hits"] = true
+		if len(metrics) > 0 {
+			body["aggs"] = metrics
+		}
+		err, response := esr.doSearch(ctx, body)
+		if err != nil {
+			return err, nil
+		}
+		row := AggregateRow{Group: make(map[string]interface{}), Values: make(map[string]float64)}
+		for _, aggregation := range query.Aggregations {
+			if aggregation.Func == AggCount {
+				row.Values[aggregation.Alias] = float64(response.Hits.Total.Value)
+				continue
+			}
+			err, value := metricValue(response.Aggregations[aggregation.Alias])
+			if err != nil {
+				return err, nil
+			}
+			row.Values[aggregation.Alias] = value
+		}
+		return nil, []AggregateRow{row}
+	}
+	var sources []interface{}
+	for _, field := range query.GroupBy {
+		sources = append(sources, map[string]interface{}{
+			field: map[string]interface{}{"terms": map[string]interface{}{"field": field}},
+		})
+	}
+	var rows []AggregateRow
+	var afterKey map[string]interface{}
+	for {
+		composite := map[string]interface{}{"size": compositePageSize, "sources": sources}
+		if afterKey != nil {
+			composite["after"] = afterKey
+		}
+		groups := map[string]interface{}{"composite": composite}
+		if len(metrics) > 0 {
+			groups["aggs"] = metrics
+		}
+		body["aggs"] = map[string]interface{}{"groups": groups}
+		err, response := esr.doSearch(ctx, body)
+		if err != nil {
+			return err, nil
+		}
+		var page esComposite
+		if err := json.Unmarshal(response.Aggregations["groups"], &page); err != nil {
+			return err, nil
+		}
+		for _, bucket := range page.Buckets {
+			row := AggregateRow{Group: bucket.Key, Values: make(map[string]float64)}
+			for _, aggregation := range query.Aggregations {
+				if aggregation.Func == AggCount {
+					row.Values[aggregation.Alias] = float64(bucket.DocCount)
+					continue
+				}
+				err, value := metricValue(bucket.Metrics[aggregation.Alias])
+				if err != nil {
+					return err, nil
+				}
+				row.Values[aggregation.Alias] = value
+			}
+			rows = append(rows, row)
+		}
+		if len(page.Buckets) < compositePageSize || page.AfterKey == nil {
+			return nil, rows
+		}
+		afterKey = page.AfterKey
+	}
+}
+
+func metricValue(raw json.RawMessage) (error, float64) {
+	if raw == nil {
+		return errors.New("missing aggregation in response"), 0
+	}
+	var metric esMetric
+	if err := json.Unmarshal(raw, &metric); err != nil {
+		return err, 0
+	}
+	if metric.Value == nil {
+		return nil, 0
+	}
+	return nil, *metric.Value
+}
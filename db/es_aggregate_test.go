@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// compositePage returns a page of count buckets of the groups composite aggregation, keyed by name from first on.
+func compositePage(first, count int, afterKey string) string {
+	buckets := make([]map[string]interface{}, 0, count)
+	for i := first; i < first+count; i++ {
+		buckets = append(buckets, map[string]interface{}{
+			"key":          map[string]interface{}{"name": fmt.Sprintf("widget-%04d", i)},
+			"doc_count":    2,
+			"sum_quantity": map[string]interface{}{"value": i},
+		})
+	}
+	groups := map[string]interface{}{"buckets": buckets}
+	if afterKey != "" {
+		groups["after_key"] = map[string]interface{}{"name": afterKey}
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"hits":         map[string]interface{}{"total": map[string]interface{}{"value": 0}, "hits": []interface{}{}},
+		"aggregations": map[string]interface{}{"groups": groups},
+	})
+	return string(response)
+}
+
+func TestESAggregatePagesThroughCompositeBuckets(t *testing.T) {
+	server := &searchServer{}
+	server.respond = func(body map[string]interface{}) string {
+		if len(server.bodies) == 1 {
+			return compositePage(0, compositePageSize, fmt.Sprintf("widget-%04d", compositePageSize-1))
+		}
+		// the last page still carries an after key
+		return compositePage(compositePageSize, 2, fmt.Sprintf("widget-%04d", compositePageSize+1))
+	}
+	esr := newSearchTestRepo(t, server)
+	err, rows := esr.Aggregate(context.Background(), NewAggregateQuery(CountOf(), SumOf("quantity")).By("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.bodies) != 2 {
+		t.Fatalf("expected two pages, sent %v searches", len(server.bodies))
+	}
+	composite := func(body map[string]interface{}) map[string]interface{} {
+		return body["aggs"].(map[string]interface{})["groups"].(map[string]interface{})["composite"].(map[string]interface{})
+	}
+	first, second := composite(server.bodies[0]), composite(server.bodies[1])
+	if _, ok := first["after"]; ok {
+		t.Fatalf("first page sent an after key: %v", first)
+	}
+	if expected := map[string]interface{}{"name": fmt.Sprintf("widget-%04d", compositePageSize-1)}; !reflect.DeepEqual(second["after"], expected) {
+		t.Fatalf("second page sent after %v, expected %v", second["after"], expected)
+	}
+	expectedSources := jsonValue(t, `[{"name": {"terms": {"field": "name"}}}]`)
+	if first["size"] != float64(compositePageSize) || !reflect.DeepEqual(first["sources"], expectedSources) {
+		t.Fatalf("unexpected composite aggregation %v", first)
+	}
+	if server.bodies[0]["size"] != 0.0 {
+		t.Fatalf("requested hits while aggregating: %v", server.bodies[0])
+	}
+
+	if len(rows) != compositePageSize+2 {
+		t.Fatalf("got %v rows, expected %v", len(rows), compositePageSize+2)
+	}
+	last := rows[len(rows)-1]
+	if last.Group["name"] != fmt.Sprintf("widget-%04d", compositePageSize+1) || last.Values["count"] != 2 || last.Values["sum_quantity"] != float64(compositePageSize+1) {
+		t.Fatalf("unexpected last row %+v", last)
+	}
+}
+
+func TestESAggregateWithoutGroups(t *testing.T) {
+	server := &searchServer{respond: func(map[string]interface{}) string {
+		return `{"hits": {"total": {"value": 7}, "hits": []}, "aggregations": {"max_quantity": {"value": 12}, "min_quantity": {"value": null}}}`
+	}}
+	esr := newSearchTestRepo(t, server)
+	err, rows := esr.Aggregate(context.Background(), NewAggregateQuery(CountOf(), MaxOf("quantity"), MinOf("quantity")).Where(Eq("name", "bolt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := server.bodies[0]
+	expectedAggs := jsonValue(t, `{"max_quantity": {"max": {"field": "quantity"}}, "min_quantity": {"min": {"field": "quantity"}}}`)
+	if !reflect.DeepEqual(body["aggs"], expectedAggs) || body["track_total_hits"] != true {
+		t.Fatalf("unexpected search %v", body)
+	}
+	if len(rows) != 1 || !sameValues(rows[0].Values, map[string]float64{"count": 7, "max_quantity": 12, "min_quantity": 0}) {
+		t.Fatalf("unexpected rows %+v", rows)
+	}
+}
@@ -0,0 +1,469 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBulkBatchSize     = 500
+	defaultBulkFlushBytes    = 5 << 20
+	defaultBulkFlushInterval = time.Second
+	defaultBulkWorkers       = 2
+	defaultBulkMaxAttempts   = 5
+	defaultBulkBackoff       = 100 * time.Millisecond
+	defaultBulkMaxDelay      = 10 * time.Second
+)
+
+var ErrBulkIndexerClosed = errors.New("bulk indexer is closed")
+
+type ESBulkAction string
+
+const (
+	ESBulkIndex  ESBulkAction = "index"
+	ESBulkCreate ESBulkAction = "create"
+	ESBulkUpdate ESBulkAction = "update"
+	ESBulkDelete ESBulkAction = "delete"
+)
+
+// ESBulkItem is one operation of a bulk request. Body is the document for index and create,
+// the partial document for update, and is ignored for delete.
+type ESBulkItem struct {
+	Action     ESBulkAction
+	DocumentId string
+	Body       []byte
+}
+
+// ESBulkItemError is the error Elasticsearch reported for a single item of a bulk request.
+type ESBulkItemError struct {
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *ESBulkItemError) Error() string {
+	return fmt.Sprintf("bulk item failed with status %v: %v %v", e.Status, e.Type, e.Reason)
+}
+
+type ESBulkStats struct {
+	Added     uint64
+	Succeeded uint64
+	Failed    uint64
+	Retried   uint64
+	Requests  uint64
+}
+
+type ESBulkIndexerOption func(bi *ESBulkIndexer)
+
+// WithBulkIndex sends the items to the given index rather than to the repository's alias.
+func WithBulkIndex(index string) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		bi.index = index
+	}
+}
+
+// WithBulkBatchSize sets the number of items after which a worker sends its batch, sizes below one keep the default.
+func WithBulkBatchSize(size int) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		if size > 0 {
+			bi.batchSize = size
+		}
+	}
+}
+
+// WithBulkFlushBytes sets the request body size after which a worker sends its batch, sizes below one keep the default.
+func WithBulkFlushBytes(size int) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		if size > 0 {
+			bi.flushBytes = size
+		}
+	}
+}
+
+// WithBulkFlushInterval sets how long items may wait in a partial batch before it is sent, intervals that are not
+// positive keep the default.
+func WithBulkFlushInterval(interval time.Duration) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		if interval > 0 {
+			bi.flushInterval = interval
+		}
+	}
+}
+
+// WithBulkWorkers sets the number of batches sent concurrently, counts below one keep the default.
+func WithBulkWorkers(workers int) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		if workers > 0 {
+			bi.workers = workers
+		}
+	}
+}
+
+// WithBulkRefresh sets the refresh parameter of the bulk requests, "true", "wait_for" or "false",
+// by default the bulk requests do not refresh the index.
+func WithBulkRefresh(refresh string) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		bi.refresh = refresh
+	}
+}
+
+// WithBulkBackoff sets how items rejected with 429 are retried, the delay doubles on every attempt up to maxDelay.
+func WithBulkBackoff(maxAttempts int, backoff, maxDelay time.Duration) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		bi.maxAttempts = maxAttempts
+		bi.backoff = backoff
+		bi.maxDelay = maxDelay
+	}
+}
+
+// WithBulkOnSuccess sets a callback called for every item Elasticsearch accepted.
+func WithBulkOnSuccess(onSuccess func(ctx context.Context, item ESBulkItem)) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		bi.onSuccess = onSuccess
+	}
+}
+
+// WithBulkOnFailure sets a callback called for every item that failed, after its retries are exhausted.
+func WithBulkOnFailure(onFailure func(ctx context.Context, item ESBulkItem, err error)) ESBulkIndexerOption {
+	return func(bi *ESBulkIndexer) {
+		bi.onFailure = onFailure
+	}
+}
+
+type bulkEntry struct {
+	item  ESBulkItem
+	lines []byte
+}
+
+type esBulkResponse struct {
+	Errors bool                                `json:"errors"`
+	Items  []map[string]esBulkResponseItemBody `json:"items"`
+}
+
+type esBulkResponseItemBody struct {
+	Id     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error,omitempty"`
+}
+
+// ESBulkIndexer sends documents to the _bulk endpoint of the repository's index from a pool of workers.
+// Add blocks while all workers are busy and their queue is full, so that producers slow down to the pace
+// the cluster accepts. Items rejected with 429 are retried with exponential backoff, other failures are
+// reported to the failure callback.
+type ESBulkIndexer struct {
+	esr   *ElasticsearchRepo
+	index string
+
+	batchSize     int
+	flushBytes    int
+	flushInterval time.Duration
+	workers       int
+	refresh       string
+	maxAttempts   int
+	backoff       time.Duration
+	maxDelay      time.Duration
+	onSuccess     func(ctx context.Context, item ESBulkItem)
+	onFailure     func(ctx context.Context, item ESBulkItem, err error)
+
+	ctx    context.Context
+	items  chan bulkEntry
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+	stats  ESBulkStats
+}
+
+// NewBulkIndexer starts the workers of a bulk indexer, they stop once Close has flushed the pending items.
+// ctx is used for the bulk requests and handed to the callbacks.
+func (esr *ElasticsearchRepo) NewBulkIndexer(ctx context.Context, opts ...ESBulkIndexerOption) *ESBulkIndexer {
+	bi := &ESBulkIndexer{
+		esr:           esr,
+		index:         esr.index,
+		batchSize:     defaultBulkBatchSize,
+		flushBytes:    defaultBulkFlushBytes,
+		flushInterval: defaultBulkFlushInterval,
+		workers:       defaultBulkWorkers,
+		refresh:       "false",
+		maxAttempts:   defaultBulkMaxAttempts,
+		backoff:       defaultBulkBackoff,
+		maxDelay:      defaultBulkMaxDelay,
+		ctx:           ctx,
+	}
+	for _, opt := range opts {
+		opt(bi)
+	}
+	bi.items = make(chan bulkEntry, bi.workers*bi.batchSize)
+	for i := 0; i < bi.workers; i++ {
+		bi.wg.Add(1)
+		go bi.work()
+	}
+	return bi
+}
+
+// Index adds the entity as an index operation, with the tenant field set from ctx. Like Create, for a ctx
+// scoped to a tenant it is added as a create operation, which fails when a document with the same id exists,
+// so that a tenant cannot overwrite the documents of another.
+func (bi *ESBulkIndexer) Index(ctx context.Context, base entity.Base) error {
+	err, condition := bi.esr.tenantCondition(ctx)
+	if err != nil {
+		return err
+	}
+	err, doc := bi.esr.tenantDocument(ctx, base)
+	if err != nil {
+		return err
+	}
+	action := ESBulkIndex
+	if condition != nil {
+		action = ESBulkCreate
+	}
+	return bi.Add(ctx, ESBulkItem{Action: action, DocumentId: base.GetExternalId(), Body: []byte(doc)})
+}
+
+// Delete adds a delete operation, the document is removed from the index. Like HardDelete, it fails unless
+// the document belongs to the tenant in ctx.
+func (bi *ESBulkIndexer) Delete(ctx context.Context, externalId string) error {
+	if err := bi.esr.checkTenant(ctx, externalId); err != nil {
+		return err
+	}
+	return bi.Add(ctx, ESBulkItem{Action: ESBulkDelete, DocumentId: externalId})
+}
+
+// Add queues the item, blocking while the queue is full until a worker takes it or ctx is done.
+func (bi *ESBulkIndexer) Add(ctx context.Context, item ESBulkItem) error {
+	err, lines := bulkLines(item)
+	if err != nil {
+		return err
+	}
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	if bi.closed {
+		return ErrBulkIndexerClosed
+	}
+	select {
+	case bi.items <- bulkEntry{item: item, lines: lines}:
+		atomic.AddUint64(&bi.stats.Added, 1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close sends the pending items and waits for the workers to finish, or for ctx to be done.
+func (bi *ESBulkIndexer) Close(ctx context.Context) error {
+	bi.mu.Lock()
+	if !bi.closed {
+		bi.closed = true
+		close(bi.items)
+	}
+	bi.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		bi.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bi *ESBulkIndexer) Stats() ESBulkStats {
+	return ESBulkStats{
+		Added:     atomic.LoadUint64(&bi.stats.Added),
+		Succeeded: atomic.LoadUint64(&bi.stats.Succeeded),
+		Failed:    atomic.LoadUint64(&bi.stats.Failed),
+		Retried:   atomic.LoadUint64(&bi.stats.Retried),
+		Requests:  atomic.LoadUint64(&bi.stats.Requests),
+	}
+}
+
+func (bi *ESBulkIndexer) work() {
+	defer bi.wg.Done()
+	ticker := time.NewTicker(bi.flushInterval)
+	defer ticker.Stop()
+	var batch []bulkEntry
+	size := 0
+	flush := func() {
+		if len(batch) > 0 {
+			bi.flush(batch)
+		}
+		batch, size = nil, 0
+	}
+	for {
+		select {
+		case entry, ok := <-bi.items:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, entry)
+			size += len(entry.lines)
+			if len(batch) >= bi.batchSize || size >= bi.flushBytes {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// flush sends the batch, resending the items rejected with 429 until they succeed or run out of attempts.
+func (bi *ESBulkIndexer) flush(batch []bulkEntry) {
+	pending := batch
+	for attempt := 1; ; attempt++ {
+		err, retry := bi.send(pending)
+		if len(retry) == 0 {
+			return
+		}
+		if attempt >= bi.maxAttempts {
+			bi.fail(retry, err)
+			return
+		}
+		atomic.AddUint64(&bi.stats.Retried, uint64(len(retry)))
+		timer := time.NewTimer(bi.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-bi.ctx.Done():
+			timer.Stop()
+			bi.fail(retry, bi.ctx.Err())
+			return
+		}
+		pending = retry
+	}
+}
+
+// send makes one bulk request and returns the items to retry, with the error that caused the retry.
+func (bi *ESBulkIndexer) send(batch []bulkEntry) (error, []bulkEntry) {
+	var body bytes.Buffer
+	for _, entry := range batch {
+		body.Write(entry.lines)
+	}
+	atomic.AddUint64(&bi.stats.Requests, 1)
+	req := esapi.BulkRequest{Index: bi.index, Body: &body, Refresh: bi.refresh}
+	res, err := req.Do(bi.ctx, bi.esr.client)
+	if err != nil {
+		if bi.ctx.Err() != nil {
+			bi.fail(batch, err)
+			return nil, nil
+		}
+		return err, batch
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests {
+		return errors.New(res.String()), batch
+	}
+	if !bi.esr.sChecker.IsSuccessFul(res.StatusCode) {
+		bi.fail(batch, errors.New(fmt.Sprintf("Error while bulk indexing %v", res.String())))
+		return nil, nil
+	}
+	var response esBulkResponse
+	err = bi.esr.marshaller.BytesToResponse(res.Body, func() interface{} {
+		return &response
+	})
+	if err != nil {
+		bi.fail(batch, err)
+		return nil, nil
+	}
+	if len(response.Items) != len(batch) {
+		bi.fail(batch, errors.New("bulk response does not match the request"))
+		return nil, nil
+	}
+	var retry []bulkEntry
+	var retryErr error
+	for i, entry := range batch {
+		result := response.Items[i][string(entry.item.Action)]
+		switch {
+		case result.Status == http.StatusTooManyRequests:
+			retry = append(retry, entry)
+			retryErr = bulkItemError(result)
+		case result.Status > 299 || result.Error != nil:
+			bi.fail([]bulkEntry{entry}, bulkItemError(result))
+		default:
+			atomic.AddUint64(&bi.stats.Succeeded, 1)
+			if bi.onSuccess != nil {
+				bi.onSuccess(bi.ctx, entry.item)
+			}
+		}
+	}
+	return retryErr, retry
+}
+
+func (bi *ESBulkIndexer) fail(entries []bulkEntry, err error) {
+	atomic.AddUint64(&bi.stats.Failed, uint64(len(entries)))
+	if bi.onFailure == nil {
+		bi.esr.logger.WithError(err).Errorf("%v bulk items failed", len(entries))
+		return
+	}
+	for _, entry := range entries {
+		bi.onFailure(bi.ctx, entry.item, err)
+	}
+}
+
+func bulkItemError(result esBulkResponseItemBody) error {
+	itemErr := &ESBulkItemError{Status: result.Status}
+	if result.Error != nil {
+		itemErr.Type, itemErr.Reason = result.Error.Type, result.Error.Reason
+	}
+	return itemErr
+}
+
+// bulkLines encodes the item as the action line and, except for deletes, the source line of a bulk body.
+func bulkLines(item ESBulkItem) (error, []byte) {
+	if item.DocumentId == "" && item.Action != ESBulkIndex {
+		return fmt.Errorf("%v requires a document id", item.Action), nil
+	}
+	meta := map[string]interface{}{}
+	if item.DocumentId != "" {
+		meta["_id"] = item.DocumentId
+	}
+	action, err := json.Marshal(map[string]interface{}{string(item.Action): meta})
+	if err != nil {
+		return err, nil
+	}
+	lines := append(action, '\n')
+	switch item.Action {
+	case ESBulkDelete:
+		return nil, lines
+	case ESBulkIndex, ESBulkCreate, ESBulkUpdate:
+	default:
+		return fmt.Errorf("unknown bulk action %v", item.Action), nil
+	}
+	source := item.Body
+	if item.Action == ESBulkUpdate {
+		source, err = json.Marshal(map[string]json.RawMessage{"doc": item.Body})
+		if err != nil {
+			return err, nil
+		}
+	}
+	var compact bytes.Buffer
+	// a source spanning several lines would break the newline delimited body
+	if err := json.Compact(&compact, source); err != nil {
+		return err, nil
+	}
+	return nil, append(append(lines, compact.Bytes()...), '\n')
+}
+
+func (bi *ESBulkIndexer) delay(attempts int) time.Duration {
+	delay := bi.backoff
+	for i := 1; i < attempts && delay < bi.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > bi.maxDelay {
+		return bi.maxDelay
+	}
+	return delay
+}
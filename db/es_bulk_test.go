@@ -0,0 +1,363 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/byteintellect/go_commons/util"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bulkServer answers bulk requests, rejecting an item with 429 as long as reject returns true for it.
+type bulkServer struct {
+	mu       sync.Mutex
+	requests [][]string
+	reject   func(id string, attempt int) bool
+}
+
+func (s *bulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := map[string]int{}
+	for _, request := range s.requests {
+		for _, id := range request {
+			attempts[id]++
+		}
+	}
+	var ids []string
+	var items []map[string]interface{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		meta, ok := action[string(ESBulkIndex)]
+		if !ok {
+			http.Error(w, "expected index actions", http.StatusBadRequest)
+			return
+		}
+		// skip the source line
+		scanner.Scan()
+		id := meta["_id"].(string)
+		ids = append(ids, id)
+		result := map[string]interface{}{"_id": id, "status": http.StatusCreated}
+		if s.reject(id, attempts[id]+1) {
+			result = map[string]interface{}{
+				"_id":    id,
+				"status": http.StatusTooManyRequests,
+				"error":  map[string]interface{}{"type": "es_rejected_execution_exception", "reason": "queue is full"},
+			}
+		}
+		items = append(items, map[string]interface{}{string(ESBulkIndex): result})
+	}
+	s.requests = append(s.requests, ids)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+}
+
+func newBulkTestRepo(t *testing.T, server http.Handler, opts ...ElasticsearchRepoOption) *ElasticsearchRepo {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{httpServer.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewElasticsearchRepo(append([]ElasticsearchRepoOption{
+		WithClient(client),
+		WithIndex("widgets"),
+		WithMarshaller(&HttpBodyUtil{}),
+		WithStatusChecker(&HttpStatusChecker{}),
+		WithESLogger(logger),
+	}, opts...)...).(*ElasticsearchRepo)
+}
+
+// indexWidgets adds a document per id to a single batch indexer, returning the ids reported as succeeded and failed.
+func indexWidgets(t *testing.T, esr *ElasticsearchRepo, ids ...string) (succeeded []string, failed map[string]error, stats ESBulkStats) {
+	t.Helper()
+	var mu sync.Mutex
+	failed = map[string]error{}
+	ctx := context.Background()
+	indexer := esr.NewBulkIndexer(ctx,
+		WithBulkWorkers(1),
+		WithBulkBatchSize(len(ids)),
+		WithBulkFlushInterval(time.Hour),
+		WithBulkBackoff(3, time.Millisecond, time.Millisecond),
+		WithBulkOnSuccess(func(ctx context.Context, item ESBulkItem) {
+			mu.Lock()
+			defer mu.Unlock()
+			succeeded = append(succeeded, item.DocumentId)
+		}),
+		WithBulkOnFailure(func(ctx context.Context, item ESBulkItem, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed[item.DocumentId] = err
+		}),
+	)
+	for _, id := range ids {
+		if err := indexer.Add(ctx, ESBulkItem{Action: ESBulkIndex, DocumentId: id, Body: []byte(`{"name":"` + id + `"}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := indexer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(succeeded)
+	return succeeded, failed, indexer.Stats()
+}
+
+func TestBulkIndexerRetriesRejectedItems(t *testing.T) {
+	server := &bulkServer{reject: func(id string, attempt int) bool {
+		return id == "nut" && attempt == 1
+	}}
+	succeeded, failed, stats := indexWidgets(t, newBulkTestRepo(t, server), "bolt", "nut", "washer")
+
+	if !reflect.DeepEqual(succeeded, []string{"bolt", "nut", "washer"}) || len(failed) != 0 {
+		t.Fatalf("succeeded %v, failed %v", succeeded, failed)
+	}
+	// only the rejected item is sent again
+	if expected := [][]string{{"bolt", "nut", "washer"}, {"nut"}}; !reflect.DeepEqual(server.requests, expected) {
+		t.Fatalf("sent %v, expected %v", server.requests, expected)
+	}
+	if stats.Requests != 2 || stats.Retried != 1 || stats.Succeeded != 3 || stats.Failed != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBulkIndexerFailsItemsRejectedOnEveryAttempt(t *testing.T) {
+	server := &bulkServer{reject: func(id string, attempt int) bool {
+		return id == "nut"
+	}}
+	succeeded, failed, stats := indexWidgets(t, newBulkTestRepo(t, server), "bolt", "nut")
+
+	if !reflect.DeepEqual(succeeded, []string{"bolt"}) || len(failed) != 1 {
+		t.Fatalf("succeeded %v, failed %v", succeeded, failed)
+	}
+	var itemErr *ESBulkItemError
+	if !errors.As(failed["nut"], &itemErr) || itemErr.Status != http.StatusTooManyRequests {
+		t.Fatalf("expected the item to fail with 429, got %v", failed["nut"])
+	}
+	if stats.Requests != 3 || stats.Retried != 2 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// documentServerOptions returns the options of a repository over the widgets index kept by server.
+func documentServerOptions(t *testing.T, server *documentServer) []ElasticsearchRepoOption {
+	t.Helper()
+	esr := newBulkTestRepo(t, server)
+	return []ElasticsearchRepoOption{
+		WithClient(esr.client),
+		WithIndex("widgets"),
+		WithMarshaller(&HttpBodyUtil{}),
+		WithStatusChecker(&HttpStatusChecker{}),
+		WithESLogger(esr.logger),
+	}
+}
+
+// documentServer keeps the documents of the widgets index, answering index, get, update and delete requests, searches
+// matching every document not soft deleted, and bulk requests of create, index and delete actions.
+type documentServer struct {
+	mu      sync.Mutex
+	docs    map[string]map[string]interface{}
+	actions []string
+}
+
+func (s *documentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/_update") {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/widgets/_doc/"), "/_update")
+		var update struct {
+			Doc map[string]interface{} `json:"doc"`
+		}
+		json.NewDecoder(r.Body).Decode(&update)
+		for field, value := range update.Doc {
+			s.docs[id][field] = value
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"_index": "widgets", "_id": id, "result": "updated"})
+		return
+	}
+	if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/widgets/_doc/") {
+		id := strings.TrimPrefix(r.URL.Path, "/widgets/_doc/")
+		doc, found := s.docs[id]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"_index": "widgets", "_id": id, "found": found, "_source": doc})
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/widgets/_doc/") {
+		id := strings.TrimPrefix(r.URL.Path, "/widgets/_doc/")
+		_, exists := s.docs[id]
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(s.docs, id)
+		case r.URL.Query().Get("op_type") == "create" && exists:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			var doc map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&doc)
+			s.docs[id] = doc
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"_index": "widgets", "_id": id})
+		return
+	}
+	if r.Method == http.MethodPost && r.URL.Path == "/widgets/_search" {
+		ids := make([]string, 0, len(s.docs))
+		for id, doc := range s.docs {
+			if doc[esDeletedAtField] == nil {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		var hits []map[string]interface{}
+		for _, id := range ids {
+			hits = append(hits, map[string]interface{}{"_index": "widgets", "_id": id, "_source": s.docs[id]})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"hits": map[string]interface{}{"total": map[string]interface{}{"value": len(hits)}, "hits": hits}})
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		http.NotFound(w, r)
+		return
+	}
+	var items []map[string]interface{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var line map[string]map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for action, meta := range line {
+			id := meta["_id"].(string)
+			s.actions = append(s.actions, action+" "+id)
+			var doc map[string]interface{}
+			if action != string(ESBulkDelete) {
+				scanner.Scan()
+				json.Unmarshal(scanner.Bytes(), &doc)
+			}
+			_, exists := s.docs[id]
+			result := map[string]interface{}{"_id": id, "status": http.StatusOK}
+			switch {
+			case action == string(ESBulkCreate) && exists:
+				result["status"] = http.StatusConflict
+				result["error"] = map[string]interface{}{"type": "version_conflict_engine_exception", "reason": "document already exists"}
+			case action == string(ESBulkDelete):
+				delete(s.docs, id)
+			default:
+				s.docs[id] = doc
+			}
+			items = append(items, map[string]interface{}{action: result})
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+}
+
+func TestBulkIndexerKeepsTenantsApart(t *testing.T) {
+	server := &documentServer{docs: map[string]map[string]interface{}{}}
+	esr := newBulkTestRepo(t, server, WithESTenantField("tenant_id"), WithEntityConverter(func(from map[string]interface{}) entity.Base {
+		return &widget{Name: from["name"].(string), TenantId: from["tenant_id"].(string)}
+	}))
+	failed := map[string]error{}
+	run := func(fn func(indexer *ESBulkIndexer)) {
+		t.Helper()
+		indexer := esr.NewBulkIndexer(context.Background(), WithBulkOnFailure(func(ctx context.Context, item ESBulkItem, err error) {
+			failed[string(item.Action)+" "+item.DocumentId] = err
+		}))
+		fn(indexer)
+		if err := indexer.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bolt := &widget{Name: "bolt"}
+	bolt.ExternalId = "bolt"
+
+	run(func(indexer *ESBulkIndexer) {
+		if err := indexer.Index(tenantCtx("acme"), bolt); err != nil {
+			t.Fatal(err)
+		}
+	})
+	run(func(indexer *ESBulkIndexer) {
+		if err := indexer.Index(tenantCtx("globex"), &widget{BaseDomain: bolt.BaseDomain, Name: "forged"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := indexer.Delete(tenantCtx("globex"), "bolt"); err == nil {
+			t.Fatal("another tenant queued the deletion of the document")
+		}
+	})
+	var itemErr *ESBulkItemError
+	if !errors.As(failed["create bolt"], &itemErr) || itemErr.Status != http.StatusConflict {
+		t.Fatalf("expected the document of another tenant not to be overwritten, got %v", failed)
+	}
+	if doc := server.docs["bolt"]; doc["name"] != "bolt" || doc["tenant_id"] != "acme" {
+		t.Fatalf("document changed by another tenant: %v", doc)
+	}
+
+	run(func(indexer *ESBulkIndexer) {
+		if err := indexer.Delete(tenantCtx("acme"), "bolt"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, ok := server.docs["bolt"]; ok {
+		t.Fatal("the owner could not delete its document")
+	}
+	expected := []string{"create bolt", "create bolt", "delete bolt"}
+	if !reflect.DeepEqual(server.actions, expected) {
+		t.Fatalf("sent %v, expected %v", server.actions, expected)
+	}
+
+	// copies across tenants, such as those of a reindex, overwrite
+	run(func(indexer *ESBulkIndexer) {
+		if err := indexer.Index(util.WithAllTenants(context.Background()), bolt); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if last := server.actions[len(server.actions)-1]; last != "index bolt" {
+		t.Fatalf("sent %v for a copy across tenants, expected an index action", last)
+	}
+}
+
+func TestBulkIndexerIgnoresInvalidOptions(t *testing.T) {
+	server := &bulkServer{reject: func(id string, attempt int) bool {
+		return false
+	}}
+	esr := newBulkTestRepo(t, server)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	indexer := esr.NewBulkIndexer(ctx, WithBulkWorkers(0), WithBulkBatchSize(0), WithBulkFlushBytes(-1), WithBulkFlushInterval(0))
+	for _, id := range []string{"bolt", "nut"} {
+		if err := indexer.Add(ctx, ESBulkItem{Action: ESBulkIndex, DocumentId: id, Body: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := indexer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stats := indexer.Stats(); stats.Succeeded != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
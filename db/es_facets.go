@@ -0,0 +1,416 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+)
+
+const defaultFacetSearchSize = 10
+
+var calendarIntervals = map[string]bool{
+	"minute": true, "1m": true, "hour": true, "1h": true, "day": true, "1d": true, "week": true, "1w": true,
+	"month": true, "1M": true, "quarter": true, "1q": true, "year": true, "1y": true,
+}
+
+// ESAggregation is a node of the Elasticsearch aggregation DSL, its result is decoded into a Facet.
+type ESAggregation interface {
+	Source() map[string]interface{}
+	decode(raw json.RawMessage) (error, *Facet)
+}
+
+// FacetBucket is a bucket of a terms, date_histogram or range aggregation. From and To are only set
+// for range buckets, Facets holds the results of the sub aggregations.
+type FacetBucket struct {
+	Key         interface{}
+	KeyAsString string
+	DocCount    int64
+	From        *float64
+	To          *float64
+	Facets      map[string]*Facet
+}
+
+// FacetStats is the result of a stats aggregation, Min, Max and Avg are nil when no document has the field.
+type FacetStats struct {
+	Count int64    `json:"count"`
+	Min   *float64 `json:"min"`
+	Max   *float64 `json:"max"`
+	Avg   *float64 `json:"avg"`
+	Sum   float64  `json:"sum"`
+}
+
+// Facet is the decoded result of an ESAggregation: Buckets for bucket aggregations, Stats for stats,
+// Value for cardinality, DocCount and Facets for nested.
+type Facet struct {
+	Buckets  []FacetBucket
+	Stats    *FacetStats
+	Value    int64
+	DocCount int64
+	Facets   map[string]*Facet
+}
+
+type esSubAggregations map[string]ESAggregation
+
+func (s esSubAggregations) source(source map[string]interface{}) map[string]interface{} {
+	if len(s) > 0 {
+		source["aggs"] = aggregationSources(s)
+	}
+	return source
+}
+
+func (s esSubAggregations) decode(raw map[string]json.RawMessage) (error, map[string]*Facet) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	facets := make(map[string]*Facet, len(s))
+	for name, aggregation := range s {
+		err, facet := aggregation.decode(raw[name])
+		if err != nil {
+			return err, nil
+		}
+		facets[name] = facet
+	}
+	return nil, facets
+}
+
+// decodeBuckets decodes the buckets of a terms, date_histogram or range aggregation.
+func (s esSubAggregations) decodeBuckets(raw json.RawMessage) (error, *Facet) {
+	var response struct {
+		Buckets []map[string]json.RawMessage `json:"buckets"`
+	}
+	if err := unmarshalAggregation(raw, &response); err != nil {
+		return err, nil
+	}
+	facet := &Facet{}
+	for _, rawBucket := range response.Buckets {
+		var bucket FacetBucket
+		for field, target := range map[string]interface{}{
+			"key": &bucket.Key, "key_as_string": &bucket.KeyAsString, "doc_count": &bucket.DocCount, "from": &bucket.From, "to": &bucket.To,
+		} {
+			if value, ok := rawBucket[field]; ok {
+				if err := json.Unmarshal(value, target); err != nil {
+					return err, nil
+				}
+			}
+		}
+		err, facets := s.decode(rawBucket)
+		if err != nil {
+			return err, nil
+		}
+		bucket.Facets = facets
+		facet.Buckets = append(facet.Buckets, bucket)
+	}
+	return nil, facet
+}
+
+// ESTermsAggregation buckets documents by the distinct values of field, the Size most frequent first.
+type ESTermsAggregation struct {
+	Field string
+	size  int
+	subs  esSubAggregations
+}
+
+func ESTermsAgg(field string) *ESTermsAggregation {
+	return &ESTermsAggregation{Field: field}
+}
+
+func (a *ESTermsAggregation) Size(size int) *ESTermsAggregation {
+	a.size = size
+	return a
+}
+
+func (a *ESTermsAggregation) Sub(name string, aggregation ESAggregation) *ESTermsAggregation {
+	if a.subs == nil {
+		a.subs = esSubAggregations{}
+	}
+	a.subs[name] = aggregation
+	return a
+}
+
+func (a *ESTermsAggregation) Source() map[string]interface{} {
+	params := map[string]interface{}{"field": a.Field}
+	if a.size > 0 {
+		params["size"] = a.size
+	}
+	return a.subs.source(map[string]interface{}{"terms": params})
+}
+
+func (a *ESTermsAggregation) decode(raw json.RawMessage) (error, *Facet) {
+	return a.subs.decodeBuckets(raw)
+}
+
+// ESDateHistogramAggregation buckets documents by date, calendar intervals such as "month" or "1d" follow the calendar,
+// other intervals such as "90m" are fixed.
+type ESDateHistogramAggregation struct {
+	Field    string
+	Interval string
+	format   string
+	timeZone string
+	subs     esSubAggregations
+}
+
+func ESDateHistogramAgg(field, interval string) *ESDateHistogramAggregation {
+	return &ESDateHistogramAggregation{Field: field, Interval: interval}
+}
+
+// Format sets the date format of the bucket keys as strings.
+func (a *ESDateHistogramAggregation) Format(format string) *ESDateHistogramAggregation {
+	a.format = format
+	return a
+}
+
+func (a *ESDateHistogramAggregation) TimeZone(timeZone string) *ESDateHistogramAggregation {
+	a.timeZone = timeZone
+	return a
+}
+
+func (a *ESDateHistogramAggregation) Sub(name string, aggregation ESAggregation) *ESDateHistogramAggregation {
+	if a.subs == nil {
+		a.subs = esSubAggregations{}
+	}
+	a.subs[name] = aggregation
+	return a
+}
+
+func (a *ESDateHistogramAggregation) Source() map[string]interface{} {
+	params := map[string]interface{}{"field": a.Field}
+	if calendarIntervals[a.Interval] {
+		params["calendar_interval"] = a.Interval
+	} else {
+		params["fixed_interval"] = a.Interval
+	}
+	if a.format != "" {
+		params["format"] = a.format
+	}
+	if a.timeZone != "" {
+		params["time_zone"] = a.timeZone
+	}
+	return a.subs.source(map[string]interface{}{"date_histogram": params})
+}
+
+func (a *ESDateHistogramAggregation) decode(raw json.RawMessage) (error, *Facet) {
+	return a.subs.decodeBuckets(raw)
+}
+
+type esRange struct {
+	Key  string      `json:"key,omitempty"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// ESRangeAggregation buckets documents by ranges of field, from inclusive and to exclusive.
+type ESRangeAggregation struct {
+	Field  string
+	ranges []esRange
+	subs   esSubAggregations
+}
+
+func ESRangeAgg(field string) *ESRangeAggregation {
+	return &ESRangeAggregation{Field: field}
+}
+
+// Range adds a bucket, a nil from or to leaves the range open on that side.
+func (a *ESRangeAggregation) Range(key string, from, to interface{}) *ESRangeAggregation {
+	a.ranges = append(a.ranges, esRange{Key: key, From: from, To: to})
+	return a
+}
+
+func (a *ESRangeAggregation) Sub(name string, aggregation ESAggregation) *ESRangeAggregation {
+	if a.subs == nil {
+		a.subs = esSubAggregations{}
+	}
+	a.subs[name] = aggregation
+	return a
+}
+
+func (a *ESRangeAggregation) Source() map[string]interface{} {
+	return a.subs.source(map[string]interface{}{"range": map[string]interface{}{"field": a.Field, "ranges": a.ranges}})
+}
+
+func (a *ESRangeAggregation) decode(raw json.RawMessage) (error, *Facet) {
+	return a.subs.decodeBuckets(raw)
+}
+
+// ESStatsAggregation computes the count, min, max, average and sum of field.
+type ESStatsAggregation struct {
+	Field string
+}
+
+func ESStatsAgg(field string) *ESStatsAggregation {
+	return &ESStatsAggregation{Field: field}
+}
+
+func (a *ESStatsAggregation) Source() map[string]interface{} {
+	return map[string]interface{}{"stats": map[string]interface{}{"field": a.Field}}
+}
+
+func (a *ESStatsAggregation) decode(raw json.RawMessage) (error, *Facet) {
+	var stats FacetStats
+	if err := unmarshalAggregation(raw, &stats); err != nil {
+		return err, nil
+	}
+	return nil, &Facet{Stats: &stats}
+}
+
+// ESCardinalityAggregation approximates the number of distinct values of field.
+type ESCardinalityAggregation struct {
+	Field string
+}
+
+func ESCardinalityAgg(field string) *ESCardinalityAggregation {
+	return &ESCardinalityAggregation{Field: field}
+}
+
+func (a *ESCardinalityAggregation) Source() map[string]interface{} {
+	return map[string]interface{}{"cardinality": map[string]interface{}{"field": a.Field}}
+}
+
+func (a *ESCardinalityAggregation) decode(raw json.RawMessage) (error, *Facet) {
+	var response struct {
+		Value int64 `json:"value"`
+	}
+	if err := unmarshalAggregation(raw, &response); err != nil {
+		return err, nil
+	}
+	return nil, &Facet{Value: response.Value}
+}
+
+// ESNestedAggregation runs its sub aggregations over the nested objects under path.
+type ESNestedAggregation struct {
+	Path string
+	subs esSubAggregations
+}
+
+func ESNestedAgg(path string) *ESNestedAggregation {
+	return &ESNestedAggregation{Path: path}
+}
+
+func (a *ESNestedAggregation) Sub(name string, aggregation ESAggregation) *ESNestedAggregation {
+	if a.subs == nil {
+		a.subs = esSubAggregations{}
+	}
+	a.subs[name] = aggregation
+	return a
+}
+
+func (a *ESNestedAggregation) Source() map[string]interface{} {
+	return a.subs.source(map[string]interface{}{"nested": map[string]interface{}{"path": a.Path}})
+}
+
+func (a *ESNestedAggregation) decode(raw json.RawMessage) (error, *Facet) {
+	var response map[string]json.RawMessage
+	if err := unmarshalAggregation(raw, &response); err != nil {
+		return err, nil
+	}
+	facet := &Facet{}
+	if err := json.Unmarshal(response["doc_count"], &facet.DocCount); err != nil {
+		return err, nil
+	}
+	err, facets := a.subs.decode(response)
+	if err != nil {
+		return err, nil
+	}
+	facet.Facets = facets
+	return nil, facet
+}
+
+func aggregationSources(aggregations map[string]ESAggregation) map[string]interface{} {
+	sources := make(map[string]interface{}, len(aggregations))
+	for name, aggregation := range aggregations {
+		sources[name] = aggregation.Source()
+	}
+	return sources
+}
+
+func unmarshalAggregation(raw json.RawMessage, target interface{}) error {
+	if raw == nil {
+		return errors.New("missing aggregation in response")
+	}
+	return json.Unmarshal(raw, target)
+}
+
+// FacetSearch returns a page of the documents matching Query together with the facets computed over all of them.
+type FacetSearch struct {
+	Query  ESClause
+	Sort   []SortField
+	From   int
+	Size   int
+	Facets map[string]ESAggregation
+}
+
+func NewFacetSearch(query ESClause) *FacetSearch {
+	return &FacetSearch{Query: query, Size: defaultFacetSearchSize, Facets: map[string]ESAggregation{}}
+}
+
+func (s *FacetSearch) Facet(name string, aggregation ESAggregation) *FacetSearch {
+	s.Facets[name] = aggregation
+	return s
+}
+
+func (s *FacetSearch) OrderBy(field string, desc bool) *FacetSearch {
+	s.Sort = append(s.Sort, SortField{Field: field, Desc: desc})
+	return s
+}
+
+// Page sets the hits returned, a zero size returns the facets only.
+func (s *FacetSearch) Page(from, size int) *FacetSearch {
+	s.From, s.Size = from, size
+	return s
+}
+
+func (s *FacetSearch) Validate() error {
+	if s.From < 0 || s.Size < 0 {
+		return errors.New("from and size must not be negative")
+	}
+	for name := range s.Facets {
+		if !aliasPattern.MatchString(name) {
+			return fmt.Errorf("invalid facet name %v", name)
+		}
+	}
+	return nil
+}
+
+// FacetedResult holds a page of hits, the total number of matching documents and the facets by name.
+type FacetedResult struct {
+	Items  []entity.Base
+	Total  int64
+	Facets map[string]*Facet
+}
+
+// FacetSearch runs the search, the hits and the facets are restricted like every other read to the tenant
+// in ctx and to documents not soft deleted.
+func (esr *ElasticsearchRepo) FacetSearch(ctx context.Context, search *FacetSearch) (error, *FacetedResult) {
+	if err := search.Validate(); err != nil {
+		return err, nil
+	}
+	err, body := esr.queryBody(ctx, search.Query, search.Sort)
+	if err != nil {
+		return err, nil
+	}
+	if search.From > 0 {
+		body["from"] = search.From
+	}
+	body["size"] = search.Size
+	body["track_total_hits"] = true
+	if len(search.Facets) > 0 {
+		body["aggs"] = aggregationSources(search.Facets)
+	}
+	err, response := esr.doSearch(ctx, body)
+	if err != nil {
+		return err, nil
+	}
+	result := &FacetedResult{Total: int64(response.Hits.Total.Value), Facets: make(map[string]*Facet, len(search.Facets))}
+	for _, hit := range response.Hits.Hits {
+		result.Items = append(result.Items, esr.entityConverter(hit.Source))
+	}
+	for name, aggregation := range search.Facets {
+		err, facet := aggregation.decode(response.Aggregations[name])
+		if err != nil {
+			return err, nil
+		}
+		result.Facets[name] = facet
+	}
+	return nil, result
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// searchServer answers search requests with the response returned by respond for the decoded body.
+type searchServer struct {
+	mu      sync.Mutex
+	bodies  []map[string]interface{}
+	respond func(body map[string]interface{}) string
+}
+
+func (s *searchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/_search") {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.bodies = append(s.bodies, body)
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, s.respond(body))
+}
+
+func newSearchTestRepo(t *testing.T, server *searchServer) *ElasticsearchRepo {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{httpServer.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewElasticsearchRepo(
+		WithClient(client),
+		WithIndex("widgets"),
+		WithMarshaller(&HttpBodyUtil{}),
+		WithStatusChecker(&HttpStatusChecker{}),
+		WithESLogger(logger),
+		WithEntityConverter(func(from map[string]interface{}) entity.Base {
+			return &widget{Name: from["name"].(string)}
+		}),
+	).(*ElasticsearchRepo)
+}
+
+// jsonValue decodes source into the generic values a decoded request body holds.
+func jsonValue(t *testing.T, source string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(source), &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+const facetResponse = `{
+	"hits": {"total": {"value": 42, "relation": "eq"}, "hits": [{"_id": "1", "_source": {"name": "bolt"}}]},
+	"aggregations": {
+		"colors": {"buckets": [
+			{"key": "red", "doc_count": 30, "price": {"count": 30, "min": 1, "max": 9, "avg": 4.5, "sum": 135}},
+			{"key": "blue", "doc_count": 12, "price": {"count": 12, "min": null, "max": null, "avg": null, "sum": 0}}
+		]},
+		"sold": {"buckets": [
+			{"key": 1640995200000, "key_as_string": "2022-01", "doc_count": 40},
+			{"key": 1643673600000, "key_as_string": "2022-02", "doc_count": 2}
+		]},
+		"prices": {"buckets": [
+			{"key": "cheap", "to": 5, "doc_count": 10},
+			{"key": "pricey", "from": 5, "doc_count": 32}
+		]},
+		"quantity": {"count": 42, "min": 0, "max": 100, "avg": 25.5, "sum": 1071},
+		"makers": {"value": 7},
+		"parts": {"doc_count": 120, "skus": {"buckets": [{"key": "M8", "doc_count": 80}]}}
+	}
+}`
+
+func TestFacetSearch(t *testing.T) {
+	server := &searchServer{respond: func(map[string]interface{}) string {
+		return facetResponse
+	}}
+	esr := newSearchTestRepo(t, server)
+	search := NewFacetSearch(ESMatch("name", "bolt")).
+		Page(10, 5).
+		OrderBy("name", false).
+		Facet("colors", ESTermsAgg("color").Size(3).Sub("price", ESStatsAgg("price"))).
+		Facet("sold", ESDateHistogramAgg("sold_at", "month").Format("yyyy-MM").TimeZone("Europe/Berlin")).
+		Facet("prices", ESRangeAgg("price").Range("cheap", nil, 5).Range("pricey", 5, nil)).
+		Facet("quantity", ESStatsAgg("quantity")).
+		Facet("makers", ESCardinalityAgg("maker")).
+		Facet("parts", ESNestedAgg("parts").Sub("skus", ESTermsAgg("parts.sku")))
+
+	err, result := esr.FacetSearch(context.Background(), search)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.bodies) != 1 {
+		t.Fatalf("expected a single search, got %v", server.bodies)
+	}
+	body := server.bodies[0]
+	expectedAggs := jsonValue(t, `{
+		"colors": {"terms": {"field": "color", "size": 3}, "aggs": {"price": {"stats": {"field": "price"}}}},
+		"sold": {"date_histogram": {"field": "sold_at", "calendar_interval": "month", "format": "yyyy-MM", "time_zone": "Europe/Berlin"}},
+		"prices": {"range": {"field": "price", "ranges": [{"key": "cheap", "to": 5}, {"key": "pricey", "from": 5}]}},
+		"quantity": {"stats": {"field": "quantity"}},
+		"makers": {"cardinality": {"field": "maker"}},
+		"parts": {"nested": {"path": "parts"}, "aggs": {"skus": {"terms": {"field": "parts.sku"}}}}
+	}`)
+	if !reflect.DeepEqual(body["aggs"], expectedAggs) {
+		t.Fatalf("sent aggregations %v\nexpected %v", body["aggs"], expectedAggs)
+	}
+	if body["from"] != 10.0 || body["size"] != 5.0 || body["track_total_hits"] != true {
+		t.Fatalf("unexpected page in %v", body)
+	}
+	if expected := jsonValue(t, `[{"name": {"order": "asc"}}]`); !reflect.DeepEqual(body["sort"], expected) {
+		t.Fatalf("sent sort %v, expected %v", body["sort"], expected)
+	}
+
+	if result.Total != 42 || len(result.Items) != 1 || result.Items[0].(*widget).Name != "bolt" {
+		t.Fatalf("unexpected hits %+v", result)
+	}
+	colors := result.Facets["colors"]
+	if len(colors.Buckets) != 2 || colors.Buckets[0].Key != "red" || colors.Buckets[0].DocCount != 30 {
+		t.Fatalf("unexpected terms buckets %+v", colors.Buckets)
+	}
+	if price := colors.Buckets[0].Facets["price"].Stats; price.Count != 30 || *price.Min != 1 || *price.Avg != 4.5 || price.Sum != 135 {
+		t.Fatalf("unexpected sub aggregation stats %+v", price)
+	}
+	if price := colors.Buckets[1].Facets["price"].Stats; price.Min != nil || price.Max != nil || price.Avg != nil {
+		t.Fatalf("expected no min, max and average without values, got %+v", price)
+	}
+	sold := result.Facets["sold"]
+	if len(sold.Buckets) != 2 || sold.Buckets[0].Key != 1640995200000.0 || sold.Buckets[0].KeyAsString != "2022-01" || sold.Buckets[1].DocCount != 2 {
+		t.Fatalf("unexpected date histogram buckets %+v", sold.Buckets)
+	}
+	prices := result.Facets["prices"]
+	if len(prices.Buckets) != 2 || prices.Buckets[0].From != nil || *prices.Buckets[0].To != 5 || *prices.Buckets[1].From != 5 || prices.Buckets[1].To != nil {
+		t.Fatalf("unexpected range buckets %+v", prices.Buckets)
+	}
+	if quantity := result.Facets["quantity"].Stats; quantity.Count != 42 || *quantity.Min != 0 || *quantity.Max != 100 || quantity.Sum != 1071 {
+		t.Fatalf("unexpected stats %+v", quantity)
+	}
+	if makers := result.Facets["makers"]; makers.Value != 7 {
+		t.Fatalf("unexpected cardinality %+v", makers)
+	}
+	parts := result.Facets["parts"]
+	if parts.DocCount != 120 || len(parts.Facets["skus"].Buckets) != 1 || parts.Facets["skus"].Buckets[0].Key != "M8" {
+		t.Fatalf("unexpected nested facet %+v", parts)
+	}
+}
+
+func TestFacetSearchFixedIntervalAndFacetsOnly(t *testing.T) {
+	server := &searchServer{respond: func(map[string]interface{}) string {
+		return `{"hits": {"total": {"value": 3}, "hits": []}, "aggregations": {"sold": {"buckets": []}}}`
+	}}
+	esr := newSearchTestRepo(t, server)
+	search := NewFacetSearch(nil).Page(0, 0).Facet("sold", ESDateHistogramAgg("sold_at", "90m"))
+	err, result := esr.FacetSearch(context.Background(), search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := server.bodies[0]
+	if expected := jsonValue(t, `{"sold": {"date_histogram": {"field": "sold_at", "fixed_interval": "90m"}}}`); !reflect.DeepEqual(body["aggs"], expected) {
+		t.Fatalf("sent aggregations %v, expected %v", body["aggs"], expected)
+	}
+	if _, ok := body["from"]; ok || body["size"] != 0.0 {
+		t.Fatalf("expected the facets only, sent %v", body)
+	}
+	if result.Total != 3 || len(result.Items) != 0 || len(result.Facets["sold"].Buckets) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestFacetSearchFailsOnMissingAggregation(t *testing.T) {
+	server := &searchServer{respond: func(map[string]interface{}) string {
+		return `{"hits": {"total": {"value": 0}, "hits": []}, "aggregations": {}}`
+	}}
+	esr := newSearchTestRepo(t, server)
+	search := NewFacetSearch(nil).Facet("makers", ESCardinalityAgg("maker"))
+	if err, result := esr.FacetSearch(context.Background(), search); err == nil {
+		t.Fatalf("expected a missing aggregation to fail, got %+v", result)
+	}
+	if err, _ := esr.FacetSearch(context.Background(), NewFacetSearch(nil).Facet("bad name", ESCardinalityAgg("maker"))); err == nil {
+		t.Fatal("expected an invalid facet name to fail")
+	}
+}
@@ -0,0 +1,367 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const esTag = "es"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+var esKindTypes = map[reflect.Kind]string{
+	reflect.Bool:    "boolean",
+	reflect.Int:     "long",
+	reflect.Int8:    "byte",
+	reflect.Int16:   "short",
+	reflect.Int32:   "integer",
+	reflect.Int64:   "long",
+	reflect.Uint:    "unsigned_long",
+	reflect.Uint8:   "short",
+	reflect.Uint16:  "integer",
+	reflect.Uint32:  "long",
+	reflect.Uint64:  "unsigned_long",
+	reflect.Float32: "float",
+	reflect.Float64: "double",
+}
+
+// ESProperty is the mapping of a single field.
+type ESProperty struct {
+	Type           string                 `json:"type,omitempty"`
+	Analyzer       string                 `json:"analyzer,omitempty"`
+	SearchAnalyzer string                 `json:"search_analyzer,omitempty"`
+	Index          *bool                  `json:"index,omitempty"`
+	CopyTo         []string               `json:"copy_to,omitempty"`
+	Format         string                 `json:"format,omitempty"`
+	Fields         map[string]*ESProperty `json:"fields,omitempty"`
+	Properties     map[string]*ESProperty `json:"properties,omitempty"`
+}
+
+// ESMapping is the mappings section of an index.
+type ESMapping struct {
+	Properties map[string]*ESProperty `json:"properties"`
+}
+
+// ESMappingOf generates the mapping of a struct from its fields, named like their json encoding. Fields of embedded
+// structs such as entity.BaseDomain are inlined, pointers are mapped like the value they point to and time.Time as date.
+// Strings are text with a keyword sub field, structs and slices of structs are objects, maps are dynamic objects.
+//
+// The es tag overrides the defaults with comma separated options:
+//
+//	type=<type>               the field type, such as keyword, date or geo_point
+//	keyword                   a keyword only string
+//	analyzer=<analyzer>       the index time analyzer of a text field
+//	search_analyzer=<name>    the search time analyzer of a text field
+//	index=false               the field is stored but not searchable
+//	copy_to=<field>|<field>   copies the value into the given fields
+//	format=<format>           the date format, such as strict_date_optional_time||epoch_millis
+//	nested                    a struct or slice of structs indexed as nested documents
+//	object                    a struct or slice of structs indexed as an object, the default
+//
+// A field tagged es:"-" is left out of the mapping. The type, analyzer and search_analyzer tags are still honoured
+// for fields without an es tag.
+func ESMappingOf(v interface{}) (error, *ESMapping) {
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot map %v, expected a struct", typ), nil
+	}
+	properties := make(map[string]*ESProperty)
+	if err := structProperties(typ, properties, map[reflect.Type]bool{}); err != nil {
+		return err, nil
+	}
+	return nil, &ESMapping{Properties: properties}
+}
+
+func structProperties(typ reflect.Type, properties map[string]*ESProperty, visiting map[reflect.Type]bool) error {
+	if visiting[typ] {
+		return fmt.Errorf("cannot map %v, it refers to itself", typ)
+	}
+	visiting[typ] = true
+	defer delete(visiting, typ)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || field.Tag.Get(esTag) == "-" {
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			if err := structProperties(fieldType, properties, visiting); err != nil {
+				return err
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		err, property := fieldProperty(field, visiting)
+		if err != nil {
+			return fmt.Errorf("%v.%v: %w", typ.Name(), field.Name, err)
+		}
+		if property != nil {
+			properties[name] = property
+		}
+	}
+	return nil
+}
+
+type esFieldOptions struct {
+	typ            string
+	keyword        bool
+	analyzer       string
+	searchAnalyzer string
+	index          *bool
+	copyTo         []string
+	format         string
+	nested         bool
+	object         bool
+}
+
+func parseESTag(field reflect.StructField) (error, *esFieldOptions) {
+	tag, ok := field.Tag.Lookup(esTag)
+	if !ok {
+		// fields predating the es tag
+		return nil, &esFieldOptions{
+			typ:            field.Tag.Get("type"),
+			analyzer:       field.Tag.Get("analyzer"),
+			searchAnalyzer: field.Tag.Get("search_analyzer"),
+		}
+	}
+	options := &esFieldOptions{}
+	for _, option := range strings.Split(tag, ",") {
+		key, value := option, ""
+		if i := strings.Index(option, "="); i >= 0 {
+			key, value = option[:i], option[i+1:]
+		}
+		switch key {
+		case "":
+		case "type":
+			options.typ = value
+		case "keyword":
+			options.keyword = true
+		case "analyzer":
+			options.analyzer = value
+		case "search_analyzer":
+			options.searchAnalyzer = value
+		case "index":
+			if value != "true" && value != "false" {
+				return fmt.Errorf("invalid es tag option %v", option), nil
+			}
+			index := value == "true"
+			options.index = &index
+		case "copy_to":
+			options.copyTo = strings.Split(value, "|")
+		case "format":
+			options.format = value
+		case "nested":
+			options.nested = true
+		case "object":
+			options.object = true
+		default:
+			return fmt.Errorf("unknown es tag option %v", option), nil
+		}
+	}
+	if options.nested && options.object {
+		return errors.New("a field cannot be both nested and object"), nil
+	}
+	return nil, options
+}
+
+// fieldProperty returns the mapping of the field, nil for fields left to dynamic mapping such as interfaces.
+func fieldProperty(field reflect.StructField, visiting map[reflect.Type]bool) (error, *ESProperty) {
+	err, options := parseESTag(field)
+	if err != nil {
+		return err, nil
+	}
+	typ := field.Type
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	// the mapping of a slice is the mapping of its elements, except for []byte which is encoded as base64
+	if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return nil, options.apply(&ESProperty{Type: "binary"})
+		}
+		typ = typ.Elem()
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+	}
+	property := &ESProperty{}
+	switch {
+	case options.typ != "" && options.typ != "nested" && options.typ != "object":
+		property.Type = options.typ
+	case typ == timeType:
+		property.Type = "date"
+	case typ.Kind() == reflect.String:
+		if options.keyword {
+			property.Type = "keyword"
+		} else {
+			property.Type = "text"
+			property.Fields = map[string]*ESProperty{"keyword": {Type: "keyword", Index: options.index}}
+		}
+	case typ.Kind() == reflect.Struct:
+		property.Properties = make(map[string]*ESProperty)
+		if err := structProperties(typ, property.Properties, visiting); err != nil {
+			return err, nil
+		}
+		if options.nested || options.typ == "nested" {
+			property.Type = "nested"
+		}
+	case typ.Kind() == reflect.Map:
+		property.Type = "object"
+	case typ.Kind() == reflect.Interface:
+		return nil, nil
+	default:
+		esType, ok := esKindTypes[typ.Kind()]
+		if !ok {
+			return fmt.Errorf("cannot map a %v", typ.Kind()), nil
+		}
+		property.Type = esType
+	}
+	return nil, options.apply(property)
+}
+
+func (o *esFieldOptions) apply(property *ESProperty) *ESProperty {
+	property.Analyzer = o.analyzer
+	property.SearchAnalyzer = o.searchAnalyzer
+	property.Index = o.index
+	property.CopyTo = o.copyTo
+	property.Format = o.format
+	return property
+}
+
+// analysedFields returns the text fields outside nested documents with their analyzers, the fields TextSearch matches.
+func (m *ESMapping) analysedFields() map[string]FieldAnalysis {
+	fields := make(map[string]FieldAnalysis)
+	var walk func(prefix string, properties map[string]*ESProperty)
+	walk = func(prefix string, properties map[string]*ESProperty) {
+		for name, property := range properties {
+			switch {
+			case property.Type == "text" && (property.Index == nil || *property.Index):
+				fields[prefix+name] = FieldAnalysis{Analyzer: property.Analyzer, SearchAnalyzer: property.SearchAnalyzer}
+			case property.Type == "" || property.Type == "object":
+				walk(prefix+name+".", property.Properties)
+			}
+		}
+	}
+	walk("", m.Properties)
+	return fields
+}
+
+type MappingChange string
+
+const (
+	// MappingMissing is a field of the generated mapping missing from the index, it can be added in place.
+	MappingMissing MappingChange = "missing"
+	// MappingUnexpected is a field of the index missing from the generated mapping.
+	MappingUnexpected MappingChange = "unexpected"
+	// MappingChanged is a field mapped differently in the index, changing it requires a reindex.
+	MappingChanged MappingChange = "changed"
+)
+
+// MappingDifference is a field whose mapping in the index differs from the generated mapping, Path is dotted.
+type MappingDifference struct {
+	Path     string
+	Change   MappingChange
+	Expected *ESProperty
+	Actual   *ESProperty
+}
+
+// MappingDiff compares the mapping generated from the default entity with the mapping of the live index.
+// Differences are sorted by path, an empty result means the index is up to date.
+func (esr *ElasticsearchRepo) MappingDiff(ctx context.Context) (error, []MappingDifference) {
+	err, expected := ESMappingOf(esr.defaultEntity)
+	if err != nil {
+		return err, nil
+	}
+	var indices map[string]struct {
+		Mappings ESMapping `json:"mappings"`
+	}
+	if err := esr.perform(ctx, esapi.IndicesGetMappingRequest{Index: []string{esr.index}}, "reading mapping", &indices); err != nil {
+		return err, nil
+	}
+	if len(indices) != 1 {
+		return fmt.Errorf("%v resolves to %v indices", esr.index, len(indices)), nil
+	}
+	var differences []MappingDifference
+	for _, index := range indices {
+		differences = diffProperties("", expected.Properties, index.Mappings.Properties)
+	}
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Path < differences[j].Path
+	})
+	return nil, differences
+}
+
+func diffProperties(prefix string, expected, actual map[string]*ESProperty) []MappingDifference {
+	var differences []MappingDifference
+	for name, want := range expected {
+		path := prefix + name
+		got, ok := actual[name]
+		if !ok {
+			differences = append(differences, MappingDifference{Path: path, Change: MappingMissing, Expected: want})
+			continue
+		}
+		if !sameProperty(want, got) {
+			differences = append(differences, MappingDifference{Path: path, Change: MappingChanged, Expected: want, Actual: got})
+		}
+		if want.Type == "object" && want.Properties == nil {
+			// a map, its properties are mapped dynamically
+			continue
+		}
+		differences = append(differences, diffProperties(path+".", want.Properties, got.Properties)...)
+		differences = append(differences, diffProperties(path+".", want.Fields, got.Fields)...)
+	}
+	for name, got := range actual {
+		if _, ok := expected[name]; !ok {
+			differences = append(differences, MappingDifference{Path: prefix + name, Change: MappingUnexpected, Actual: got})
+		}
+	}
+	return differences
+}
+
+// sameProperty compares the settings of two field mappings, leaving their sub fields and properties out.
+func sameProperty(want, got *ESProperty) bool {
+	typeOf := func(p *ESProperty) string {
+		if p.Type == "" && p.Properties != nil {
+			return "object"
+		}
+		return p.Type
+	}
+	indexed := func(p *ESProperty) bool {
+		return p.Index == nil || *p.Index
+	}
+	return typeOf(want) == typeOf(got) && want.Analyzer == got.Analyzer && want.SearchAnalyzer == got.SearchAnalyzer &&
+		indexed(want) == indexed(got) && want.Format == got.Format && reflect.DeepEqual(want.CopyTo, got.CopyTo)
+}
+
+// mappingDocument returns the settings and mappings of the index, derived from the default entity.
+func (esr *ElasticsearchRepo) mappingDocument() (error, []byte) {
+	err, mapping := ESMappingOf(esr.defaultEntity)
+	if err != nil {
+		return err, nil
+	}
+	mappingBytes, err := json.Marshal(map[string]interface{}{"mappings": mapping, "settings": esr.settings})
+	if err != nil {
+		return err, nil
+	}
+	esr.logger.Infof("Mappings %v", string(mappingBytes))
+	return nil, mappingBytes
+}
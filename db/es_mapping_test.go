@@ -0,0 +1,168 @@
+package db
+
+import (
+	"encoding/json"
+	"github.com/byteintellect/go_commons/entity"
+	"testing"
+	"time"
+)
+
+type mappedPart struct {
+	Sku  string `json:"sku" es:"keyword"`
+	Size int    `json:"size"`
+}
+
+type mappedNode struct {
+	Children []mappedNode `json:"children"`
+}
+
+func TestESMappingOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{name: "string", value: struct {
+			Name string `json:"name"`
+		}{}, expected: `{"name":{"type":"text","fields":{"keyword":{"type":"keyword"}}}}`},
+		{name: "keyword", value: struct {
+			Name string `json:"name" es:"keyword"`
+		}{}, expected: `{"name":{"type":"keyword"}}`},
+		{name: "type", value: struct {
+			Location string `json:"location" es:"type=geo_point"`
+		}{}, expected: `{"location":{"type":"geo_point"}}`},
+		{name: "analyzers", value: struct {
+			Name string `json:"name" es:"analyzer=autocomplete,search_analyzer=standard"`
+		}{}, expected: `{"name":{"type":"text","analyzer":"autocomplete","search_analyzer":"standard","fields":{"keyword":{"type":"keyword"}}}}`},
+		{name: "index", value: struct {
+			Notes string `json:"notes" es:"index=false"`
+		}{}, expected: `{"notes":{"type":"text","index":false,"fields":{"keyword":{"type":"keyword","index":false}}}}`},
+		{name: "copy to", value: struct {
+			Name string `json:"name" es:"keyword,copy_to=all|names"`
+		}{}, expected: `{"name":{"type":"keyword","copy_to":["all","names"]}}`},
+		{name: "format", value: struct {
+			SoldAt time.Time `json:"sold_at" es:"format=epoch_millis"`
+		}{}, expected: `{"sold_at":{"type":"date","format":"epoch_millis"}}`},
+		{name: "nested", value: struct {
+			Parts []mappedPart `json:"parts" es:"nested"`
+		}{}, expected: `{"parts":{"type":"nested","properties":{"size":{"type":"long"},"sku":{"type":"keyword"}}}}`},
+		{name: "nested type", value: struct {
+			Parts []mappedPart `json:"parts" es:"type=nested"`
+		}{}, expected: `{"parts":{"type":"nested","properties":{"size":{"type":"long"},"sku":{"type":"keyword"}}}}`},
+		{name: "object", value: struct {
+			Part mappedPart `json:"part" es:"object"`
+		}{}, expected: `{"part":{"properties":{"size":{"type":"long"},"sku":{"type":"keyword"}}}}`},
+		{name: "struct", value: struct {
+			Part *mappedPart `json:"part"`
+		}{}, expected: `{"part":{"properties":{"size":{"type":"long"},"sku":{"type":"keyword"}}}}`},
+		{name: "skipped", value: struct {
+			Name   string `json:"name" es:"-"`
+			Secret string `json:"-"`
+			hidden string
+		}{}, expected: `{}`},
+		{name: "untagged name", value: struct {
+			Name string `es:"keyword"`
+		}{}, expected: `{"Name":{"type":"keyword"}}`},
+		{name: "legacy tags", value: struct {
+			Name string `json:"name" type:"keyword" analyzer:"autocomplete"`
+		}{}, expected: `{"name":{"type":"keyword","analyzer":"autocomplete"}}`},
+		{name: "pointers", value: struct {
+			Quantity **int     `json:"quantity"`
+			Name     *string   `json:"name" es:"keyword"`
+			Tags     []*string `json:"tags" es:"keyword"`
+		}{}, expected: `{"name":{"type":"keyword"},"quantity":{"type":"long"},"tags":{"type":"keyword"}}`},
+		{name: "time", value: struct {
+			SoldAt   time.Time    `json:"sold_at"`
+			LostAt   *time.Time   `json:"lost_at"`
+			Restocks []*time.Time `json:"restocks"`
+		}{}, expected: `{"lost_at":{"type":"date"},"restocks":{"type":"date"},"sold_at":{"type":"date"}}`},
+		{name: "signed", value: struct {
+			Int   int   `json:"int"`
+			Int8  int8  `json:"int8"`
+			Int16 int16 `json:"int16"`
+			Int32 int32 `json:"int32"`
+			Int64 int64 `json:"int64"`
+		}{}, expected: `{"int":{"type":"long"},"int16":{"type":"short"},"int32":{"type":"integer"},"int64":{"type":"long"},"int8":{"type":"byte"}}`},
+		{name: "unsigned", value: struct {
+			Uint   uint   `json:"uint"`
+			Uint8  uint8  `json:"uint8"`
+			Uint16 uint16 `json:"uint16"`
+			Uint32 uint32 `json:"uint32"`
+			Uint64 uint64 `json:"uint64"`
+		}{}, expected: `{"uint":{"type":"unsigned_long"},"uint16":{"type":"integer"},"uint32":{"type":"long"},"uint64":{"type":"unsigned_long"},"uint8":{"type":"short"}}`},
+		{name: "other kinds", value: struct {
+			Active bool                   `json:"active"`
+			Weight float32                `json:"weight"`
+			Price  float64                `json:"price"`
+			Blob   []byte                 `json:"blob"`
+			Labels map[string]string      `json:"labels"`
+			Extra  interface{}            `json:"extra"`
+			Props  map[string]interface{} `json:"props"`
+		}{}, expected: `{"active":{"type":"boolean"},"blob":{"type":"binary"},"labels":{"type":"object"},"price":{"type":"double"},"props":{"type":"object"},"weight":{"type":"float"}}`},
+		{name: "embedded", value: struct {
+			entity.BaseDomain
+			*mappedPart
+		}{}, expected: `{"DeletedAt":{"type":"date"},"Status":{"type":"integer"},"UpdatedAt":{"type":"date"},` +
+			`"created_at":{"type":"date"},"external_id":{"type":"keyword"},"id":{"type":"long"},"size":{"type":"long"},"sku":{"type":"keyword"}}`},
+		{name: "pointer to struct", value: &mappedPart{}, expected: `{"size":{"type":"long"},"sku":{"type":"keyword"}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err, mapping := ESMappingOf(test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, expected interface{}
+			source, err := json.Marshal(mapping.Properties)
+			if err != nil {
+				t.Fatal(err)
+			}
+			json.Unmarshal(source, &got)
+			if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if string(mustMarshal(t, got)) != string(mustMarshal(t, expected)) {
+				t.Fatalf("got %s\nexpected %s", source, test.expected)
+			}
+		})
+	}
+}
+
+func TestESMappingOfRejectsInvalidTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "not a struct", value: "bolt"},
+		{name: "nil", value: nil},
+		{name: "unknown option", value: struct {
+			Name string `json:"name" es:"stored"`
+		}{}},
+		{name: "invalid index", value: struct {
+			Name string `json:"name" es:"index=no"`
+		}{}},
+		{name: "nested object", value: struct {
+			Part mappedPart `json:"part" es:"nested,object"`
+		}{}},
+		{name: "unmappable kind", value: struct {
+			Done chan bool `json:"done"`
+		}{}},
+		{name: "recursive", value: mappedNode{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err, mapping := ESMappingOf(test.value); err == nil {
+				t.Fatalf("expected an error, got %+v", mapping)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	source, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
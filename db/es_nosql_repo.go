@@ -12,26 +12,16 @@ import (
 	"github.com/gobeam/stringy"
 	"github.com/sirupsen/logrus"
 	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
-	"reflect"
+	"sort"
 	"strings"
+	"time"
 )
 
-var (
-	kindStr = map[reflect.Kind]string{
-		reflect.String:  "text",
-		reflect.Int64:   "long",
-		reflect.Int32:   "integer",
-		reflect.Bool:    "bool",
-		reflect.Uint64:  "long",
-		reflect.Slice:   "nested",
-		reflect.Struct:  "nested",
-		reflect.Float64: "float",
-		reflect.Float32: "float",
-		reflect.Int:     "integer",
-	}
+// The document fields maintained by soft deletes, named after the json names of entity.BaseDomain.
+const (
+	esDeletedAtField = "DeletedAt"
+	esStatusField    = "Status"
 )
 
 type FieldAnalysis struct {
@@ -117,6 +107,7 @@ type EsHit struct {
 	Id     string                 `json:"_id"`
 	Score  float64                `json:"_score"`
 	Source map[string]interface{} `json:"_source"`
+	Sort   []interface{}          `json:"sort,omitempty"`
 }
 
 type Hits struct {
@@ -125,11 +116,20 @@ type Hits struct {
 	Hits     []EsHit `json:"hits"`
 }
 
+type ESGetResponse struct {
+	Index  string                 `json:"_index"`
+	Id     string                 `json:"_id"`
+	Found  bool                   `json:"found"`
+	Source map[string]interface{} `json:"_source"`
+}
+
 type ESSearchResponse struct {
 	Took     uint   `json:"took"`
 	TimedOut bool   `json:"timed_out"`
 	Shards   Shards `json:"_shards"`
 	Hits     Hits   `json:"hits"`
+
+	Aggregations map[string]json.RawMessage `json:"aggregations"`
 }
 
 type ESQuery struct {
@@ -198,103 +198,6 @@ type Settings struct {
 	Analysis Analysis `json:"analysis"`
 }
 
-func getTagValue(rawTag, key string) string {
-	tags := strings.Split(rawTag, ",")
-	for _, tag := range tags {
-		if strings.HasPrefix(tag, key) {
-			return tag[len(key)+1:]
-		}
-	}
-	return ""
-}
-
-func (esr *ElasticsearchRepo) getMappingForSlice(w reflect.Type, parentPath string) string {
-	var mappings []string
-	for j := 0; j < w.NumField(); j++ {
-		if w.Field(j).Type.Kind() != reflect.Struct && w.Field(j).Type.Kind() != reflect.Slice && w.Field(j).Type.Kind() != reflect.Chan {
-			if w.Field(j).Type.Kind() == reflect.String {
-				mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"%v\"\n, \"fields\": {\n\"keyword\": {\n \"type\": \"keyword\"} } }", toSnakeCase(w.Field(j).Name), kindStr[w.Field(j).Type.Kind()]))
-				attrName := fmt.Sprintf("%v.%v", parentPath, toSnakeCase(w.Field(j).Name))
-				esr.fieldMappings[attrName] = FieldAnalysis{
-					Analyzer:       w.Field(j).Tag.Get("analyzer"),
-					SearchAnalyzer: w.Field(j).Tag.Get("search_analyzer"),
-				}
-			} else {
-				if w.Field(j).Type.Kind() == reflect.Ptr {
-					mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), w.Field(j).Tag.Get("type")))
-				} else if w.Field(j).Tag.Get("type") != "" {
-					mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), w.Field(j).Tag.Get("type")))
-				} else {
-					mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), kindStr[w.Field(j).Type.Kind()]))
-				}
-			}
-		} else if w.Field(j).Type.Kind() == reflect.Struct || (w.Field(j).Type.Kind() == reflect.Slice && w.Field(j).Type.Elem().Kind() == reflect.Struct) {
-			if w.Field(j).Tag.Get("type") != "" {
-				mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), w.Field(j).Tag.Get("type")))
-			} else {
-				mappings = append(mappings, fmt.Sprintf("\"%v\":{\"properties\": {\n %v \n}\n}", toSnakeCase(w.Field(j).Name), esr.getMappingForSlice(w.Field(j).Type, fmt.Sprintf("%v.%v", parentPath, toSnakeCase(w.Field(j).Name)))))
-			}
-		} else if w.Field(j).Type.Kind() == reflect.Slice && (w.Field(j).Type.Elem().Kind() != reflect.Struct && w.Field(j).Type.Elem().Kind() != reflect.Chan) {
-			if w.Field(j).Type.Kind() == reflect.String {
-				attrName := fmt.Sprintf("%v.%v", parentPath, toSnakeCase(w.Field(j).Name))
-				esr.fieldMappings[attrName] = FieldAnalysis{
-					Analyzer:       w.Field(j).Tag.Get("analyzer"),
-					SearchAnalyzer: w.Field(j).Tag.Get("search_analyzer"),
-				}
-				mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n \"type\": \"text\"\n }", toSnakeCase(w.Field(j).Name)))
-			} else {
-				attrName := fmt.Sprintf("%v.%v", parentPath, toSnakeCase(w.Field(j).Name))
-				esr.fieldMappings[attrName] = FieldAnalysis{
-					Analyzer:       w.Field(j).Tag.Get("analyzer"),
-					SearchAnalyzer: w.Field(j).Tag.Get("search_analyzer"),
-				}
-				mappings = append(mappings, fmt.Sprintf("\"%v\": {\"type\": \"%v\"}", toSnakeCase(w.Field(j).Name), w.Field(j).Tag.Get("type")))
-			}
-		}
-	}
-	return strings.Join(mappings, ",\n")
-}
-
-func (esr *ElasticsearchRepo) getMapping(v reflect.Value) string {
-	var mappings []string
-	valType := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		attr := v.Field(i)
-		if attr.Kind() != reflect.Struct && attr.Kind() != reflect.Slice && attr.Kind() != reflect.Chan {
-			if attr.Kind() == reflect.String {
-				attrName := toSnakeCase(valType.Field(i).Name)
-				esr.fieldMappings[attrName] = FieldAnalysis{
-					Analyzer:       valType.Field(i).Tag.Get("analyzer"),
-					SearchAnalyzer: valType.Field(i).Tag.Get("search_analyzer"),
-				}
-				mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"%v\"\n, \"fields\": {\n\"keyword\": {\n \"type\": \"keyword\"} } }", toSnakeCase(valType.Field(i).Name), kindStr[attr.Kind()]))
-			} else {
-				mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"%v\"\n   }", toSnakeCase(valType.Field(i).Name), kindStr[attr.Kind()]))
-			}
-		} else if attr.Kind() == reflect.Slice {
-			attrType := reflect.TypeOf(attr.Interface()).Elem().Kind()
-			if attrType != reflect.Struct {
-				if attrType == reflect.String {
-					attrName := toSnakeCase(valType.Field(i).Name)
-					mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"text\"\n }", toSnakeCase(valType.Field(i).Name)))
-					esr.fieldMappings[attrName] = FieldAnalysis{
-						Analyzer:       valType.Field(i).Tag.Get("analyzer"),
-						SearchAnalyzer: valType.Field(i).Tag.Get("search_analyzer"),
-					}
-				} else {
-					mappings = append(mappings, fmt.Sprintf("   \"%v\": {\n     \"type\": \"%v\"\n   }", toSnakeCase(valType.Field(i).Name), kindStr[attrType]))
-				}
-			} else {
-				sFace := reflect.TypeOf(attr.Interface()).Elem()
-				mappings = append(mappings, fmt.Sprintf("\"%v\":{\n    \"properties\":    {\n %v }\n  }", toSnakeCase(valType.Field(i).Name), esr.getMappingForSlice(sFace, toSnakeCase(valType.Field(i).Name))))
-			}
-		} else if attr.Kind() == reflect.Struct {
-			mappings = append(mappings, fmt.Sprintf("\"%v\":{\n    \"properties\":    {\n %v }\n  }", toSnakeCase(valType.Field(i).Name), esr.getMappingForSlice(reflect.TypeOf(attr.Interface()), toSnakeCase(valType.Field(i).Name))))
-		}
-	}
-	return "{\n \"mappings\":{ \n  \"properties\":{\n" + strings.Join(mappings, ",\n") + "\n  }\n }\n}"
-}
-
 type ElasticsearchRepo struct {
 	marshaller      *HttpBodyUtil
 	client          *elasticsearch.Client
@@ -307,6 +210,10 @@ type ElasticsearchRepo struct {
 	logger          *logrus.Logger
 	settings        Settings
 	httpClient      *http.Client
+	codec           *CursorCodec
+	hardDelete      bool
+	tenantField     string
+	refresh         string
 }
 
 type ElasticsearchRepoOption func(repo *ElasticsearchRepo)
@@ -317,6 +224,27 @@ func WithHttpClient(client *http.Client) ElasticsearchRepoOption {
 	}
 }
 
+func WithESCursorCodec(codec *CursorCodec) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.codec = codec
+	}
+}
+
+// WithESHardDelete makes Delete remove documents instead of soft deleting them.
+func WithESHardDelete() ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.hardDelete = true
+	}
+}
+
+// WithESRefresh sets the refresh parameter of single document writes, "true" (the default), "wait_for" or "false".
+// Use "false" or an ESBulkIndexer for backfills, refreshing after every document is expensive.
+func WithESRefresh(refresh string) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.refresh = refresh
+	}
+}
+
 func WithMarshaller(marshaller *HttpBodyUtil) ElasticsearchRepoOption {
 	return func(repo *ElasticsearchRepo) {
 		repo.marshaller = marshaller
@@ -374,27 +302,44 @@ func WithStatusChecker(checker *HttpStatusChecker) ElasticsearchRepoOption {
 func NewElasticsearchRepo(opts ...ElasticsearchRepoOption) BaseNoSQLRepo {
 	repo := &ElasticsearchRepo{
 		fieldMappings: make(map[string]FieldAnalysis),
+		refresh:       "true",
 	}
 
 	for _, opt := range opts {
 		opt(repo)
 	}
+	// without a converter documents are decoded into the entities of the creator
+	if repo.entityConverter == nil && repo.entityCreator != nil {
+		repo.entityConverter = repo.decodeDocument
+	}
+	// the analysed fields TextSearch matches against, a mapping error is returned by IndexMappings
+	if repo.defaultEntity != nil {
+		if err, mapping := ESMappingOf(repo.defaultEntity); err == nil {
+			repo.fieldMappings = mapping.analysedFields()
+		}
+	}
 	return repo
 }
 
-func (esr *ElasticsearchRepo) GetById(ctx context.Context, id uint64) (error, entity.Base) {
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(fmt.Sprintf("{\"query\":{\"term\":{\"id\":%v}}}", id)),
+// decodeDocument decodes the source of a document into a new entity of the creator.
+func (esr *ElasticsearchRepo) decodeDocument(from map[string]interface{}) entity.Base {
+	base := esr.entityCreator()
+	source, err := json.Marshal(from)
+	if err == nil {
+		err = json.Unmarshal(source, base)
 	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+	if err != nil && esr.logger != nil {
+		esr.logger.WithError(err).Errorf("Error while decoding a document of %v", esr.index)
+	}
+	return base
+}
+
+func (esr *ElasticsearchRepo) GetById(ctx context.Context, id uint64) (error, entity.Base) {
+	err, body := esr.searchBody(ctx, []Condition{Eq("id", id)}, nil)
+	if err != nil {
 		return err, nil
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	err, response := esr.doSearch(ctx, body)
 	if err != nil {
 		return err, nil
 	}
@@ -405,136 +350,130 @@ func (esr *ElasticsearchRepo) GetById(ctx context.Context, id uint64) (error, en
 }
 
 func (esr *ElasticsearchRepo) Search(ctx context.Context, params map[string]string) (error, []entity.Base) {
-	var queries []string
-	for key, value := range params {
-		// supports filtering only on text fields
-		queries = append(queries, fmt.Sprintf("{\"term\": {\"%v\": \"%v\"}\n}", key, value))
-	}
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(fmt.Sprintf("{\"query\":{\"bool\":{\"filter\":[%v]\n}\n}\n}", strings.Join(queries, ",\n"))),
-	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+	err, query := QueryFromParams(params)
+	if err != nil {
 		return err, nil
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	err, result := esr.SearchQuery(ctx, query)
 	if err != nil {
 		return err, nil
 	}
-	var result []entity.Base
-	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
-	}
-	return nil, result
+	return nil, result.Items
 }
 
-func (esr *ElasticsearchRepo) GetDb() interface{} {
-	return esr.client
-}
-
-func (esr *ElasticsearchRepo) ExactSearch(ctx context.Context, key string, value interface{}) (error, []entity.Base) {
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(fmt.Sprintf("{\"query\":{\"term\":{\"%v\":%v}}}", key, value)),
+func (esr *ElasticsearchRepo) SearchQuery(ctx context.Context, query *Query) (error, *SearchResult) {
+	if err := query.Validate(); err != nil {
+		return err, nil
 	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+	err, body := esr.searchBody(ctx, query.Conditions, query.Sort)
+	if err != nil {
 		return err, nil
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	body["from"] = query.Offset
+	body["size"] = query.PageLimit()
+	body["track_total_hits"] = true
+	err, response := esr.doSearch(ctx, body)
 	if err != nil {
 		return err, nil
 	}
-	var result []entity.Base
+	result := &SearchResult{Total: int64(response.Hits.Total.Value)}
 	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
+		result.Items = append(result.Items, esr.entityConverter(hit.Source))
 	}
 	return nil, result
 }
 
-func (esr *ElasticsearchRepo) RangeSearch(ctx context.Context, key string, start, end interface{}) (error, []entity.Base) {
-	queryString := fmt.Sprintf("{\"query\":{\"range\": {\"%v\": {\"gte\": %v, \"lte\": %v}}}}", key, start, end)
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(queryString),
+// SearchAfter returns the page following the continuation token using search_after, an empty token
+// starts from the first document. Documents are ordered by the query's sort fields with id as the tiebreaker.
+func (esr *ElasticsearchRepo) SearchAfter(ctx context.Context, query *Query, token string) (error, *CursorPage) {
+	if err := query.Validate(); err != nil {
+		return err, nil
 	}
-
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+	if query.Offset > 0 {
+		return errors.New("offset cannot be combined with a continuation token"), nil
+	}
+	keys := keysetSort(query)
+	err, cursor := decodeCursor(esr.codec, token, keys)
+	if err != nil {
 		return err, nil
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	limit := query.PageLimit()
+	err, body := esr.searchBody(ctx, query.Conditions, keys)
 	if err != nil {
 		return err, nil
 	}
-	var result []entity.Base
-	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
+	// fetch one extra document to find out whether there is a next page
+	body["size"] = limit + 1
+	if cursor != nil {
+		body["search_after"] = append(append([]interface{}{}, cursor.Values...), cursor.Id)
 	}
-	return nil, result
-}
-
-func (esr *ElasticsearchRepo) getMatchQuery(value string) []string {
-	var result []string
-	for attr, mapping := range esr.fieldMappings {
-		var analyzer string
-		if mapping.Analyzer != "" {
-			analyzer = fmt.Sprintf(", \"analyzer\": %v", mapping.Analyzer)
+	err, response := esr.doSearch(ctx, body)
+	if err != nil {
+		return err, nil
+	}
+	page := &CursorPage{}
+	for i, hit := range response.Hits.Hits {
+		if i == limit {
+			last := response.Hits.Hits[limit-1]
+			if len(last.Sort) != len(keys) {
+				return errors.New("missing sort values in search response"), nil
+			}
+			err, page.NextToken = encodeCursor(esr.codec, keys, last.Sort[:len(keys)-1], page.Items[limit-1].GetId())
+			if err != nil {
+				return err, nil
+			}
+			break
 		}
-		result = append(result, fmt.Sprintf("{\"query\": {\"match\": {\"%v\": {\"query\": %v %v}}}}", attr, value, analyzer))
+		page.Items = append(page.Items, esr.entityConverter(hit.Source))
 	}
-	return result
+	return nil, page
 }
 
-func (esr *ElasticsearchRepo) getMatchPhraseQuery(value string) []string {
-	var result []string
-	for attr, mapping := range esr.fieldMappings {
-		var analyzer string
-		if mapping.Analyzer != "" {
-			analyzer = fmt.Sprintf(", \"analyzer\": %v", mapping.Analyzer)
-		}
-		result = append(result, fmt.Sprintf("{\"query\": {\"match_phrase\": {\"%v\": {\"query\": %v %v}}}}", attr, value, analyzer))
+func (esr *ElasticsearchRepo) searchBody(ctx context.Context, conditions []Condition, sort []SortField) (error, map[string]interface{}) {
+	var filters []ESClause
+	for _, condition := range conditions {
+		filters = append(filters, conditionToESClause(condition))
 	}
-	return result
+	return esr.queryBody(ctx, nil, sort, filters...)
 }
 
-func (esr *ElasticsearchRepo) getMultiMatchQuery(queryType, value string) string {
-	var attrs []string
-	var analyzerType string
-	for attr, mapping := range esr.fieldMappings {
-		if mapping.Analyzer != "" {
-			analyzerType = fmt.Sprintf(", \"analyzer\": \"%v\"", mapping.Analyzer)
+func (esr *ElasticsearchRepo) queryBody(ctx context.Context, query ESClause, sort []SortField, filters ...ESClause) (error, map[string]interface{}) {
+	err, scoped := esr.scoped(ctx, query, filters...)
+	if err != nil {
+		return err, nil
+	}
+	body := map[string]interface{}{"query": scoped.Source()}
+	var sorts []interface{}
+	for _, field := range sort {
+		order := "asc"
+		if field.Desc {
+			order = "desc"
 		}
-		attrs = append(attrs, fmt.Sprintf("\"%v\"", attr))
+		sorts = append(sorts, map[string]interface{}{field.Field: map[string]interface{}{"order": order}})
+	}
+	if len(sorts) > 0 {
+		body["sort"] = sorts
 	}
-	return fmt.Sprintf("{ \"multi_match\": {\"query\": \"%v\", \"type\": \"%v\", \"fields\": [%v] %v}}", value, queryType, strings.Join(attrs, ","), analyzerType)
+	return nil, body
 }
 
-func (esr *ElasticsearchRepo) TextSearch(ctx context.Context, value string) (error, []entity.Base) {
-	var innerQueries []string
-	innerQueries = append(innerQueries, esr.getMultiMatchQuery("cross_fields", value))
-	innerQueries = append(innerQueries, esr.getMultiMatchQuery("best_fields", value))
-	innerQueries = append(innerQueries, esr.getMultiMatchQuery("phrase", value))
-	innerQueries = append(innerQueries, esr.getMultiMatchQuery("phrase_prefix", value))
-	queryBody := fmt.Sprintf("{\"query\": {\"bool\":{\"should\":[%v]}}}", strings.Join(innerQueries, ","))
+func (esr *ElasticsearchRepo) doSearch(ctx context.Context, body map[string]interface{}) (error, *ESSearchResponse) {
+	bodyBytes, err := json.Marshal(body)
+	if err != nil {
+		return err, nil
+	}
 	req := esapi.SearchRequest{
 		Index: []string{esr.index},
-		Body:  strings.NewReader(queryBody),
+		Body:  bytes.NewReader(bodyBytes),
 	}
 	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+	if err != nil {
 		return err, nil
 	}
+	defer res.Body.Close()
+	if !esr.sChecker.IsSuccessFul(res.StatusCode) {
+		return errors.New(fmt.Sprintf("Error while searching %v", res.String())), nil
+	}
 	var response ESSearchResponse
 	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
 		return &response
@@ -542,41 +481,79 @@ func (esr *ElasticsearchRepo) TextSearch(ctx context.Context, value string) (err
 	if err != nil {
 		return err, nil
 	}
-	var result []entity.Base
-	for _, hit := range response.Hits.Hits {
-		result = append(result, esr.entityConverter(hit.Source))
-	}
-	return nil, result
+	return nil, &response
 }
 
-func (esr *ElasticsearchRepo) IndexMappings(ctx context.Context) error {
-	v := reflect.ValueOf(esr.defaultEntity)
-	var mapping map[string]interface{}
-	err := json.Unmarshal([]byte(esr.getMapping(v)), &mapping)
+func (esr *ElasticsearchRepo) GetDb() interface{} {
+	return esr.client
+}
+
+func (esr *ElasticsearchRepo) ExactSearch(ctx context.Context, key string, value interface{}) (error, []entity.Base) {
+	err, result := esr.SearchDSL(ctx, ESTerm(key, value), nil, 0, 0)
 	if err != nil {
-		log.Fatalf("An error %v occurred while indexing mappings, cannot continue.", err)
+		return err, nil
 	}
-	mapping["settings"] = esr.settings
-	mappingStr, err := json.Marshal(mapping)
-	esr.logger.Infof("Mappings %v", string(mappingStr))
+	return nil, result.Items
+}
+
+func (esr *ElasticsearchRepo) RangeSearch(ctx context.Context, key string, start, end interface{}) (error, []entity.Base) {
+	err, result := esr.SearchDSL(ctx, ESRange(key).Gte(start).Lte(end), nil, 0, 0)
 	if err != nil {
-		log.Fatalf("An error %v occurred while marshalling mapping to json", err)
+		return err, nil
 	}
-	req, err := http.NewRequest(http.MethodPut, "http://localhost:9200/"+esr.index, bytes.NewBuffer(mappingStr))
+	return nil, result.Items
+}
+
+// textQuery matches value against the analysed fields of the mapping, trying several multi_match strategies.
+func (esr *ElasticsearchRepo) textQuery(value string) ESClause {
+	var fields []string
+	analyzers := make(map[string]bool)
+	for attr, mapping := range esr.fieldMappings {
+		fields = append(fields, attr)
+		analyzers[mapping.Analyzer] = true
+	}
+	sort.Strings(fields)
+	query := ESBool().MinimumShouldMatch(1)
+	for _, matchType := range []string{"cross_fields", "best_fields", "phrase", "phrase_prefix"} {
+		multiMatch := ESMultiMatch(value, fields...).Type(matchType)
+		// a query time analyzer applies to every field, so it is only set when all fields share it
+		if len(analyzers) == 1 {
+			for analyzer := range analyzers {
+				multiMatch.Analyzer(analyzer)
+			}
+		}
+		query.Should(multiMatch)
+	}
+	return query
+}
+
+func (esr *ElasticsearchRepo) TextSearch(ctx context.Context, value string) (error, []entity.Base) {
+	err, result := esr.SearchDSL(ctx, esr.textQuery(value), nil, 0, 0)
 	if err != nil {
+		return err, nil
+	}
+	return nil, result.Items
+}
+
+// IndexMappings creates the first versioned index with the mapping of the default entity behind the repository's
+// index name used as an alias, see Reindex. It does nothing when the alias or an index of that name already exists.
+func (esr *ElasticsearchRepo) IndexMappings(ctx context.Context) error {
+	if err, _ := esr.mappingDocument(); err != nil {
 		return err
 	}
-	req.Header.Add("content-type", "application/json")
-	resp, err := esr.httpClient.Do(req)
+	err, current, _ := esr.aliasedIndex(ctx)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if current != "" {
+		esr.logger.Infof("Index %v already exists", current)
+		return nil
+	}
+	name := versionedIndex(esr.index, 1)
+	if err := esr.createIndex(ctx, name, true); err != nil {
 		return err
 	}
-	esr.logger.Infof("Mapping created %v", string(respBytes))
+	esr.logger.Infof("Index %v created behind alias %v", name, esr.index)
 	return nil
 }
 
@@ -599,49 +576,134 @@ func (esr *ElasticsearchRepo) IsHealthy() bool {
 	return esResponse.IsHealthy()
 }
 
+// Create indexes the document of base. For tenant scoped repositories it fails when a document with the same
+// id exists, so that a tenant cannot overwrite the documents of another.
 func (esr *ElasticsearchRepo) Create(ctx context.Context, base entity.Base) (error, entity.Base) {
-	jBody, err := base.ToJson()
+	err, jBody := esr.tenantDocument(ctx, base)
 	if err != nil {
 		return err, nil
 	}
 	req := esapi.IndexRequest{
-		Index:      string(base.GetTable()),
+		Index:      esr.index,
 		DocumentID: base.GetExternalId(),
 		Body:       strings.NewReader(jBody),
-		Refresh:    "true",
+		Refresh:    esr.refresh,
+	}
+	if esr.tenantField != "" {
+		req.OpType = "create"
 	}
 	res, err := req.Do(ctx, esr.client)
-	if err != nil || esr.sChecker.IsInternalError(res.StatusCode) || esr.sChecker.IsClientError(res.StatusCode) {
+	if err != nil {
 		return errors.New(fmt.Sprintf("Error while indexing %v", err)), nil
 	}
+	defer res.Body.Close()
+	if esr.sChecker.IsInternalError(res.StatusCode) || esr.sChecker.IsClientError(res.StatusCode) {
+		return errors.New(fmt.Sprintf("Error while indexing %v", res.String())), nil
+	}
 
 	return nil, base
 }
 
+// Update replaces the fields of the document with those of base, only for documents of the tenant in ctx.
 func (esr *ElasticsearchRepo) Update(ctx context.Context, entityId string, base entity.Base) (error, entity.Base) {
-	req := esapi.UpdateRequest{DocumentID: entityId, Index: base.GetExternalId()}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || esr.sChecker.IsInternalError(res.StatusCode) || esr.sChecker.IsClientError(res.StatusCode) {
-		return errors.New(fmt.Sprintf("Error while updating %v", err)), nil
+	err, jBody := esr.tenantDocument(ctx, base)
+	if err != nil {
+		return err, nil
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(jBody), &doc); err != nil {
+		return err, nil
+	}
+	if err := esr.partialUpdate(ctx, entityId, doc); err != nil {
+		return err, nil
 	}
 	return nil, base
 }
 
 func (esr *ElasticsearchRepo) GetByExternalId(ctx context.Context, entityId string) (error, entity.Base) {
 	truthy := true
-	req := esapi.GetRequest{DocumentID: entityId, Refresh: &truthy, Realtime: &truthy}
+	req := esapi.GetRequest{Index: esr.index, DocumentID: entityId, Refresh: &truthy, Realtime: &truthy}
 	res, err := req.Do(ctx, esr.client)
 	if err != nil {
 		return err, nil
 	}
-	entity := esr.entityCreator()
+	defer res.Body.Close()
+	var response ESGetResponse
 	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &entity
+		return &response
 	})
 	if err != nil {
 		return err, nil
 	}
-	return nil, entity
+	if !response.Found || (!includeDeleted(ctx) && response.Source[esDeletedAtField] != nil) {
+		return errors.New("not found"), nil
+	}
+	err, owned := esr.ownsDocument(ctx, response.Source)
+	if err != nil {
+		return err, nil
+	}
+	if !owned {
+		return errors.New("not found"), nil
+	}
+	return nil, esr.entityConverter(response.Source)
+}
+
+// Delete soft deletes the document, or removes it when the repository is configured WithESHardDelete.
+func (esr *ElasticsearchRepo) Delete(ctx context.Context, entityId string) error {
+	if esr.hardDelete {
+		return esr.HardDelete(ctx, entityId)
+	}
+	return esr.SoftDelete(ctx, entityId)
+}
+
+func (esr *ElasticsearchRepo) SoftDelete(ctx context.Context, entityId string) error {
+	return esr.partialUpdate(ctx, entityId, map[string]interface{}{
+		esDeletedAtField: time.Now(),
+		esStatusField:    entity.GetStatusInt("inactive"),
+	})
+}
+
+func (esr *ElasticsearchRepo) Restore(ctx context.Context, entityId string) error {
+	return esr.partialUpdate(ctx, entityId, map[string]interface{}{
+		esDeletedAtField: nil,
+		esStatusField:    entity.GetStatusInt("active"),
+	})
+}
+
+func (esr *ElasticsearchRepo) HardDelete(ctx context.Context, entityId string) error {
+	if err := esr.checkTenant(ctx, entityId); err != nil {
+		return err
+	}
+	req := esapi.DeleteRequest{Index: esr.index, DocumentID: entityId, Refresh: esr.refresh}
+	res, err := req.Do(ctx, esr.client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !esr.sChecker.IsSuccessFul(res.StatusCode) {
+		return errors.New(fmt.Sprintf("Error while deleting %v", res.String()))
+	}
+	return nil
+}
+
+func (esr *ElasticsearchRepo) partialUpdate(ctx context.Context, entityId string, doc map[string]interface{}) error {
+	if err := esr.checkTenant(ctx, entityId); err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"doc": doc})
+	if err != nil {
+		return err
+	}
+	req := esapi.UpdateRequest{Index: esr.index, DocumentID: entityId, Body: bytes.NewReader(body), Refresh: esr.refresh}
+	res, err := req.Do(ctx, esr.client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !esr.sChecker.IsSuccessFul(res.StatusCode) {
+		return errors.New(fmt.Sprintf("Error while updating %v", res.String()))
+	}
+	return nil
 }
 
 func toSnakeCase(input string) string {
@@ -649,22 +711,16 @@ func toSnakeCase(input string) string {
 }
 
 func (esr *ElasticsearchRepo) MultiGetByExternalId(ctx context.Context, entityIds []string) (error, []entity.Base) {
-	var nEntityIds []string
+	var ids []interface{}
 	for _, entityId := range entityIds {
-		nEntityIds = append(nEntityIds, fmt.Sprintf("\"%v\"", entityId))
-	}
-	req := esapi.SearchRequest{
-		Index: []string{esr.index},
-		Body:  strings.NewReader(fmt.Sprintf("{\"query\":{\"terms\":{\"_id\":[%v]}}}", strings.Join(nEntityIds, ","))),
+		ids = append(ids, entityId)
 	}
-	res, err := req.Do(ctx, esr.client)
-	if err != nil || !esr.sChecker.IsSuccessFul(res.StatusCode) {
+	err, body := esr.searchBody(ctx, []Condition{In("_id", ids...)}, nil)
+	if err != nil {
 		return err, nil
 	}
-	var response ESSearchResponse
-	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
-		return &response
-	})
+	body["size"] = len(entityIds)
+	err, response := esr.doSearch(ctx, body)
 	if err != nil {
 		return err, nil
 	}
@@ -0,0 +1,364 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ESClause is a node of the Elasticsearch query DSL. Values are kept as Go values and only
+// encoded when the search body is marshalled, so user input never needs escaping.
+type ESClause interface {
+	Source() map[string]interface{}
+}
+
+// ESTermQuery matches documents whose field holds exactly value.
+type ESTermQuery struct {
+	Field string
+	Value interface{}
+}
+
+func ESTerm(field string, value interface{}) *ESTermQuery {
+	return &ESTermQuery{Field: field, Value: value}
+}
+
+func (q *ESTermQuery) Source() map[string]interface{} {
+	return map[string]interface{}{"term": map[string]interface{}{q.Field: q.Value}}
+}
+
+// ESTermsQuery matches documents whose field holds any of values.
+type ESTermsQuery struct {
+	Field  string
+	Values []interface{}
+}
+
+func ESTerms(field string, values ...interface{}) *ESTermsQuery {
+	return &ESTermsQuery{Field: field, Values: values}
+}
+
+func (q *ESTermsQuery) Source() map[string]interface{} {
+	values := q.Values
+	if values == nil {
+		values = []interface{}{}
+	}
+	return map[string]interface{}{"terms": map[string]interface{}{q.Field: values}}
+}
+
+// ESRangeQuery matches documents whose field lies within the bounds set with Gt, Gte, Lt and Lte.
+type ESRangeQuery struct {
+	Field  string
+	bounds map[string]interface{}
+}
+
+func ESRange(field string) *ESRangeQuery {
+	return &ESRangeQuery{Field: field, bounds: map[string]interface{}{}}
+}
+
+func (q *ESRangeQuery) Gt(value interface{}) *ESRangeQuery {
+	q.bounds["gt"] = value
+	return q
+}
+
+func (q *ESRangeQuery) Gte(value interface{}) *ESRangeQuery {
+	q.bounds["gte"] = value
+	return q
+}
+
+func (q *ESRangeQuery) Lt(value interface{}) *ESRangeQuery {
+	q.bounds["lt"] = value
+	return q
+}
+
+func (q *ESRangeQuery) Lte(value interface{}) *ESRangeQuery {
+	q.bounds["lte"] = value
+	return q
+}
+
+func (q *ESRangeQuery) Source() map[string]interface{} {
+	return map[string]interface{}{"range": map[string]interface{}{q.Field: q.bounds}}
+}
+
+// ESMatchQuery is a full text match of text against field, as a phrase when Phrase is set.
+type ESMatchQuery struct {
+	Field    string
+	Text     string
+	Phrase   bool
+	analyzer string
+	operator string
+}
+
+func ESMatch(field, text string) *ESMatchQuery {
+	return &ESMatchQuery{Field: field, Text: text}
+}
+
+func ESMatchPhrase(field, text string) *ESMatchQuery {
+	return &ESMatchQuery{Field: field, Text: text, Phrase: true}
+}
+
+func (q *ESMatchQuery) Analyzer(analyzer string) *ESMatchQuery {
+	q.analyzer = analyzer
+	return q
+}
+
+// Operator sets how the terms of the text are combined, "or" (the default) or "and".
+func (q *ESMatchQuery) Operator(operator string) *ESMatchQuery {
+	q.operator = operator
+	return q
+}
+
+func (q *ESMatchQuery) Source() map[string]interface{} {
+	params := map[string]interface{}{"query": q.Text}
+	if q.analyzer != "" {
+		params["analyzer"] = q.analyzer
+	}
+	if q.operator != "" && !q.Phrase {
+		params["operator"] = q.operator
+	}
+	kind := "match"
+	if q.Phrase {
+		kind = "match_phrase"
+	}
+	return map[string]interface{}{kind: map[string]interface{}{q.Field: params}}
+}
+
+// ESMultiMatchQuery matches text against several fields, all the index's default fields when none are given.
+type ESMultiMatchQuery struct {
+	Text      string
+	Fields    []string
+	matchType string
+	analyzer  string
+}
+
+func ESMultiMatch(text string, fields ...string) *ESMultiMatchQuery {
+	return &ESMultiMatchQuery{Text: text, Fields: fields}
+}
+
+// Type sets the multi_match type, such as best_fields, cross_fields, phrase or phrase_prefix.
+func (q *ESMultiMatchQuery) Type(matchType string) *ESMultiMatchQuery {
+	q.matchType = matchType
+	return q
+}
+
+func (q *ESMultiMatchQuery) Analyzer(analyzer string) *ESMultiMatchQuery {
+	q.analyzer = analyzer
+	return q
+}
+
+func (q *ESMultiMatchQuery) Source() map[string]interface{} {
+	params := map[string]interface{}{"query": q.Text}
+	if len(q.Fields) > 0 {
+		params["fields"] = q.Fields
+	}
+	if q.matchType != "" {
+		params["type"] = q.matchType
+	}
+	if q.analyzer != "" {
+		params["analyzer"] = q.analyzer
+	}
+	return map[string]interface{}{"multi_match": params}
+}
+
+// ESWildcardQuery matches field against a pattern where * matches any characters and ? a single one.
+type ESWildcardQuery struct {
+	Field   string
+	Pattern string
+}
+
+func ESWildcard(field, pattern string) *ESWildcardQuery {
+	return &ESWildcardQuery{Field: field, Pattern: pattern}
+}
+
+func (q *ESWildcardQuery) Source() map[string]interface{} {
+	return map[string]interface{}{"wildcard": map[string]interface{}{q.Field: q.Pattern}}
+}
+
+// ESExistsQuery matches documents having a value for field.
+type ESExistsQuery struct {
+	Field string
+}
+
+func ESExists(field string) *ESExistsQuery {
+	return &ESExistsQuery{Field: field}
+}
+
+func (q *ESExistsQuery) Source() map[string]interface{} {
+	return map[string]interface{}{"exists": map[string]interface{}{"field": q.Field}}
+}
+
+// ESNestedQuery runs query against the nested objects under path.
+type ESNestedQuery struct {
+	Path  string
+	Query ESClause
+}
+
+func ESNested(path string, query ESClause) *ESNestedQuery {
+	return &ESNestedQuery{Path: path, Query: query}
+}
+
+func (q *ESNestedQuery) Source() map[string]interface{} {
+	return map[string]interface{}{"nested": map[string]interface{}{"path": q.Path, "query": q.Query.Source()}}
+}
+
+// ESBoolQuery combines clauses, must and should clauses contribute to the score while filter and must_not do not.
+type ESBoolQuery struct {
+	must               []ESClause
+	should             []ESClause
+	filter             []ESClause
+	mustNot            []ESClause
+	minimumShouldMatch int
+}
+
+func ESBool() *ESBoolQuery {
+	return &ESBoolQuery{}
+}
+
+func (q *ESBoolQuery) Must(clauses ...ESClause) *ESBoolQuery {
+	q.must = append(q.must, clauses...)
+	return q
+}
+
+func (q *ESBoolQuery) Should(clauses ...ESClause) *ESBoolQuery {
+	q.should = append(q.should, clauses...)
+	return q
+}
+
+func (q *ESBoolQuery) Filter(clauses ...ESClause) *ESBoolQuery {
+	q.filter = append(q.filter, clauses...)
+	return q
+}
+
+func (q *ESBoolQuery) MustNot(clauses ...ESClause) *ESBoolQuery {
+	q.mustNot = append(q.mustNot, clauses...)
+	return q
+}
+
+func (q *ESBoolQuery) MinimumShouldMatch(n int) *ESBoolQuery {
+	q.minimumShouldMatch = n
+	return q
+}
+
+func (q *ESBoolQuery) Source() map[string]interface{} {
+	params := map[string]interface{}{}
+	for occur, clauses := range map[string][]ESClause{"must": q.must, "should": q.should, "filter": q.filter, "must_not": q.mustNot} {
+		if len(clauses) > 0 {
+			params[occur] = clauseSources(clauses)
+		}
+	}
+	if q.minimumShouldMatch > 0 {
+		params["minimum_should_match"] = q.minimumShouldMatch
+	}
+	return map[string]interface{}{"bool": params}
+}
+
+// ESMatchAllQuery matches every document.
+type ESMatchAllQuery struct{}
+
+func ESMatchAll() *ESMatchAllQuery {
+	return &ESMatchAllQuery{}
+}
+
+func (q *ESMatchAllQuery) Source() map[string]interface{} {
+	return map[string]interface{}{"match_all": map[string]interface{}{}}
+}
+
+func clauseSources(clauses []ESClause) []interface{} {
+	sources := make([]interface{}, 0, len(clauses))
+	for _, clause := range clauses {
+		sources = append(sources, clause.Source())
+	}
+	return sources
+}
+
+// MarshalESClause returns the JSON of the clause, as sent in the query of a search body.
+func MarshalESClause(clause ESClause) ([]byte, error) {
+	return json.Marshal(clause.Source())
+}
+
+// conditionToESClause translates a repository condition into the query DSL.
+func conditionToESClause(condition Condition) ESClause {
+	switch condition.Operator {
+	case OpAnd, OpOr:
+		var nested []ESClause
+		for _, c := range condition.Conditions {
+			nested = append(nested, conditionToESClause(c))
+		}
+		if condition.Operator == OpAnd {
+			return ESBool().Filter(nested...)
+		}
+		return ESBool().Should(nested...).MinimumShouldMatch(1)
+	case OpNe:
+		return ESBool().MustNot(ESTerm(condition.Field, condition.Value))
+	case OpIn:
+		return ESTerms(condition.Field, condition.Values...)
+	case OpNotIn:
+		return ESBool().MustNot(ESTerms(condition.Field, condition.Values...))
+	case OpGt:
+		return ESRange(condition.Field).Gt(condition.Value)
+	case OpGte:
+		return ESRange(condition.Field).Gte(condition.Value)
+	case OpLt:
+		return ESRange(condition.Field).Lt(condition.Value)
+	case OpLte:
+		return ESRange(condition.Field).Lte(condition.Value)
+	case OpBetween:
+		return ESRange(condition.Field).Gte(condition.Values[0]).Lte(condition.Values[1])
+	case OpLike:
+		return ESWildcard(condition.Field, strings.NewReplacer("%", "*", "_", "?").Replace(fmt.Sprintf("%v", condition.Value)))
+	case OpIsNull:
+		return ESBool().MustNot(ESExists(condition.Field))
+	case OpNotNull:
+		return ESExists(condition.Field)
+	default:
+		return ESTerm(condition.Field, condition.Value)
+	}
+}
+
+// scoped adds filters restricting query to the tenant in ctx and, unless reading WithDeleted, to documents
+// not soft deleted.
+func (esr *ElasticsearchRepo) scoped(ctx context.Context, query ESClause, filters ...ESClause) (error, ESClause) {
+	scoped := ESBool().Filter(filters...)
+	if query != nil {
+		scoped.Must(query)
+	}
+	err, tenant := esr.tenantCondition(ctx)
+	if err != nil {
+		return err, nil
+	}
+	if tenant != nil {
+		scoped.Filter(conditionToESClause(*tenant))
+	}
+	if !includeDeleted(ctx) {
+		scoped.Filter(conditionToESClause(IsNull(esDeletedAtField)))
+	}
+	return nil, scoped
+}
+
+// SearchDSL returns the documents matching a query composed with the query DSL, restricted like every other
+// read to the tenant in ctx and to documents not soft deleted. A zero size uses the Elasticsearch default.
+func (esr *ElasticsearchRepo) SearchDSL(ctx context.Context, query ESClause, sort []SortField, from, size int) (error, *SearchResult) {
+	if from < 0 || size < 0 {
+		return errors.New("from and size must not be negative"), nil
+	}
+	err, body := esr.queryBody(ctx, query, sort)
+	if err != nil {
+		return err, nil
+	}
+	if from > 0 {
+		body["from"] = from
+	}
+	if size > 0 {
+		body["size"] = size
+	}
+	body["track_total_hits"] = true
+	err, response := esr.doSearch(ctx, body)
+	if err != nil {
+		return err, nil
+	}
+	result := &SearchResult{Total: int64(response.Hits.Total.Value)}
+	for _, hit := range response.Hits.Hits {
+		result.Items = append(result.Items, esr.entityConverter(hit.Source))
+	}
+	return nil, result
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/util"
+	"testing"
+)
+
+func TestConditionToESClause(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition Condition
+		expected  string
+	}{
+		{name: "eq", condition: Eq("name", `bolt" OR 1`), expected: `{"term":{"name":"bolt\" OR 1"}}`},
+		{name: "ne", condition: Ne("name", "bolt"), expected: `{"bool":{"must_not":[{"term":{"name":"bolt"}}]}}`},
+		{name: "in", condition: In("quantity", 1, 2), expected: `{"terms":{"quantity":[1,2]}}`},
+		{name: "not in", condition: NotIn("quantity", 1, 2), expected: `{"bool":{"must_not":[{"terms":{"quantity":[1,2]}}]}}`},
+		{name: "gt", condition: Gt("quantity", 1), expected: `{"range":{"quantity":{"gt":1}}}`},
+		{name: "gte", condition: Gte("quantity", 1), expected: `{"range":{"quantity":{"gte":1}}}`},
+		{name: "lt", condition: Lt("quantity", 1), expected: `{"range":{"quantity":{"lt":1}}}`},
+		{name: "lte", condition: Lte("quantity", 1), expected: `{"range":{"quantity":{"lte":1}}}`},
+		{name: "between", condition: Between("quantity", 1, 5), expected: `{"range":{"quantity":{"gte":1,"lte":5}}}`},
+		{name: "like", condition: Like("name", "bo_t%"), expected: `{"wildcard":{"name":"bo?t*"}}`},
+		{name: "is null", condition: IsNull("deleted_at"), expected: `{"bool":{"must_not":[{"exists":{"field":"deleted_at"}}]}}`},
+		{name: "not null", condition: NotNull("deleted_at"), expected: `{"exists":{"field":"deleted_at"}}`},
+		{
+			name:      "nested groups",
+			condition: Or(Eq("name", "bolt"), And(Gt("quantity", 1), Lt("quantity", 5))),
+			expected: `{"bool":{"minimum_should_match":1,"should":[{"term":{"name":"bolt"}},` +
+				`{"bool":{"filter":[{"range":{"quantity":{"gt":1}}},{"range":{"quantity":{"lt":5}}}]}}]}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := MarshalESClause(conditionToESClause(test.condition))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(source) != test.expected {
+				t.Fatalf("got %s\nexpected %s", source, test.expected)
+			}
+		})
+	}
+}
+
+func TestScopedESQuery(t *testing.T) {
+	repo := NewElasticsearchRepo(WithESTenantField("tenant_id")).(*ElasticsearchRepo)
+	query := ESMatch("name", "bolt")
+
+	err, scoped := repo.scoped(util.WithTenant(context.Background(), "acme"), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := MarshalESClause(scoped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"bool":{"filter":[{"term":{"tenant_id":"acme"}},{"bool":{"must_not":[{"exists":{"field":"DeletedAt"}}]}}],` +
+		`"must":[{"match":{"name":{"query":"bolt"}}}]}}`
+	if string(source) != expected {
+		t.Fatalf("got %s\nexpected %s", source, expected)
+	}
+
+	if err, _ := repo.scoped(context.Background(), query); !errors.Is(err, ErrMissingTenant) {
+		t.Fatalf("expected a search without tenant to fail, got %v", err)
+	}
+	err, scoped = repo.scoped(WithDeleted(util.WithTenant(context.Background(), "acme")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source, _ := MarshalESClause(scoped); string(source) != `{"bool":{"filter":[{"term":{"tenant_id":"acme"}}]}}` {
+		t.Fatalf("got %s, expected only the tenant filter", source)
+	}
+}
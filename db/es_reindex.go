@@ -0,0 +1,416 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/byteintellect/go_commons/util"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultReindexPollInterval = time.Second
+
+// ReindexProgress reports how many of the documents to copy into the new index have been copied so far.
+type ReindexProgress struct {
+	Index string
+	Total int64
+	Done  int64
+}
+
+// ReindexResult describes a completed reindex, OldIndex is empty when the alias did not exist before.
+type ReindexResult struct {
+	OldIndex string
+	NewIndex string
+	Copied   int64
+	Failed   int64
+}
+
+type ReindexOption func(r *reindexer)
+
+type reindexer struct {
+	source       StreamingRepository
+	progress     func(progress ReindexProgress)
+	pollInterval time.Duration
+	deleteOld    bool
+	bulkOpts     []ESBulkIndexerOption
+	// startedAt is when copying began, the second pass copies the entities of the source updated since
+	startedAt time.Time
+}
+
+// WithReindexSource copies the entities of the source repository, typically the GORM source of truth,
+// instead of copying the documents of the current index with _reindex. The source's entities must have an
+// updated_at column, entities updated while copying are copied again once the alias has moved.
+func WithReindexSource(source StreamingRepository, opts ...ESBulkIndexerOption) ReindexOption {
+	return func(r *reindexer) {
+		r.source = source
+		r.bulkOpts = opts
+	}
+}
+
+// WithReindexProgress sets a callback called as documents are copied.
+func WithReindexProgress(progress func(progress ReindexProgress)) ReindexOption {
+	return func(r *reindexer) {
+		r.progress = progress
+	}
+}
+
+// WithReindexPollInterval sets how often the progress of a _reindex task is checked.
+func WithReindexPollInterval(interval time.Duration) ReindexOption {
+	return func(r *reindexer) {
+		r.pollInterval = interval
+	}
+}
+
+// WithReindexDeleteOld deletes the previous index once the alias points to the new one.
+func WithReindexDeleteOld() ReindexOption {
+	return func(r *reindexer) {
+		r.deleteOld = true
+	}
+}
+
+// Reindex creates the next versioned index from the current mapping, copies the documents into it and then
+// atomically moves the alias, so that readers and writers switch over without downtime.
+// Writes made through the alias while copying land in the old index, they are copied over by a second pass
+// once the alias has moved: documents copied from the old index carry its versions so that changed documents
+// are copied again, entities of a source repository updated since copying began are copied again from the
+// source. A write indexed through the alias while the second pass runs can be overwritten by the state the
+// second pass read just before it, and documents hard deleted while copying are not removed from the new index.
+// An index created before aliases were used, named like the alias, is replaced by the alias in the same step,
+// as it is removed by the swap it is made read only while copying and writes to it fail meanwhile.
+// The new index is deleted when copying fails before the alias has moved, after that a failed second pass
+// leaves it behind the alias.
+func (esr *ElasticsearchRepo) Reindex(ctx context.Context, opts ...ReindexOption) (error, *ReindexResult) {
+	r := &reindexer{pollInterval: defaultReindexPollInterval}
+	for _, opt := range opts {
+		opt(r)
+	}
+	err, current, aliased := esr.aliasedIndex(ctx)
+	if err != nil {
+		return err, nil
+	}
+	if current == "" && r.source == nil {
+		return errors.New("nothing to reindex from, the index does not exist"), nil
+	}
+	err, version := esr.latestVersion(ctx)
+	if err != nil {
+		return err, nil
+	}
+	result := &ReindexResult{OldIndex: current, NewIndex: versionedIndex(esr.index, version+1)}
+	if err := esr.createIndex(ctx, result.NewIndex, false); err != nil {
+		return err, nil
+	}
+	blocked := false
+	if current != "" && !aliased {
+		err = esr.blockWrites(ctx, current, true)
+		blocked = err == nil
+	}
+	r.startedAt = time.Now()
+	if err == nil && r.source != nil {
+		err = esr.copyFromSource(ctx, r, result, NewQuery())
+	} else if err == nil {
+		err = esr.copyIndex(ctx, r, result, map[string]interface{}{"version_type": "external"})
+	}
+	if err == nil {
+		err = esr.refreshIndex(ctx, result.NewIndex)
+	}
+	if err == nil {
+		err = esr.swapAlias(ctx, current, aliased, result.NewIndex)
+	}
+	if err != nil {
+		if blocked {
+			if unblockErr := esr.blockWrites(ctx, current, false); unblockErr != nil {
+				esr.logger.WithError(unblockErr).Errorf("index %v is left read only", current)
+			}
+		}
+		// the alias does not point to the new index, nothing reads or writes it
+		if deleteErr := esr.deleteIndex(ctx, result.NewIndex); deleteErr != nil {
+			esr.logger.WithError(deleteErr).Errorf("index %v is left behind", result.NewIndex)
+		}
+		return err, result
+	}
+	if current != "" && aliased {
+		if err := esr.catchUp(ctx, r, result); err != nil {
+			return err, result
+		}
+	}
+	if r.deleteOld && aliased {
+		if err := esr.deleteIndex(ctx, current); err != nil {
+			return err, result
+		}
+	}
+	return nil, result
+}
+
+// copyFromSource indexes the entities of the source matching query into the new index, adding to the counts
+// of result.
+func (esr *ElasticsearchRepo) copyFromSource(ctx context.Context, r *reindexer, result *ReindexResult, query *Query) error {
+	// every tenant and the soft deleted entities are copied, as they are all in the index
+	ctx = WithDeleted(util.WithAllTenants(ctx))
+	progress := ReindexProgress{Index: result.NewIndex}
+	if counter, ok := r.source.(BaseRepository); ok {
+		err, total := counter.Count(ctx, query.Conditions...)
+		if err != nil {
+			return err
+		}
+		progress.Total = total
+	}
+	opts := append([]ESBulkIndexerOption{WithBulkIndex(result.NewIndex)}, r.bulkOpts...)
+	var mu sync.Mutex
+	var firstErr error
+	opts = append(opts, WithBulkOnFailure(func(ctx context.Context, item ESBulkItem, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = fmt.Errorf("copying %v failed: %w", item.DocumentId, err)
+		}
+	}))
+	indexer := esr.NewBulkIndexer(ctx, opts...)
+	err := r.source.Iterate(ctx, query, func(base entity.Base) error {
+		if err := indexer.Index(ctx, base); err != nil {
+			return err
+		}
+		progress.Done++
+		if r.progress != nil && progress.Done%int64(defaultBulkBatchSize) == 0 {
+			r.progress(progress)
+		}
+		return nil
+	})
+	if closeErr := indexer.Close(ctx); err == nil {
+		err = closeErr
+	}
+	stats := indexer.Stats()
+	result.Copied += int64(stats.Succeeded)
+	result.Failed += int64(stats.Failed)
+	if err != nil {
+		return err
+	}
+	if r.progress != nil {
+		r.progress(progress)
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%v documents could not be copied, first error: %w", result.Failed, firstErr)
+	}
+	return nil
+}
+
+type esTask struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status struct {
+			Total   int64 `json:"total"`
+			Created int64 `json:"created"`
+			Updated int64 `json:"updated"`
+			Deleted int64 `json:"deleted"`
+		} `json:"status"`
+	} `json:"task"`
+	Response struct {
+		Failures []json.RawMessage `json:"failures"`
+	} `json:"response"`
+	Error json.RawMessage `json:"error"`
+}
+
+// catchUp copies the writes made to the old index while the new one was filled, see Reindex.
+func (esr *ElasticsearchRepo) catchUp(ctx context.Context, r *reindexer, result *ReindexResult) error {
+	if r.source != nil {
+		// documents copied from the source have versions unrelated to those of the old index
+		if err := esr.copyFromSource(ctx, r, result, NewQuery().Where(Gte("updated_at", r.startedAt))); err != nil {
+			return err
+		}
+		return esr.refreshIndex(ctx, result.NewIndex)
+	}
+	copied := result.Copied
+	if err := esr.copyIndex(ctx, r, result, map[string]interface{}{"version_type": "external"}); err != nil {
+		return err
+	}
+	result.Copied += copied
+	return esr.refreshIndex(ctx, result.NewIndex)
+}
+
+// copyIndex runs _reindex from the old index into the new one as a background task and polls it until it
+// completes, documents already in the new index and not to be replaced under dest's options are skipped.
+func (esr *ElasticsearchRepo) copyIndex(ctx context.Context, r *reindexer, result *ReindexResult, dest map[string]interface{}) error {
+	dest["index"] = result.NewIndex
+	body, err := json.Marshal(map[string]interface{}{
+		"conflicts": "proceed",
+		"source":    map[string]interface{}{"index": result.OldIndex},
+		"dest":      dest,
+	})
+	if err != nil {
+		return err
+	}
+	wait := false
+	req := esapi.ReindexRequest{Body: bytes.NewReader(body), WaitForCompletion: &wait}
+	var started struct {
+		Task string `json:"task"`
+	}
+	if err := esr.perform(ctx, req, "reindexing", &started); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		var task esTask
+		if err := esr.perform(ctx, esapi.TasksGetRequest{TaskID: started.Task}, "checking reindex task", &task); err != nil {
+			return err
+		}
+		status := task.Task.Status
+		result.Copied = status.Created + status.Updated
+		if r.progress != nil {
+			r.progress(ReindexProgress{Index: result.NewIndex, Total: status.Total, Done: status.Created + status.Updated + status.Deleted})
+		}
+		if !task.Completed {
+			continue
+		}
+		if len(task.Error) > 0 && string(task.Error) != "null" {
+			return errors.New(fmt.Sprintf("Error while reindexing %v", string(task.Error)))
+		}
+		if failures := task.Response.Failures; len(failures) > 0 {
+			result.Failed = int64(len(failures))
+			return errors.New(fmt.Sprintf("Error while reindexing %v documents failed, first %v", len(failures), string(failures[0])))
+		}
+		return nil
+	}
+}
+
+// aliasedIndex returns the index behind the repository's alias and true, or the index named like the alias
+// and false, or an empty name when neither exists.
+func (esr *ElasticsearchRepo) aliasedIndex(ctx context.Context) (error, string, bool) {
+	res, err := esapi.IndicesGetAliasRequest{Name: []string{esr.index}}.Do(ctx, esr.client)
+	if err != nil {
+		return err, "", false
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		exists, err := esapi.IndicesExistsRequest{Index: []string{esr.index}}.Do(ctx, esr.client)
+		if err != nil {
+			return err, "", false
+		}
+		defer exists.Body.Close()
+		if exists.StatusCode == http.StatusNotFound {
+			return nil, "", false
+		}
+		return nil, esr.index, false
+	}
+	if !esr.sChecker.IsSuccessFul(res.StatusCode) {
+		return errors.New(fmt.Sprintf("Error while reading aliases %v", res.String())), "", false
+	}
+	var indices map[string]interface{}
+	err = esr.marshaller.BytesToResponse(res.Body, func() interface{} {
+		return &indices
+	})
+	if err != nil {
+		return err, "", false
+	}
+	if len(indices) != 1 {
+		return fmt.Errorf("alias %v points to %v indices", esr.index, len(indices)), "", false
+	}
+	var current string
+	for index := range indices {
+		current = index
+	}
+	return nil, current, true
+}
+
+// latestVersion returns the highest version of the indices behind the alias, 0 when there is none.
+func (esr *ElasticsearchRepo) latestVersion(ctx context.Context) (error, int) {
+	var indices map[string]interface{}
+	req := esapi.IndicesGetRequest{Index: []string{esr.index + "_v*"}}
+	if err := esr.perform(ctx, req, "listing indices", &indices); err != nil {
+		return err, 0
+	}
+	latest := 0
+	for index := range indices {
+		if version, err := strconv.Atoi(strings.TrimPrefix(index, esr.index+"_v")); err == nil && version > latest {
+			latest = version
+		}
+	}
+	return nil, latest
+}
+
+func (esr *ElasticsearchRepo) createIndex(ctx context.Context, name string, withAlias bool) error {
+	err, mapping := esr.mappingDocument()
+	if err != nil {
+		return err
+	}
+	if withAlias {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(mapping, &doc); err != nil {
+			return err
+		}
+		doc["aliases"] = map[string]interface{}{esr.index: map[string]interface{}{"is_write_index": true}}
+		if mapping, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	return esr.perform(ctx, esapi.IndicesCreateRequest{Index: name, Body: bytes.NewReader(mapping)}, "creating index", nil)
+}
+
+// swapAlias points the alias at the new index in a single update, removing an index named like the alias.
+func (esr *ElasticsearchRepo) swapAlias(ctx context.Context, current string, aliased bool, next string) error {
+	var actions []interface{}
+	switch {
+	case current != "" && aliased:
+		actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": current, "alias": esr.index}})
+	case current != "":
+		actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": current}})
+	}
+	actions = append(actions, map[string]interface{}{"add": map[string]interface{}{"index": next, "alias": esr.index, "is_write_index": true}})
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+	return esr.perform(ctx, esapi.IndicesUpdateAliasesRequest{Body: bytes.NewReader(body)}, "swapping alias", nil)
+}
+
+// blockWrites makes the index read only, or writable again.
+func (esr *ElasticsearchRepo) blockWrites(ctx context.Context, name string, block bool) error {
+	body, err := json.Marshal(map[string]interface{}{"index.blocks.write": block})
+	if err != nil {
+		return err
+	}
+	req := esapi.IndicesPutSettingsRequest{Index: []string{name}, Body: bytes.NewReader(body)}
+	return esr.perform(ctx, req, "changing index write block", nil)
+}
+
+func (esr *ElasticsearchRepo) refreshIndex(ctx context.Context, name string) error {
+	return esr.perform(ctx, esapi.IndicesRefreshRequest{Index: []string{name}}, "refreshing index", nil)
+}
+
+func (esr *ElasticsearchRepo) deleteIndex(ctx context.Context, name string) error {
+	return esr.perform(ctx, esapi.IndicesDeleteRequest{Index: []string{name}}, "deleting index", nil)
+}
+
+// perform runs an index management request, decoding the response into response unless it is nil.
+func (esr *ElasticsearchRepo) perform(ctx context.Context, req esapi.Request, action string, response interface{}) error {
+	res, err := req.Do(ctx, esr.client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if !esr.sChecker.IsSuccessFul(res.StatusCode) {
+		return errors.New(fmt.Sprintf("Error while %v %v", action, res.String()))
+	}
+	if response == nil {
+		return nil
+	}
+	return esr.marshaller.BytesToResponse(res.Body, func() interface{} {
+		return response
+	})
+}
+
+func versionedIndex(alias string, version int) string {
+	return fmt.Sprintf("%v_v%v", alias, version)
+}
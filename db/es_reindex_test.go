@@ -0,0 +1,302 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// reindexServer plays the index management API of a cluster holding the widgets index, either behind the
+// widgets alias when aliasOf is set or as an index named widgets.
+type reindexServer struct {
+	mu          sync.Mutex
+	aliasOf     string
+	taskFailure string
+	failAliases bool
+	requests    []string
+	reindexes   []map[string]interface{}
+	aliases     []interface{}
+	settings    []map[string]interface{}
+	bulkIds     [][]string
+}
+
+func (s *reindexServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	request := r.Method + " " + r.URL.Path
+	s.requests = append(s.requests, request)
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case request == "GET /_alias/widgets":
+		if s.aliasOf == "" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{s.aliasOf: map[string]interface{}{"aliases": map[string]interface{}{"widgets": map[string]interface{}{}}}})
+	case request == "HEAD /widgets":
+		if s.aliasOf != "" {
+			http.NotFound(w, r)
+		}
+	case request == "GET /widgets_v*":
+		indices := map[string]interface{}{}
+		if s.aliasOf != "" {
+			indices[s.aliasOf] = map[string]interface{}{}
+		}
+		json.NewEncoder(w).Encode(indices)
+	case request == "POST /_reindex":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.reindexes = append(s.reindexes, body)
+		io.WriteString(w, `{"task":"node:1"}`)
+	case request == "GET /_tasks/node:1":
+		failures := "[]"
+		if s.taskFailure != "" {
+			failures = `[` + s.taskFailure + `]`
+		}
+		io.WriteString(w, `{"completed":true,"task":{"status":{"total":3,"created":2,"updated":1}},"response":{"failures":`+failures+`}}`)
+	case request == "POST /_aliases" && s.failAliases:
+		http.Error(w, `{"error":"cluster unavailable"}`, http.StatusServiceUnavailable)
+	case request == "POST /_aliases":
+		var body map[string][]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.aliases = append(s.aliases, body["actions"]...)
+		io.WriteString(w, `{"acknowledged":true}`)
+	case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/_settings"):
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.settings = append(s.settings, body)
+		io.WriteString(w, `{"acknowledged":true}`)
+	case strings.HasSuffix(r.URL.Path, "/_bulk"):
+		var ids []string
+		var items []map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]map[string]interface{}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+			id := action[string(ESBulkIndex)]["_id"].(string)
+			ids = append(ids, id)
+			items = append(items, map[string]interface{}{string(ESBulkIndex): map[string]interface{}{"_id": id, "status": http.StatusCreated}})
+		}
+		sort.Strings(ids)
+		s.bulkIds = append(s.bulkIds, ids)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": false, "items": items})
+	default:
+		// index creation, refresh and deletion
+		io.WriteString(w, `{"acknowledged":true}`)
+	}
+}
+
+func (s *reindexServer) sent(request string) bool {
+	for _, sent := range s.requests {
+		if sent == request {
+			return true
+		}
+	}
+	return false
+}
+
+func newReindexTestRepo(t *testing.T, server *reindexServer) *ElasticsearchRepo {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{httpServer.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return NewElasticsearchRepo(
+		WithClient(client),
+		WithIndex("widgets"),
+		WithDefaultEntity(&widget{}),
+		WithMarshaller(&HttpBodyUtil{}),
+		WithStatusChecker(&HttpStatusChecker{}),
+		WithESLogger(logger),
+	).(*ElasticsearchRepo)
+}
+
+func aliasAction(action, index string) map[string]interface{} {
+	return map[string]interface{}{action: map[string]interface{}{"index": index, "alias": "widgets"}}
+}
+
+func TestReindexReplacesIndexWithAlias(t *testing.T) {
+	server := &reindexServer{}
+	esr := newReindexTestRepo(t, server)
+	err, result := esr.Reindex(context.Background(), WithReindexPollInterval(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OldIndex != "widgets" || result.NewIndex != "widgets_v1" || result.Copied != 3 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if !server.sent("PUT /widgets_v1") {
+		t.Fatalf("new index not created, sent %v", server.requests)
+	}
+	// the index is replaced by the alias, writes to it are blocked while copying
+	if len(server.settings) != 1 || server.settings[0]["index.blocks.write"] != true {
+		t.Fatalf("expected writes to be blocked once, got %v", server.settings)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"remove_index": map[string]interface{}{"index": "widgets"}},
+		map[string]interface{}{"add": map[string]interface{}{"index": "widgets_v1", "alias": "widgets", "is_write_index": true}},
+	}
+	if !reflect.DeepEqual(server.aliases, expected) {
+		t.Fatalf("alias actions %v, expected %v", server.aliases, expected)
+	}
+	// nothing can be written while copying, so there is nothing to catch up with
+	if len(server.reindexes) != 1 {
+		t.Fatalf("expected a single copy, got %v", server.reindexes)
+	}
+}
+
+func TestReindexSwapsAliasAndCatchesUp(t *testing.T) {
+	server := &reindexServer{aliasOf: "widgets_v1"}
+	esr := newReindexTestRepo(t, server)
+	var progress []ReindexProgress
+	err, result := esr.Reindex(context.Background(), WithReindexPollInterval(1), WithReindexDeleteOld(),
+		WithReindexProgress(func(p ReindexProgress) {
+			progress = append(progress, p)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OldIndex != "widgets_v1" || result.NewIndex != "widgets_v2" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"remove": map[string]interface{}{"index": "widgets_v1", "alias": "widgets"}},
+		map[string]interface{}{"add": map[string]interface{}{"index": "widgets_v2", "alias": "widgets", "is_write_index": true}},
+	}
+	if !reflect.DeepEqual(server.aliases, expected) {
+		t.Fatalf("alias actions %v, expected %v", server.aliases, expected)
+	}
+	// the copy and the catch up pass both keep the versions of the old index
+	if len(server.reindexes) != 2 {
+		t.Fatalf("expected a copy and a catch up pass, got %v", server.reindexes)
+	}
+	for _, body := range server.reindexes {
+		dest := body["dest"].(map[string]interface{})
+		if dest["index"] != "widgets_v2" || dest["version_type"] != "external" || body["conflicts"] != "proceed" {
+			t.Fatalf("unexpected reindex %v", body)
+		}
+	}
+	if len(server.settings) != 0 {
+		t.Fatalf("blocked writes to an aliased index: %v", server.settings)
+	}
+	if !server.sent("DELETE /widgets_v1") || server.sent("DELETE /widgets_v2") {
+		t.Fatalf("expected the old index only to be deleted, sent %v", server.requests)
+	}
+	if len(progress) != 2 || progress[0].Total != 3 || progress[0].Done != 3 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+}
+
+func TestReindexUnblocksIndexOnFailure(t *testing.T) {
+	server := &reindexServer{taskFailure: `{"id":"bolt","cause":{"type":"mapper_parsing_exception"}}`}
+	esr := newReindexTestRepo(t, server)
+	err, result := esr.Reindex(context.Background(), WithReindexPollInterval(1))
+	if err == nil || result.Failed != 1 {
+		t.Fatalf("expected the reindex to fail, got %v %+v", err, result)
+	}
+	if len(server.aliases) != 0 {
+		t.Fatalf("alias moved after a failure: %v", server.aliases)
+	}
+	if len(server.settings) != 2 || server.settings[1]["index.blocks.write"] != false {
+		t.Fatalf("expected writes to be unblocked, got %v", server.settings)
+	}
+	if !server.sent("DELETE /widgets_v1") {
+		t.Fatalf("new index left behind, sent %v", server.requests)
+	}
+}
+
+func TestReindexDeletesNewIndexOnFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *reindexServer
+	}{
+		{name: "copy", server: &reindexServer{aliasOf: "widgets_v1", taskFailure: `{"id":"bolt"}`}},
+		{name: "alias swap", server: &reindexServer{aliasOf: "widgets_v1", failAliases: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			esr := newReindexTestRepo(t, test.server)
+			if err, _ := esr.Reindex(context.Background(), WithReindexPollInterval(1), WithReindexDeleteOld()); err == nil {
+				t.Fatal("expected the reindex to fail")
+			}
+			if !test.server.sent("DELETE /widgets_v2") {
+				t.Fatalf("new index left behind, sent %v", test.server.requests)
+			}
+			if test.server.sent("DELETE /widgets_v1") {
+				t.Fatalf("deleted the index behind the alias, sent %v", test.server.requests)
+			}
+		})
+	}
+}
+
+// updatingSource updates an entity of repo while the first pass copies it.
+type updatingSource struct {
+	*GORMRepository
+	update entity.Base
+	passes int
+}
+
+func (s *updatingSource) Iterate(ctx context.Context, query *Query, fn func(base entity.Base) error) error {
+	s.passes++
+	if err := s.GORMRepository.Iterate(ctx, query, fn); err != nil {
+		return err
+	}
+	if s.passes == 1 {
+		err, _ := s.GORMRepository.Update(context.Background(), s.update.GetExternalId(), &widget{Name: "screw"})
+		return err
+	}
+	return nil
+}
+
+func TestReindexFromSourceCopiesUpdatedEntitiesAgain(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	var created []entity.Base
+	for _, name := range []string{"bolt", "nut", "washer"} {
+		err, base := repo.Create(ctx, &widget{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, base)
+	}
+	server := &reindexServer{aliasOf: "widgets_v1"}
+	esr := newReindexTestRepo(t, server)
+	source := &updatingSource{GORMRepository: repo, update: created[1]}
+
+	err, result := esr.Reindex(ctx, WithReindexSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.reindexes) != 0 {
+		t.Fatalf("copied the old index: %v", server.reindexes)
+	}
+	var all []string
+	for _, base := range created {
+		all = append(all, base.GetExternalId())
+	}
+	sort.Strings(all)
+	// the entity updated while copying is copied again after the alias moved
+	expected := [][]string{all, {created[1].GetExternalId()}}
+	if !reflect.DeepEqual(server.bulkIds, expected) {
+		t.Fatalf("sent %v, expected %v", server.bulkIds, expected)
+	}
+	if result.Copied != 4 || result.Failed != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
@@ -0,0 +1,14 @@
+package db
+
+import "testing"
+
+// Widget and the helpers below share the fixtures of the package with the tests of package db_test.
+type Widget = widget
+
+var NewTestDb = newTestDb
+
+// NewDocumentServerOptions returns the options of a repository over an empty widgets index.
+func NewDocumentServerOptions(t *testing.T) []ElasticsearchRepoOption {
+	t.Helper()
+	return documentServerOptions(t, &documentServer{docs: map[string]map[string]interface{}{}})
+}
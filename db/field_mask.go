@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"strings"
+)
+
+// readOnlyColumns are maintained by the repositories and cannot be set through a field mask.
+var readOnlyColumns = map[string]bool{
+	"id":             true,
+	externalIdColumn: true,
+	"created_at":     true,
+	"updated_at":     true,
+	deletedAtColumn:  true,
+	versionColumn:    true,
+}
+
+// PathsFromFieldMask returns the paths of a protobuf field mask, as sent by grpc-gateway PATCH endpoints.
+func PathsFromFieldMask(mask *fieldmaskpb.FieldMask) []string {
+	if mask == nil {
+		return nil
+	}
+	return mask.GetPaths()
+}
+
+// validatePaths checks that every path names a known field that can be updated. known returns the column or
+// document field a path resolves to, as gorm also resolves Go field names, the checks apply to both.
+func validatePaths(paths []string, known func(path string) (string, bool), tenantField string) error {
+	if len(paths) == 0 {
+		return errors.New("at least one field path is required")
+	}
+	readOnly := func(name string) bool {
+		return readOnlyColumns[name] || (tenantField != "" && name == tenantField)
+	}
+	for _, path := range paths {
+		if strings.Contains(path, ".") {
+			return fmt.Errorf("nested field path %v is not supported", path)
+		}
+		if readOnly(path) {
+			return fmt.Errorf("field %v cannot be updated", path)
+		}
+		name, ok := known(path)
+		if !ok {
+			return fmt.Errorf("unknown field %v", path)
+		}
+		if readOnly(name) {
+			return fmt.Errorf("field %v cannot be updated", path)
+		}
+	}
+	return nil
+}
+
+// UpdateFields sets exactly the columns in paths to their values on base, zero values included,
+// instead of merging the whole entity like Update does.
+func (r *GORMRepository) UpdateFields(ctx context.Context, externalId string, base entity.Base, paths []string) (error, entity.Base) {
+	return retryValue(ctx, r.retryPolicy, "update_fields", func(ctx context.Context) (error, entity.Base) {
+		err, sch := r.parseSchema(base)
+		if err != nil {
+			return err, nil
+		}
+		var columns []string
+		err = validatePaths(paths, func(path string) (string, bool) {
+			field := sch.LookUpField(path)
+			if field == nil || field.DBName == "" {
+				return "", false
+			}
+			columns = append(columns, field.DBName)
+			return field.DBName, true
+		}, r.tenantColumn)
+		if err != nil {
+			return err, nil
+		}
+		source := reflect.Indirect(reflect.ValueOf(base))
+		for attempt := 0; ; attempt++ {
+			err, current := r.GetByExternalId(WithPrimary(ctx), externalId)
+			if err != nil {
+				return err, nil
+			}
+			err, before := r.snapshot(ctx, current)
+			if err != nil {
+				return err, nil
+			}
+			target := reflect.Indirect(reflect.ValueOf(current))
+			for _, column := range columns {
+				field := sch.LookUpField(column)
+				value, _ := field.ValueOf(ctx, source)
+				if err := field.Set(ctx, target, value); err != nil {
+					return err, nil
+				}
+			}
+			selected := append([]string{}, columns...)
+			if field := sch.LookUpField("updated_at"); field != nil {
+				selected = append(selected, field.DBName)
+			}
+			var version uint64
+			if r.versioned {
+				versioned, ok := current.(entity.Versioned)
+				if !ok {
+					return fmt.Errorf("%v does not support optimistic locking", current.GetTable()), nil
+				}
+				version = versioned.GetVersion()
+				if err := r.setColumn(ctx, current, versionColumn, version+1); err != nil {
+					return err, nil
+				}
+				selected = append(selected, versionColumn)
+			}
+			err, after := r.snapshot(ctx, current)
+			if err != nil {
+				return err, nil
+			}
+			changes := r.diffSnapshots(current, before, after)
+			err = r.recorded(ctx, AuditUpdate, current, externalId, changes, func(ctx context.Context) error {
+				tx := r.conn(ctx).Table(string(current.GetTable())).Scopes(r.tenantScope(ctx)).Model(current).Select(selected)
+				if !r.versioned {
+					// the row was read above, no rows affected only means the values did not change on MySQL
+					return tx.Updates(current).Error
+				}
+				tx = tx.Where(clause.Eq{Column: clause.Column{Name: versionColumn}, Value: version}).Updates(current)
+				if tx.Error != nil {
+					return tx.Error
+				}
+				if tx.RowsAffected == 0 {
+					return ErrVersionConflict
+				}
+				return nil
+			})
+			if err == nil {
+				return nil, current
+			}
+			// the masked values do not depend on the row read, so a conflict is simply retried
+			if !errors.Is(err, ErrVersionConflict) || attempt >= r.maxRetries {
+				return err, nil
+			}
+		}
+	})
+}
+
+// UpdateFields sets exactly the fields in paths, named as in the document, to their values on base
+// with a partial document update.
+func (esr *ElasticsearchRepo) UpdateFields(ctx context.Context, externalId string, base entity.Base, paths []string) (error, entity.Base) {
+	fields := jsonFields(reflect.TypeOf(base))
+	if err := validatePaths(paths, func(path string) (string, bool) { return toSnakeCase(path), fields[path] }, esr.tenantField); err != nil {
+		return err, nil
+	}
+	jBody, err := base.ToJson()
+	if err != nil {
+		return err, nil
+	}
+	source := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(jBody), &source); err != nil {
+		return err, nil
+	}
+	doc := make(map[string]interface{})
+	for _, path := range paths {
+		doc[path] = source[path]
+	}
+	if err := esr.partialUpdate(ctx, externalId, doc); err != nil {
+		return err, nil
+	}
+	return esr.GetByExternalId(ctx, externalId)
+}
+
+// jsonFields returns the json names of the fields of typ, including those of embedded structs.
+func jsonFields(typ reflect.Type) map[string]bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	fields := make(map[string]bool)
+	if typ.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			for embedded := range jsonFields(field.Type) {
+				fields[embedded] = true
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
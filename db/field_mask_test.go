@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidatePaths(t *testing.T) {
+	columns := map[string]string{"name": "name", "Name": "name", "quantity": "quantity", "Version": "version", "TenantId": "tenant_id", "tenant_id": "tenant_id"}
+	known := func(path string) (string, bool) {
+		column, ok := columns[path]
+		return column, ok
+	}
+	tests := []struct {
+		name  string
+		paths []string
+		valid bool
+	}{
+		{"column names", []string{"name", "quantity"}, true},
+		{"go field name", []string{"Name"}, true},
+		{"no paths", nil, false},
+		{"nested path", []string{"name.first"}, false},
+		{"unknown field", []string{"colour"}, false},
+		{"read only column", []string{"version"}, false},
+		{"read only go field name", []string{"Version"}, false},
+		{"tenant column", []string{"tenant_id"}, false},
+		{"tenant go field name", []string{"TenantId"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePaths(test.paths, known, "tenant_id")
+			if test.valid && err != nil {
+				t.Fatalf("expected %v to be valid, got %v", test.paths, err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("expected %v to be rejected", test.paths)
+			}
+		})
+	}
+}
+
+func TestUpdateFields(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &widget{Name: "bolt", Quantity: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalId := created.GetExternalId()
+
+	if err, _ := repo.UpdateFields(ctx, externalId, &widget{}, []string{"Id"}); err == nil {
+		t.Fatal("the primary key was updated through its go field name")
+	}
+	err, updated := repo.UpdateFields(ctx, externalId, &widget{Name: "nut"}, []string{"quantity"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := updated.(*widget); w.Quantity != 0 || w.Name != "bolt" {
+		t.Fatalf("expected only quantity to be zeroed, got %+v", w)
+	}
+	// writing the values the row already holds must not be reported as not found
+	if err, _ := repo.UpdateFields(ctx, externalId, &widget{}, []string{"quantity"}); err != nil {
+		t.Fatalf("unchanged update failed: %v", err)
+	}
+}
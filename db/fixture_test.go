@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/byteintellect/go_commons/util"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"strings"
+	"testing"
+	"time"
+)
+
+type widget struct {
+	entity.BaseDomain
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+	TenantId string `json:"tenant_id"`
+}
+
+func (w *widget) GetTable() entity.DomainName {
+	return "widgets"
+}
+
+func (w *widget) ToDto() interface{} {
+	return w
+}
+
+func (w *widget) FromDto(dto interface{}) (entity.Base, error) {
+	return dto.(*widget), nil
+}
+
+func (w *widget) Merge(other interface{}) {
+	o := other.(*widget)
+	if o.Name != "" {
+		w.Name = o.Name
+	}
+	if o.Quantity != 0 {
+		w.Quantity = o.Quantity
+	}
+}
+
+func (w *widget) FromSqlRow(rows *sql.Rows) (entity.Base, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]interface{}{
+		"id":          &w.Id,
+		"external_id": &w.ExternalId,
+		"created_at":  &w.CreatedAt,
+		"updated_at":  &w.UpdatedAt,
+		"deleted_at":  &w.DeletedAt,
+		"status":      &w.Status,
+		"name":        &w.Name,
+		"quantity":    &w.Quantity,
+		"tenant_id":   &w.TenantId,
+	}
+	dest := make([]interface{}, len(columns))
+	for i, column := range columns {
+		if target, ok := targets[column]; ok {
+			dest[i] = target
+		} else {
+			dest[i] = new(interface{})
+		}
+	}
+	return w, rows.Scan(dest...)
+}
+
+func (w *widget) ToJson() (string, error) {
+	jBytes, err := json.Marshal(w)
+	return string(jBytes), err
+}
+
+func (w *widget) String() string {
+	s, _ := w.ToJson()
+	return s
+}
+
+func newWidget() entity.Base {
+	return &widget{}
+}
+
+// newTestDb opens an in memory sqlite database private to the test with the widgets table migrated.
+func newTestDb(t *testing.T) *gorm.DB {
+	t.Helper()
+	return openTestDb(t, t.Name())
+}
+
+// openTestDb opens the in memory sqlite database called name with the widgets table migrated.
+func openTestDb(t *testing.T, name string) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%v?mode=memory&cache=shared", strings.ReplaceAll(name, "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the database lives as long as one of its connections is open
+	sqlDb.SetMaxIdleConns(1)
+	sqlDb.SetConnMaxLifetime(time.Hour)
+	t.Cleanup(func() {
+		sqlDb.Close()
+	})
+	if err := db.Table("widgets").AutoMigrate(&widget{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestRepo(t *testing.T, opts ...GORMRepositoryOption) *GORMRepository {
+	t.Helper()
+	opts = append([]GORMRepositoryOption{WithDb(newTestDb(t)), WithCreator(newWidget)}, opts...)
+	return NewGORMRepository(opts...)
+}
+
+func tenantCtx(tenant string) context.Context {
+	return util.WithTenant(context.Background(), tenant)
+}
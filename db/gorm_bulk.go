@@ -0,0 +1,323 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+)
+
+const externalIdColumn = "external_id"
+
+type batchWriter func(ctx context.Context, batch []entity.Base) error
+
+// BulkCreate inserts the entities in batches, generating external ids for entities without one.
+// A failing batch is retried row by row so that only the offending rows are reported as failed.
+// Every row is recorded like Create records it, in the transaction of its batch.
+func (r *GORMRepository) BulkCreate(ctx context.Context, bases []entity.Base) (error, *BulkResult) {
+	if err := r.prepareBulk(ctx, bases); err != nil {
+		return err, nil
+	}
+	return r.writeInBatches(ctx, bases, func(ctx context.Context, batch []entity.Base) error {
+		if err := r.conn(ctx).Table(string(batch[0].GetTable())).Create(sliceOf(batch)).Error; err != nil {
+			return err
+		}
+		return r.recordBatch(ctx, batch, nil)
+	})
+}
+
+// Upsert inserts the entities, updating every column of rows whose external_id already exists and
+// incrementing their version under optimistic locking. The last writer wins, the version of the entities is
+// not compared with the stored one, BatchUpdate detects conflicting writes. Soft deleted rows are updated but
+// stay deleted. Every row is recorded as a create or an update, and gets the id of its stored row. For tenant
+// scoped repositories rows of another tenant are reported as failed instead of being updated.
+func (r *GORMRepository) Upsert(ctx context.Context, bases []entity.Base) (error, *BulkResult) {
+	if len(bases) == 0 {
+		return nil, &BulkResult{}
+	}
+	if err := r.prepareBulk(ctx, bases); err != nil {
+		return err, nil
+	}
+	err, conflict := r.upsertConflict(ctx, bases[0])
+	if err != nil {
+		return err, nil
+	}
+	return r.writeInBatches(ctx, bases, func(ctx context.Context, batch []entity.Base) error {
+		if err := r.checkOwnership(ctx, batch); err != nil {
+			return err
+		}
+		var existing map[string]entity.Base
+		if r.recording() {
+			if err, existing = r.existing(ctx, batch); err != nil {
+				return err
+			}
+		}
+		if err := r.conn(ctx).Table(string(batch[0].GetTable())).Clauses(conflict).Create(sliceOf(batch)).Error; err != nil {
+			return err
+		}
+		if err := r.storedIds(ctx, batch); err != nil {
+			return err
+		}
+		return r.recordBatch(ctx, batch, existing)
+	})
+}
+
+// BatchUpdate merges each entity into the row with the same external id like Update does, optimistic
+// locking and recording included, one transaction per batch.
+func (r *GORMRepository) BatchUpdate(ctx context.Context, bases []entity.Base) (error, *BulkResult) {
+	for _, base := range bases {
+		if base.GetExternalId() == "" {
+			return errors.New("batch update requires an external id on every entity"), nil
+		}
+	}
+	return r.writeInBatches(ctx, bases, func(ctx context.Context, batch []entity.Base) error {
+		for _, base := range batch {
+			if err, _ := r.Update(ctx, base.GetExternalId(), base); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *GORMRepository) writeInBatches(ctx context.Context, bases []entity.Base, write batchWriter) (error, *BulkResult) {
+	result := &BulkResult{}
+	if len(bases) == 0 {
+		return nil, result
+	}
+	for start := 0; start < len(bases); start += r.batchSize {
+		end := start + r.batchSize
+		if end > len(bases) {
+			end = len(bases)
+		}
+		batch := bases[start:end]
+		err := RunInTx(ctx, r.db, func(ctx context.Context) error {
+			return write(ctx, batch)
+		})
+		if err == nil {
+			result.Succeeded = append(result.Succeeded, batch...)
+			continue
+		}
+		if len(batch) == 1 {
+			result.Failed = append(result.Failed, RowError{Index: start, ExternalId: batch[0].GetExternalId(), Err: err})
+			continue
+		}
+		// each row gets its own savepoint so that a failure does not abort the surrounding transaction
+		for i, base := range batch {
+			err := RunInTx(ctx, r.db, func(ctx context.Context) error {
+				return write(ctx, []entity.Base{base})
+			})
+			if err != nil {
+				result.Failed = append(result.Failed, RowError{Index: start + i, ExternalId: base.GetExternalId(), Err: err})
+				continue
+			}
+			result.Succeeded = append(result.Succeeded, base)
+		}
+	}
+	return nil, result
+}
+
+// existing returns the stored rows of the entities of batch by external id, soft deleted rows included.
+func (r *GORMRepository) existing(ctx context.Context, batch []entity.Base) (error, map[string]entity.Base) {
+	externalIds := make([]string, 0, len(batch))
+	for _, base := range batch {
+		externalIds = append(externalIds, base.GetExternalId())
+	}
+	rows, err := r.conn(ctx).Table(string(batch[0].GetTable())).Where("external_id IN (?)", externalIds).Rows()
+	if err != nil {
+		return err, nil
+	}
+	err, stored := r.populateRows(rows)
+	if err != nil {
+		return err, nil
+	}
+	existing := make(map[string]entity.Base, len(stored))
+	for _, base := range stored {
+		existing[base.GetExternalId()] = base
+	}
+	return nil, existing
+}
+
+// recordBatch records the entities of a bulk write, as updates of the rows in existing and as creates otherwise.
+func (r *GORMRepository) recordBatch(ctx context.Context, batch []entity.Base, existing map[string]entity.Base) error {
+	if !r.recording() {
+		return nil
+	}
+	for _, base := range batch {
+		action := AuditCreate
+		var before map[string]interface{}
+		if stored, ok := existing[base.GetExternalId()]; ok {
+			action = AuditUpdate
+			// the insert left the primary key of updated rows unset
+			if err := r.setColumn(ctx, base, "id", stored.GetId()); err != nil {
+				return err
+			}
+			var err error
+			if err, before = r.snapshot(ctx, stored); err != nil {
+				return err
+			}
+		}
+		err, after := r.snapshot(ctx, base)
+		if err != nil {
+			return err
+		}
+		if err := r.record(ctx, action, base, "", r.diffSnapshots(base, before, after)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareBulk checks that all entities share a type and assigns external ids the way BaseDomain.BeforeCreate does.
+func (r *GORMRepository) prepareBulk(ctx context.Context, bases []entity.Base) error {
+	if len(bases) == 0 {
+		return nil
+	}
+	baseType := reflect.TypeOf(bases[0])
+	for i, base := range bases {
+		if reflect.TypeOf(base) != baseType {
+			return fmt.Errorf("entity at %v is a %v, expected %v", i, reflect.TypeOf(base), baseType)
+		}
+		if err := r.setTenant(ctx, base); err != nil {
+			return err
+		}
+		if base.GetExternalId() == "" {
+			if err := r.setColumn(ctx, base, externalIdColumn, uuid.New().String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// upsertConflict updates every column of the conflicting row but the tenant, the deletion time and the version,
+// which is incremented under optimistic locking. For tenant scoped repositories only rows of the same tenant are
+// updated, with ON CONFLICT ... WHERE or, on MySQL which has no conflict condition, by keeping the stored
+// value of every column of rows of other tenants. storedIds then fails the batch.
+func (r *GORMRepository) upsertConflict(ctx context.Context, base entity.Base) (error, clause.OnConflict) {
+	conflict := clause.OnConflict{Columns: []clause.Column{{Name: externalIdColumn}}}
+	err, sch := r.parseSchema(base)
+	if err != nil {
+		return err, conflict
+	}
+	var columns []string
+	for _, field := range sch.Fields {
+		// the columns UpdateAll would update
+		if field.DBName == "" || field.PrimaryKey || field.AutoCreateTime != 0 ||
+			(field.HasDefaultValue && field.DefaultValueInterface == nil) {
+			continue
+		}
+		if field.DBName == r.tenantColumn || field.DBName == deletedAtColumn || field.DBName == versionColumn {
+			continue
+		}
+		columns = append(columns, field.DBName)
+	}
+	conflict.DoUpdates = clause.AssignmentColumns(columns)
+	if r.versioned {
+		conflict.DoUpdates = append(conflict.DoUpdates, clause.Assignment{
+			Column: clause.Column{Name: versionColumn},
+			Value:  gorm.Expr("? + 1", clause.Column{Table: string(base.GetTable()), Name: versionColumn}),
+		})
+	}
+	if err, tenant, scoped := tenantFor(ctx); r.tenantColumn != "" && err == nil && scoped {
+		conflict.Where = clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Name: r.tenantColumn}, Value: tenant},
+		}}
+		if r.db.Dialector.Name() == string(MySQL) {
+			tenantColumn := clause.Column{Name: r.tenantColumn}
+			for i, assignment := range conflict.DoUpdates {
+				value := assignment.Value
+				if column, ok := value.(clause.Column); ok && column.Table == "excluded" {
+					value = gorm.Expr("VALUES(?)", clause.Column{Name: column.Name})
+				}
+				conflict.DoUpdates[i].Value = gorm.Expr("IF(? = ?, ?, ?)", tenantColumn, tenant, value, assignment.Column)
+			}
+		}
+	}
+	return nil, conflict
+}
+
+// storedIds sets the ids of the stored rows on the entities of an upsert, the ids MySQL reports for rows
+// updated by ON DUPLICATE KEY UPDATE are not theirs. For tenant scoped repositories it fails when a row
+// belongs to another tenant, which a concurrent insert can cause after checkOwnership.
+func (r *GORMRepository) storedIds(ctx context.Context, batch []entity.Base) error {
+	externalIds := make([]interface{}, 0, len(batch))
+	for _, base := range batch {
+		externalIds = append(externalIds, base.GetExternalId())
+	}
+	columns := []string{"id", externalIdColumn}
+	if r.tenantColumn != "" {
+		columns = append(columns, r.tenantColumn)
+	}
+	var rows []map[string]interface{}
+	err := r.conn(ctx).Table(string(batch[0].GetTable())).Select(columns).
+		Where(clause.IN{Column: clause.Column{Name: externalIdColumn}, Values: externalIds}).
+		Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	err, tenant, scoped := tenantFor(ctx)
+	scoped = r.tenantColumn != "" && err == nil && scoped
+	stored := make(map[string]interface{}, len(rows))
+	var foreign []string
+	for _, row := range rows {
+		externalId := fmt.Sprint(row[externalIdColumn])
+		if scoped && fmt.Sprint(row[r.tenantColumn]) != tenant {
+			foreign = append(foreign, externalId)
+		}
+		stored[externalId] = row["id"]
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("%w: %v", ErrForeignTenant, foreign)
+	}
+	for _, base := range batch {
+		id, ok := stored[base.GetExternalId()]
+		if !ok {
+			return fmt.Errorf("upserted row %v not found", base.GetExternalId())
+		}
+		if err := r.setColumn(ctx, base, "id", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOwnership fails when any of the entities already exists for another tenant, soft deleted rows included.
+func (r *GORMRepository) checkOwnership(ctx context.Context, batch []entity.Base) error {
+	if r.tenantColumn == "" {
+		return nil
+	}
+	err, tenant, scoped := tenantFor(ctx)
+	if err != nil || !scoped {
+		return err
+	}
+	externalIds := make([]interface{}, 0, len(batch))
+	for _, base := range batch {
+		externalIds = append(externalIds, base.GetExternalId())
+	}
+	var foreign []string
+	err = r.conn(ctx).Table(string(batch[0].GetTable())).
+		Where(clause.IN{Column: clause.Column{Name: externalIdColumn}, Values: externalIds}).
+		Where(clause.Neq{Column: clause.Column{Name: r.tenantColumn}, Value: tenant}).
+		Pluck(externalIdColumn, &foreign).Error
+	if err != nil {
+		return err
+	}
+	if len(foreign) > 0 {
+		return fmt.Errorf("%w: %v", ErrForeignTenant, foreign)
+	}
+	return nil
+}
+
+// sliceOf converts entities of the same concrete type into a typed slice gorm can batch insert.
+func sliceOf(bases []entity.Base) interface{} {
+	slice := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(bases[0])), 0, len(bases))
+	for _, base := range bases {
+		slice = reflect.Append(slice, reflect.ValueOf(base))
+	}
+	return slice.Interface()
+}
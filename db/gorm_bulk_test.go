@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/entity"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+)
+
+func TestBulkWritesAreAudited(t *testing.T) {
+	db := newTestDb(t)
+	audit := NewAuditLog(db)
+	if err := audit.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewGORMRepository(WithDb(db), WithCreator(newWidget), WithAuditLog(audit))
+	ctx := context.Background()
+
+	bolt := &widget{Name: "bolt"}
+	err, result := repo.BulkCreate(ctx, []entity.Base{bolt, &widget{Name: "nut"}})
+	if err != nil || len(result.Failed) != 0 {
+		t.Fatalf("bulk create failed: %v %+v", err, result)
+	}
+	renamed := &widget{Name: "washer"}
+	renamed.ExternalId = bolt.ExternalId
+	if err, result := repo.Upsert(ctx, []entity.Base{renamed}); err != nil || len(result.Failed) != 0 {
+		t.Fatalf("upsert failed: %v %+v", err, result)
+	}
+	// the values are already stored, which MySQL reports as no rows affected
+	if err, result := repo.BatchUpdate(ctx, []entity.Base{renamed}); err != nil || len(result.Failed) != 0 {
+		t.Fatalf("batch update failed: %v %+v", err, result)
+	}
+
+	err, entries := repo.History(ctx, bolt.ExternalId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []AuditAction
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	if len(actions) != 3 || actions[0] != AuditCreate || actions[1] != AuditUpdate || actions[2] != AuditUpdate {
+		t.Fatalf("expected a create and two updates, got %v", actions)
+	}
+	var renames []FieldChange
+	for _, change := range entries[1].Changes {
+		if change.Field == "name" {
+			renames = append(renames, change)
+		}
+	}
+	if len(renames) != 1 || renames[0].Old != "bolt" || renames[0].New != "washer" {
+		t.Fatalf("upsert recorded %+v", entries[1].Changes)
+	}
+}
+
+func TestBulkCreateKeepsDefaultBatchSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		repo := newTestRepo(t, WithBatchSize(size))
+		err, result := repo.BulkCreate(context.Background(), []entity.Base{&widget{Name: "bolt"}, &widget{Name: "nut"}})
+		if err != nil || len(result.Succeeded) != 2 {
+			t.Fatalf("bulk create with batch size %v: %v %+v", size, err, result)
+		}
+	}
+}
+
+func TestUpsertSetsStoredIds(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	var stored []entity.Base
+	for _, name := range []string{"bolt", "nut", "washer"} {
+		err, created := repo.Create(ctx, &widget{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored = append(stored, created)
+	}
+	renamed := &widget{Name: "screw"}
+	renamed.ExternalId = stored[0].GetExternalId()
+	added := &widget{Name: "rivet"}
+	err, result := repo.Upsert(ctx, []entity.Base{added, renamed})
+	if err != nil || len(result.Failed) != 0 {
+		t.Fatalf("upsert failed: %v %+v", err, result)
+	}
+	if renamed.Id != stored[0].GetId() {
+		t.Fatalf("updated row got id %v, expected %v", renamed.Id, stored[0].GetId())
+	}
+	err, found := repo.GetByExternalId(ctx, added.ExternalId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.Id != found.GetId() {
+		t.Fatalf("inserted row got id %v, expected %v", added.Id, found.GetId())
+	}
+}
+
+func TestUpsertRejectsRowsOfOtherTenants(t *testing.T) {
+	repo := newTestRepo(t, WithTenantColumn("tenant_id"))
+	err, created := repo.Create(tenantCtx("acme"), &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken := &widget{Name: "nut"}
+	taken.ExternalId = created.GetExternalId()
+	// the row was inserted after the ownership check
+	err = repo.storedIds(tenantCtx("globex"), []entity.Base{taken})
+	if !errors.Is(err, ErrForeignTenant) {
+		t.Fatalf("expected the foreign row to fail the batch, got %v", err)
+	}
+	err, result := repo.Upsert(tenantCtx("globex"), []entity.Base{taken})
+	if err != nil || len(result.Failed) != 1 || !errors.Is(result.Failed[0].Err, ErrForeignTenant) {
+		t.Fatalf("expected the foreign row to fail, got %v %+v", err, result)
+	}
+	err, stored := repo.GetByExternalId(tenantCtx("acme"), created.GetExternalId())
+	if err != nil || stored.(*widget).Name != "bolt" {
+		t.Fatalf("foreign row changed to %+v: %v", stored, err)
+	}
+}
+
+func TestMySQLUpsertOnlyUpdatesRowsOfTenant(t *testing.T) {
+	repo := NewGORMRepository(WithDb(dryRunDb(t)), WithCreator(newWidget), WithTenantColumn("tenant_id"))
+	base := &widget{Name: "bolt"}
+	err, conflict := repo.upsertConflict(tenantCtx("acme"), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := repo.db.Session(&gorm.Session{SkipDefaultTransaction: true}).Table("widgets").Clauses(conflict).Create(base)
+	if tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	sql := tx.Statement.SQL.String()
+	for _, assignment := range []string{
+		"`name`=IF(`tenant_id` = ?, VALUES(`name`), `name`)",
+		"`quantity`=IF(`tenant_id` = ?, VALUES(`quantity`), `quantity`)",
+	} {
+		if !strings.Contains(sql, assignment) {
+			t.Fatalf("expected %v in %v", assignment, sql)
+		}
+	}
+	for _, column := range []string{"`tenant_id`=", "`deleted_at`="} {
+		if strings.Contains(sql, column) {
+			t.Fatalf("%v is updated by %v", column, sql)
+		}
+	}
+}
+
+func TestUpsertKeepsSoftDeletedRowsDeleted(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SoftDelete(ctx, created.GetExternalId()); err != nil {
+		t.Fatal(err)
+	}
+	renamed := &widget{Name: "nut"}
+	renamed.ExternalId = created.GetExternalId()
+	err, result := repo.Upsert(ctx, []entity.Base{renamed})
+	if err != nil || len(result.Failed) != 0 {
+		t.Fatalf("upsert failed: %v %+v", err, result)
+	}
+	if err, found := repo.GetByExternalId(ctx, created.GetExternalId()); err == nil {
+		t.Fatalf("upsert revived the soft deleted row %+v", found)
+	}
+	err, stored := repo.GetByExternalId(WithDeleted(ctx), created.GetExternalId())
+	if err != nil || stored.(*widget).Name != "nut" || stored.(*widget).DeletedAt == nil {
+		t.Fatalf("stored %+v, expected the deleted row to be updated: %v", stored, err)
+	}
+}
+
+func TestVersionedUpsertLastWriterWins(t *testing.T) {
+	db, repo := newVersionedTestRepo(t, WithOptimisticLocking(0))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &gadget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, _ := repo.Update(ctx, created.GetExternalId(), &gadget{Quantity: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// read at version 0, updated since
+	stale := &gadget{Name: "nut"}
+	stale.ExternalId = created.GetExternalId()
+	err, result := repo.Upsert(ctx, []entity.Base{stale})
+	if err != nil || len(result.Failed) != 0 {
+		t.Fatalf("upsert failed: %v %+v", err, result)
+	}
+	if stored := storedGadget(t, db, created.GetExternalId()); stored.Name != "nut" || stored.Version != 2 {
+		t.Fatalf("stored %+v, expected the stale upsert to be applied at version 2", stored)
+	}
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/entity"
+	"gorm.io/gorm/clause"
+)
+
+// Iterate calls fn for every row matching the query, in the query's sort order with id as the tiebreaker.
+// Rows are read in chunks using keyset pagination, so only one chunk is held in memory at a time. Each chunk
+// is read in full and its connection released before fn is called, so fn may use the repository.
+// A non zero query limit caps the total number of rows visited, iteration stops at the first error
+// returned by fn or when ctx is cancelled.
+func (r *GORMRepository) Iterate(ctx context.Context, query *Query, fn func(base entity.Base) error) error {
+	if err := query.Validate(); err != nil {
+		return err
+	}
+	if query.Offset > 0 {
+		return errors.New("offset is not supported when iterating")
+	}
+	base := r.creator()
+	if err := r.checkColumns(base, query.Fields()); err != nil {
+		return err
+	}
+	keys := keysetSort(query)
+	var cursor *Cursor
+	visited := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk := r.chunkSize
+		if query.Limit > 0 && query.Limit-visited < chunk {
+			chunk = query.Limit - visited
+		}
+		conditions := query.Conditions
+		if cursor != nil {
+			conditions = append(append([]Condition{}, conditions...), keysetCondition(keys, cursor))
+		}
+		tx := r.filtered(ctx, base, conditions)
+		for _, key := range keys {
+			tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: key.Field}, Desc: key.Desc})
+		}
+		rows, err := tx.Limit(chunk).Rows()
+		if err != nil {
+			return err
+		}
+		err, bases := r.populateRows(rows)
+		if err != nil {
+			return err
+		}
+		for _, base := range bases {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(base); err != nil {
+				return err
+			}
+		}
+		visited += len(bases)
+		if len(bases) == 0 || len(bases) < chunk || (query.Limit > 0 && visited >= query.Limit) {
+			return nil
+		}
+		last := bases[len(bases)-1]
+		err, values := r.columnValues(ctx, last, keys[:len(keys)-1])
+		if err != nil {
+			return err
+		}
+		cursor = &Cursor{Values: values, Id: last.GetId()}
+	}
+}
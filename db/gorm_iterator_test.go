@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"github.com/byteintellect/go_commons/entity"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIterate(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		chunkSize int
+		query     *Query
+		expected  []string
+	}{
+		{name: "chunks ending on a full chunk", chunkSize: 2, query: NewQuery().OrderBy("quantity", true), expected: []string{"screw", "nut", "bolt", "washer"}},
+		{name: "chunks ending on a partial chunk", chunkSize: 3, query: NewQuery().OrderBy("quantity", true), expected: []string{"screw", "nut", "bolt", "washer"}},
+		{name: "limit", chunkSize: 3, query: NewQuery().OrderBy("quantity", false).WithLimit(2), expected: []string{"bolt", "washer"}},
+		{name: "conditions", chunkSize: 1, query: NewQuery().Where(Gt("quantity", 2)), expected: []string{"nut", "screw"}},
+		{name: "zero chunk size", chunkSize: 0, query: NewQuery(), expected: []string{"bolt", "nut", "washer", "screw"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := newTestRepo(t, WithChunkSize(test.chunkSize))
+			for _, w := range []*widget{{Name: "bolt", Quantity: 2}, {Name: "nut", Quantity: 5}, {Name: "washer", Quantity: 2}, {Name: "screw", Quantity: 9}} {
+				if err, _ := repo.Create(ctx, w); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var names []string
+			err := repo.Iterate(ctx, test.query, func(base entity.Base) error {
+				names = append(names, base.(*widget).Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Fatalf("visited %v, expected %v", names, test.expected)
+			}
+		})
+	}
+}
+
+func TestIterateReleasesConnectionBeforeCallingFn(t *testing.T) {
+	db := newTestDb(t)
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fn could not get a connection while the rows of its chunk are open
+	sqlDb.SetMaxOpenConns(1)
+	repo := NewGORMRepository(WithDb(db), WithCreator(newWidget), WithChunkSize(2))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, name := range []string{"bolt", "nut", "washer"} {
+		if err, _ := repo.Create(ctx, &widget{Name: name, Quantity: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = repo.Iterate(ctx, NewQuery(), func(base entity.Base) error {
+		err, _ := repo.Update(ctx, base.GetExternalId(), &widget{Quantity: 7})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, count := repo.Count(ctx, Eq("quantity", 7)); err != nil || count != 3 {
+		t.Fatalf("updated %v widgets while iterating, expected 3: %v", count, err)
+	}
+}
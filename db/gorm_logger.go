@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/util"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	gLogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+	"time"
+)
+
+const defaultSlowThreshold = time.Second
+
+// ParseLogLevel maps silent, error, warn and info to GORM log levels, anything else is info.
+func ParseLogLevel(level string) gLogger.LogLevel {
+	switch level {
+	case "silent":
+		return gLogger.Silent
+	case "error":
+		return gLogger.Error
+	case "warn":
+		return gLogger.Warn
+	default:
+		return gLogger.Info
+	}
+}
+
+// ZapGormLogger writes GORM's logs to a zap logger, tagging every entry with the request's correlation id.
+type ZapGormLogger struct {
+	logger        *zap.Logger
+	level         gLogger.LogLevel
+	slowThreshold time.Duration
+}
+
+func NewZapGormLogger(logger *zap.Logger, level gLogger.LogLevel, slowThreshold time.Duration) *ZapGormLogger {
+	if slowThreshold <= 0 {
+		slowThreshold = defaultSlowThreshold
+	}
+	return &ZapGormLogger{
+		logger:        logger,
+		level:         level,
+		slowThreshold: slowThreshold,
+	}
+}
+
+func (l *ZapGormLogger) LogMode(level gLogger.LogLevel) gLogger.Interface {
+	logger := *l
+	logger.level = level
+	return &logger
+}
+
+func (l *ZapGormLogger) fields(ctx context.Context, fields ...zap.Field) []zap.Field {
+	if requestId := util.RequestId(ctx); requestId != "" {
+		fields = append(fields, zap.String("request_id", requestId))
+	}
+	return append(fields, zap.String("source", utils.FileWithLineNum()))
+}
+
+func (l *ZapGormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gLogger.Info {
+		l.logger.Info(fmt.Sprintf(msg, data...), l.fields(ctx)...)
+	}
+}
+
+func (l *ZapGormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gLogger.Warn {
+		l.logger.Warn(fmt.Sprintf(msg, data...), l.fields(ctx)...)
+	}
+}
+
+func (l *ZapGormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gLogger.Error {
+		l.logger.Error(fmt.Sprintf(msg, data...), l.fields(ctx)...)
+	}
+}
+
+// Trace logs failed statements as errors, statements slower than the threshold as warnings and,
+// at info level, every other statement. Record not found errors are not logged.
+func (l *ZapGormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gLogger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	sql, rows := fc()
+	fields := l.fields(ctx, zap.String("sql", sql), zap.Int64("rows", rows), zap.Duration("elapsed", elapsed))
+	switch {
+	case err != nil && l.level >= gLogger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		l.logger.Error("sql error", append(fields, zap.Error(err))...)
+	case elapsed > l.slowThreshold && l.level >= gLogger.Warn:
+		l.logger.Warn("slow sql", append(fields, zap.Duration("threshold", l.slowThreshold))...)
+	case l.level >= gLogger.Info:
+		l.logger.Info("sql", fields...)
+	}
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"github.com/byteintellect/go_commons/util"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	gLogger "gorm.io/gorm/logger"
+	"testing"
+)
+
+func TestZapGormLoggerTagsRequestId(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	db := newTestDb(t)
+	db.Logger = NewZapGormLogger(zap.New(core), gLogger.Info, 0)
+	ctx := util.WithRequestId(context.Background(), "request-1")
+
+	if err := db.WithContext(ctx).Table("widgets").Find(&[]widget{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WithContext(context.Background()).Table("widgets").Find(&[]widget{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.FilterMessage("sql").AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 sql entries, got %v", len(entries))
+	}
+	if requestId := entries[0].ContextMap()["request_id"]; requestId != "request-1" {
+		t.Fatalf("logged request id %v, expected request-1", requestId)
+	}
+	if _, ok := entries[1].ContextMap()["request_id"]; ok {
+		t.Fatal("logged a request id for a statement without one")
+	}
+	if sql, _ := entries[0].ContextMap()["sql"].(string); sql != "SELECT * FROM `widgets`" {
+		t.Fatalf("logged sql %q", sql)
+	}
+}
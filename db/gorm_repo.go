@@ -4,17 +4,50 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/byteintellect/go_commons/entity"
+	cfErrors "github.com/byteintellect/go_commons/errors"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"time"
 )
 
+const (
+	deletedAtColumn  = "deleted_at"
+	versionColumn    = "version"
+	defaultBatchSize = 100
+	defaultChunkSize = 1000
+)
+
+// ErrVersionConflict is returned by Update when the row was changed since it was read.
+var ErrVersionConflict = cfErrors.CFConflict
+
 type GORMRepositoryOption func(repository *GORMRepository)
 
 type GORMRepository struct {
 	db      *gorm.DB
 	creator entity.EntityCreator
 	logger  *logrus.Logger
+	columns map[string]bool
+	codec   *CursorCodec
+
+	hardDelete bool
+	versioned  bool
+	maxRetries int
+	batchSize  int
+	chunkSize  int
+	replicas   *ReplicaSet
+	audit      *AuditLog
+	outbox     *Outbox
+	events     OutboxEventFactory
+
+	tenantColumn     string
+	retryPolicy      *RetryPolicy
+	history          bool
+	historyTableName string
 }
 
 func WithCreator(creator entity.EntityCreator) GORMRepositoryOption {
@@ -35,36 +68,170 @@ func WithDb(db *gorm.DB) GORMRepositoryOption {
 	}
 }
 
+// WithSearchableColumns restricts the columns that can be filtered and sorted on by Search,
+// by default every column of the entity's table is searchable.
+func WithSearchableColumns(columns ...string) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.columns = make(map[string]bool)
+		for _, column := range columns {
+			r.columns[column] = true
+		}
+	}
+}
+
+func WithCursorCodec(codec *CursorCodec) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.codec = codec
+	}
+}
+
+// WithHardDelete makes Delete remove rows instead of soft deleting them.
+func WithHardDelete() GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.hardDelete = true
+	}
+}
+
+// WithOptimisticLocking makes Update conditional on the version read, incrementing it on success.
+// The entities must implement entity.Versioned, typically by embedding entity.VersionedDomain.
+// When the caller does not pin a version the merge is retried up to maxRetries times on conflict.
+func WithOptimisticLocking(maxRetries int) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.versioned = true
+		r.maxRetries = maxRetries
+	}
+}
+
+// WithBatchSize sets the number of rows written per statement by the bulk operations, sizes below one keep the default.
+func WithBatchSize(batchSize int) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		if batchSize > 0 {
+			r.batchSize = batchSize
+		}
+	}
+}
+
+// WithChunkSize sets the number of rows fetched per query by Iterate, sizes below one keep the default.
+func WithChunkSize(chunkSize int) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		if chunkSize > 0 {
+			r.chunkSize = chunkSize
+		}
+	}
+}
+
+// WithReplicaSet routes reads to the replica set's replicas and writes to its primary.
+func WithReplicaSet(replicas *ReplicaSet) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.replicas = replicas
+		r.db = replicas.Primary()
+	}
+}
+
 func (r *GORMRepository) GetDb() interface{} {
 	// users will have to cast this to *gorm.Db
 	return r.db
 }
 
 func NewGORMRepository(opts ...GORMRepositoryOption) *GORMRepository {
-	repo := GORMRepository{}
+	repo := GORMRepository{
+		batchSize: defaultBatchSize,
+		chunkSize: defaultChunkSize,
+	}
 	for _, opt := range opts {
 		opt(&repo)
 	}
 	return &repo
 }
 
-func (r *GORMRepository) GetById(ctx context.Context, id uint64) (error, entity.Base) {
-	entity := r.creator()
-	if err := r.db.Table(string(entity.GetTable())).WithContext(ctx).Where("id = ?", id).First(&entity).Error; err != nil {
-		return err, nil
+// conn returns the connection for ctx, joining the transaction started by RunInTx if there is one.
+func (r *GORMRepository) conn(ctx context.Context) *gorm.DB {
+	return conn(ctx, r.db)
+}
+
+// reader returns the connection for reads, a replica unless ctx carries a transaction or asks for the primary.
+func (r *GORMRepository) reader(ctx context.Context) *gorm.DB {
+	if r.replicas == nil || InTx(ctx) {
+		return r.conn(ctx)
 	}
-	return nil, entity
+	return r.replicas.Reader(ctx).WithContext(ctx)
 }
 
-func (r *GORMRepository) GetByExternalId(ctx context.Context, externalId string) (error, entity.Base) {
-	entity := r.creator()
-	if err := r.db.WithContext(ctx).Table(string(entity.GetTable())).Where("external_id = ?", externalId).First(entity).Error; err != nil {
-		return err, nil
+// recorded runs write and records its audit entry, history snapshot and outbox event in the same transaction,
+// when none of them is configured it only runs write.
+// An empty externalId is read from base after write, for creates where it is assigned on insert.
+func (r *GORMRepository) recorded(ctx context.Context, action AuditAction, base entity.Base, externalId string, changes []FieldChange, write func(ctx context.Context) error) error {
+	if !r.recording() {
+		return write(ctx)
+	}
+	return RunInTx(ctx, r.db, func(ctx context.Context) error {
+		if err := write(ctx); err != nil {
+			return err
+		}
+		return r.record(ctx, action, base, externalId, changes)
+	})
+}
+
+// recording reports whether writes are recorded by an audit log, the history table or an outbox.
+func (r *GORMRepository) recording() bool {
+	return r.audit != nil || r.outbox != nil || r.history
+}
+
+// record stores the audit entry, history snapshot and outbox event of a write made in the transaction of ctx.
+func (r *GORMRepository) record(ctx context.Context, action AuditAction, base entity.Base, externalId string, changes []FieldChange) error {
+	if externalId == "" {
+		externalId = base.GetExternalId()
+	}
+	if r.audit != nil {
+		err, tenant := r.storedTenant(ctx, base, externalId)
+		if err != nil {
+			return err
+		}
+		if err := r.audit.Record(ctx, &AuditEntry{
+			EntityType: string(base.GetTable()),
+			EntityId:   externalId,
+			Tenant:     tenant,
+			Action:     action,
+			Changes:    changes,
+		}); err != nil {
+			return err
+		}
 	}
-	return nil, entity
+	if r.history {
+		if err := r.recordHistory(ctx, action, base, externalId); err != nil {
+			return err
+		}
+	}
+	if r.outbox != nil {
+		if event := r.events(ctx, action, externalId, base); event != nil {
+			return r.outbox.Add(ctx, event)
+		}
+	}
+	return nil
+}
+
+func (r *GORMRepository) GetById(ctx context.Context, id uint64) (error, entity.Base) {
+	return retryRead(ctx, r.retryPolicy, "get_by_id", func(ctx context.Context) (error, entity.Base) {
+		entity := r.creator()
+		if err := r.reader(ctx).Table(string(entity.GetTable())).Scopes(notDeleted(ctx), r.tenantScope(ctx)).Where("id = ?", id).First(&entity).Error; err != nil {
+			return err, nil
+		}
+		return nil, entity
+	})
+}
+
+func (r *GORMRepository) GetByExternalId(ctx context.Context, externalId string) (error, entity.Base) {
+	return retryRead(ctx, r.retryPolicy, "get_by_external_id", func(ctx context.Context) (error, entity.Base) {
+		entity := r.creator()
+		if err := r.reader(ctx).Table(string(entity.GetTable())).Scopes(notDeleted(ctx), r.tenantScope(ctx)).Where("external_id = ?", externalId).First(entity).Error; err != nil {
+			return err, nil
+		}
+		return nil, entity
+	})
 }
 
 func (r *GORMRepository) populateRows(rows *sql.Rows) (error, []entity.Base) {
+	defer rows.Close()
 	var models []entity.Base
 	for rows.Next() {
 		entity := r.creator()
@@ -74,37 +241,476 @@ func (r *GORMRepository) populateRows(rows *sql.Rows) (error, []entity.Base) {
 		}
 		models = append(models, entity)
 	}
+	if err := rows.Err(); err != nil {
+		return err, nil
+	}
 	return nil, models
 }
 
 func (r *GORMRepository) MultiGetByExternalId(ctx context.Context, externalIds []string) (error, []entity.Base) {
-	entity := r.creator()
-	rows, err := r.db.WithContext(ctx).Table(string(entity.GetTable())).Where("external_id IN (?)", externalIds).Rows()
-	if err != nil {
-		return err, nil
-	}
-	return r.populateRows(rows)
+	return retryRead(ctx, r.retryPolicy, "multi_get_by_external_id", func(ctx context.Context) (error, []entity.Base) {
+		entity := r.creator()
+		rows, err := r.reader(ctx).Table(string(entity.GetTable())).Scopes(notDeleted(ctx), r.tenantScope(ctx)).Where("external_id IN (?)", externalIds).Rows()
+		if err != nil {
+			return err, nil
+		}
+		return r.populateRows(rows)
+	})
 }
 
 func (r *GORMRepository) Create(ctx context.Context, base entity.Base) (error, entity.Base) {
-	if err := r.db.Table(string(base.GetTable())).Model(base).WithContext(ctx).Create(base).Error; err != nil {
+	if err := r.setTenant(ctx, base); err != nil {
+		return err, nil
+	}
+	create := func(ctx context.Context) error {
+		if err := r.conn(ctx).Table(string(base.GetTable())).Model(base).Create(base).Error; err != nil {
+			return err
+		}
+		// recorded after the insert, once the hooks and the database have set the ids and defaults
+		return r.recordBatch(ctx, []entity.Base{base}, nil)
+	}
+	var err error
+	if r.recording() {
+		err = RunInTx(ctx, r.db, create)
+	} else {
+		err = create(ctx)
+	}
+	if err != nil {
 		return err, nil
 	}
 	return nil, base
 }
 
 func (r *GORMRepository) Update(ctx context.Context, externalId string, updatedBase entity.Base) (error, entity.Base) {
-	err, entity := r.GetByExternalId(ctx, externalId)
+	return retryValue(ctx, r.retryPolicy, "update", func(ctx context.Context) (error, entity.Base) {
+		if r.versioned {
+			return r.versionedUpdate(ctx, externalId, updatedBase)
+		}
+		err, entity := r.GetByExternalId(WithPrimary(ctx), externalId)
+		if err != nil {
+			return err, nil
+		}
+		err, before := r.snapshot(ctx, entity)
+		if err != nil {
+			return err, nil
+		}
+		entity.Merge(updatedBase)
+		if err := r.setTenant(ctx, entity); err != nil {
+			return err, nil
+		}
+		err, after := r.snapshot(ctx, entity)
+		if err != nil {
+			return err, nil
+		}
+		changes := r.diffSnapshots(entity, before, after)
+		err = r.recorded(ctx, AuditUpdate, entity, externalId, changes, func(ctx context.Context) error {
+			return r.conn(ctx).Table(string(entity.GetTable())).Scopes(r.tenantScope(ctx)).Model(entity).Updates(entity).Error
+		})
+		if err != nil {
+			return err, nil
+		}
+		return nil, entity
+	})
+}
+
+// versionedUpdate issues UPDATE ... WHERE version = ? and re-reads and merges again on conflict.
+// A non zero version on updatedBase is treated as the version the caller expects, such updates are not retried.
+func (r *GORMRepository) versionedUpdate(ctx context.Context, externalId string, updatedBase entity.Base) (error, entity.Base) {
+	var expected uint64
+	if versioned, ok := updatedBase.(entity.Versioned); ok {
+		expected = versioned.GetVersion()
+	}
+	for attempt := 0; ; attempt++ {
+		err, current := r.GetByExternalId(WithPrimary(ctx), externalId)
+		if err != nil {
+			return err, nil
+		}
+		versioned, ok := current.(entity.Versioned)
+		if !ok {
+			return fmt.Errorf("%v does not support optimistic locking", current.GetTable()), nil
+		}
+		version := versioned.GetVersion()
+		if expected != 0 && expected != version {
+			return ErrVersionConflict, nil
+		}
+		err, before := r.snapshot(ctx, current)
+		if err != nil {
+			return err, nil
+		}
+		current.Merge(updatedBase)
+		if err := r.setTenant(ctx, current); err != nil {
+			return err, nil
+		}
+		if err := r.setColumn(ctx, current, versionColumn, version+1); err != nil {
+			return err, nil
+		}
+		err, after := r.snapshot(ctx, current)
+		if err != nil {
+			return err, nil
+		}
+		changes := r.diffSnapshots(current, before, after)
+		err = r.recorded(ctx, AuditUpdate, current, externalId, changes, func(ctx context.Context) error {
+			tx := r.conn(ctx).Table(string(current.GetTable())).Scopes(r.tenantScope(ctx)).Model(current).
+				Where(clause.Eq{Column: clause.Column{Name: versionColumn}, Value: version}).
+				Updates(current)
+			if tx.Error != nil {
+				return tx.Error
+			}
+			if tx.RowsAffected == 0 {
+				return ErrVersionConflict
+			}
+			return nil
+		})
+		if err == nil {
+			return nil, current
+		}
+		if !errors.Is(err, ErrVersionConflict) {
+			return err, nil
+		}
+		if expected != 0 || attempt >= r.maxRetries {
+			return ErrVersionConflict, nil
+		}
+	}
+}
+
+func (r *GORMRepository) setColumn(ctx context.Context, base entity.Base, column string, value interface{}) error {
+	err, sch := r.parseSchema(base)
+	if err != nil {
+		return err
+	}
+	field := sch.LookUpField(column)
+	if field == nil {
+		return fmt.Errorf("unknown column %v", column)
+	}
+	return field.Set(ctx, reflect.Indirect(reflect.ValueOf(base)), value)
+}
+
+// Delete soft deletes the entity, or removes it when the repository is configured WithHardDelete.
+func (r *GORMRepository) Delete(ctx context.Context, externalId string) error {
+	if r.hardDelete {
+		return r.HardDelete(ctx, externalId)
+	}
+	return r.SoftDelete(ctx, externalId)
+}
+
+// SoftDelete sets deleted_at and marks the entity inactive, soft deleted entities are excluded from reads
+// unless the context is created with WithDeleted.
+func (r *GORMRepository) SoftDelete(ctx context.Context, externalId string) error {
+	return r.retryPolicy.Do(ctx, "soft_delete", func(ctx context.Context) error {
+		base := r.creator()
+		values := map[string]interface{}{
+			deletedAtColumn: time.Now(),
+			"status":        entity.GetStatusInt("inactive"),
+		}
+		err, changes := r.columnChanges(ctx, externalId, values)
+		if err != nil {
+			return err
+		}
+		return appliedOnRetry(ctx, r.recorded(ctx, AuditSoftDelete, base, externalId, changes, func(ctx context.Context) error {
+			tx := r.conn(ctx).Table(string(base.GetTable())).Scopes(r.tenantScope(ctx)).
+				Where("external_id = ? AND deleted_at IS NULL", externalId).
+				Updates(values)
+			return rowAffected(tx)
+		}))
+	})
+}
+
+func (r *GORMRepository) Restore(ctx context.Context, externalId string) error {
+	return r.retryPolicy.Do(ctx, "restore", func(ctx context.Context) error {
+		base := r.creator()
+		values := map[string]interface{}{
+			deletedAtColumn: nil,
+			"status":        entity.GetStatusInt("active"),
+		}
+		err, changes := r.columnChanges(ctx, externalId, values)
+		if err != nil {
+			return err
+		}
+		return appliedOnRetry(ctx, r.recorded(ctx, AuditRestore, base, externalId, changes, func(ctx context.Context) error {
+			tx := r.conn(ctx).Table(string(base.GetTable())).Scopes(r.tenantScope(ctx)).
+				Where("external_id = ? AND deleted_at IS NOT NULL", externalId).
+				Updates(values)
+			return rowAffected(tx)
+		}))
+	})
+}
+
+func (r *GORMRepository) HardDelete(ctx context.Context, externalId string) error {
+	return r.retryPolicy.Do(ctx, "hard_delete", func(ctx context.Context) error {
+		base := r.creator()
+		if r.recording() {
+			// the deleted entity is recorded, its row is gone once the delete ran
+			var err error
+			if err, base = r.GetByExternalId(WithDeleted(WithPrimary(ctx)), externalId); err != nil {
+				return appliedOnRetry(ctx, err)
+			}
+		}
+		return appliedOnRetry(ctx, r.recorded(ctx, AuditHardDelete, base, externalId, nil, func(ctx context.Context) error {
+			tx := r.conn(ctx).Table(string(base.GetTable())).Scopes(r.tenantScope(ctx)).Where("external_id = ?", externalId).Delete(base)
+			return rowAffected(tx)
+		}))
+	})
+}
+
+// columnChanges returns the audited changes of setting values on the stored entity, nil when auditing is off.
+func (r *GORMRepository) columnChanges(ctx context.Context, externalId string, values map[string]interface{}) (error, []FieldChange) {
+	if r.audit == nil {
+		return nil, nil
+	}
+	err, current := r.GetByExternalId(WithDeleted(WithPrimary(ctx)), externalId)
+	if err != nil {
+		return err, nil
+	}
+	err, before := r.snapshot(ctx, current)
 	if err != nil {
 		return err, nil
 	}
-	entity.Merge(updatedBase)
-	if err := r.db.WithContext(ctx).Table(string(entity.GetTable())).Model(entity).Updates(entity).Error; err != nil {
+	for column, value := range values {
+		if err := r.setColumn(ctx, current, column, value); err != nil {
+			return err, nil
+		}
+	}
+	err, after := r.snapshot(ctx, current)
+	if err != nil {
 		return err, nil
 	}
-	return nil, entity
+	return nil, r.diffSnapshots(current, before, after)
+}
+
+func rowAffected(tx *gorm.DB) error {
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// appliedOnRetry treats a retried delete or restore finding no row as done, the transient error failing the
+// previous attempt may have been raised after its commit. Nothing is recorded again for it.
+func appliedOnRetry(ctx context.Context, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) && isRetry(ctx) {
+		return nil
+	}
+	return err
+}
+
+func notDeleted(ctx context.Context) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if includeDeleted(ctx) {
+			return tx
+		}
+		return tx.Where(clause.Eq{Column: clause.Column{Name: deletedAtColumn}, Value: nil})
+	}
+}
+
+// filtered returns a read query on the entity's table restricted to conditions.
+func (r *GORMRepository) filtered(ctx context.Context, base entity.Base, conditions []Condition) *gorm.DB {
+	tx := r.reader(ctx).Table(string(base.GetTable())).Scopes(notDeleted(ctx), r.tenantScope(ctx))
+	if len(conditions) > 0 {
+		tx = tx.Clauses(clause.Where{Exprs: conditionsToClauses(conditions)})
+	}
+	return tx
 }
 
 func (r *GORMRepository) Search(ctx context.Context, params map[string]string) (error, []entity.Base) {
-	return errors.New("not implemented"), nil
+	err, query := QueryFromParams(params)
+	if err != nil {
+		return err, nil
+	}
+	err, result := r.SearchQuery(ctx, query)
+	if err != nil {
+		return err, nil
+	}
+	return nil, result.Items
+}
+
+func (r *GORMRepository) SearchQuery(ctx context.Context, query *Query) (error, *SearchResult) {
+	return retryRead(ctx, r.retryPolicy, "search", func(ctx context.Context) (error, *SearchResult) {
+		if err := query.Validate(); err != nil {
+			return err, nil
+		}
+		base := r.creator()
+		if err := r.checkColumns(base, query.Fields()); err != nil {
+			return err, nil
+		}
+		var total int64
+		if err := r.filtered(ctx, base, query.Conditions).Count(&total).Error; err != nil {
+			return err, nil
+		}
+		tx := r.filtered(ctx, base, query.Conditions)
+		for _, sort := range query.Sort {
+			tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: sort.Field}, Desc: sort.Desc})
+		}
+		rows, err := tx.Limit(query.PageLimit()).Offset(query.Offset).Rows()
+		if err != nil {
+			return err, nil
+		}
+		err, items := r.populateRows(rows)
+		if err != nil {
+			return err, nil
+		}
+		return nil, &SearchResult{Items: items, Total: total}
+	})
+}
+
+// SearchAfter returns the page following the continuation token, an empty token starts from the first row.
+// Rows are ordered by the query's sort fields with id as the tiebreaker, offset is not supported.
+func (r *GORMRepository) SearchAfter(ctx context.Context, query *Query, token string) (error, *CursorPage) {
+	return retryRead(ctx, r.retryPolicy, "search_after", func(ctx context.Context) (error, *CursorPage) {
+		if err := query.Validate(); err != nil {
+			return err, nil
+		}
+		if query.Offset > 0 {
+			return errors.New("offset cannot be combined with a continuation token"), nil
+		}
+		base := r.creator()
+		if err := r.checkColumns(base, query.Fields()); err != nil {
+			return err, nil
+		}
+		keys := keysetSort(query)
+		err, cursor := decodeCursor(r.codec, token, keys)
+		if err != nil {
+			return err, nil
+		}
+		conditions := query.Conditions
+		if cursor != nil {
+			err, sch := r.parseSchema(base)
+			if err != nil {
+				return err, nil
+			}
+			if err := parseCursorTimes(sch, keys, cursor); err != nil {
+				return err, nil
+			}
+			conditions = append(append([]Condition{}, conditions...), keysetCondition(keys, cursor))
+		}
+		tx := r.filtered(ctx, base, conditions)
+		for _, key := range keys {
+			tx = tx.Order(clause.OrderByColumn{Column: clause.Column{Name: key.Field}, Desc: key.Desc})
+		}
+		limit := query.PageLimit()
+		// fetch one extra row to find out whether there is a next page
+		rows, err := tx.Limit(limit + 1).Rows()
+		if err != nil {
+			return err, nil
+		}
+		err, items := r.populateRows(rows)
+		if err != nil {
+			return err, nil
+		}
+		page := &CursorPage{Items: items}
+		if len(items) > limit {
+			page.Items = items[:limit]
+			last := page.Items[limit-1]
+			err, values := r.columnValues(ctx, last, keys[:len(keys)-1])
+			if err != nil {
+				return err, nil
+			}
+			err, page.NextToken = encodeCursor(r.codec, keys, values, last.GetId())
+			if err != nil {
+				return err, nil
+			}
+		}
+		return nil, page
+	})
+}
+
+func (r *GORMRepository) parseSchema(base entity.Base) (error, *schema.Schema) {
+	stmt := &gorm.Statement{DB: r.db}
+	if err := stmt.Parse(base); err != nil {
+		return err, nil
+	}
+	return nil, stmt.Schema
+}
+
+func (r *GORMRepository) columnValues(ctx context.Context, base entity.Base, keys []SortField) (error, []interface{}) {
+	err, sch := r.parseSchema(base)
+	if err != nil {
+		return err, nil
+	}
+	var values []interface{}
+	for _, key := range keys {
+		field := sch.LookUpField(key.Field)
+		if field == nil {
+			return fmt.Errorf("unknown column %v", key.Field), nil
+		}
+		value, _ := field.ValueOf(ctx, reflect.Indirect(reflect.ValueOf(base)))
+		values = append(values, value)
+	}
+	return nil, values
+}
+
+func (r *GORMRepository) searchableColumns(base entity.Base) (error, map[string]bool) {
+	if len(r.columns) > 0 {
+		return nil, r.columns
+	}
+	err, sch := r.parseSchema(base)
+	if err != nil {
+		return err, nil
+	}
+	columns := make(map[string]bool)
+	for _, column := range sch.DBNames {
+		columns[column] = true
+	}
+	return nil, columns
+}
+
+func (r *GORMRepository) checkColumns(base entity.Base, fields []string) error {
+	err, columns := r.searchableColumns(base)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if !columns[field] {
+			return fmt.Errorf("field %v is not searchable", field)
+		}
+	}
+	return nil
+}
+
+func conditionsToClauses(conditions []Condition) []clause.Expression {
+	var exprs []clause.Expression
+	for _, condition := range conditions {
+		exprs = append(exprs, conditionToClause(condition))
+	}
+	return exprs
+}
+
+func conditionToClause(condition Condition) clause.Expression {
+	column := clause.Column{Name: condition.Field}
+	switch condition.Operator {
+	case OpAnd:
+		return clause.And(conditionsToClauses(condition.Conditions)...)
+	case OpOr:
+		// gorm joins a single OR condition to the conditions before it with OR rather than AND
+		if len(condition.Conditions) == 1 {
+			return conditionToClause(condition.Conditions[0])
+		}
+		return clause.Or(conditionsToClauses(condition.Conditions)...)
+	case OpNe:
+		return clause.Neq{Column: column, Value: condition.Value}
+	case OpIn:
+		return clause.IN{Column: column, Values: condition.Values}
+	case OpNotIn:
+		return clause.Not(clause.IN{Column: column, Values: condition.Values})
+	case OpGt:
+		return clause.Gt{Column: column, Value: condition.Value}
+	case OpGte:
+		return clause.Gte{Column: column, Value: condition.Value}
+	case OpLt:
+		return clause.Lt{Column: column, Value: condition.Value}
+	case OpLte:
+		return clause.Lte{Column: column, Value: condition.Value}
+	case OpBetween:
+		return clause.Expr{SQL: "? BETWEEN ? AND ?", Vars: []interface{}{column, condition.Values[0], condition.Values[1]}}
+	case OpLike:
+		return clause.Like{Column: column, Value: condition.Value}
+	case OpIsNull:
+		return clause.Eq{Column: column, Value: nil}
+	case OpNotNull:
+		return clause.Neq{Column: column, Value: nil}
+	default:
+		return clause.Eq{Column: column, Value: condition.Value}
+	}
 }
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/byteintellect/go_commons/entity"
+	"gorm.io/gorm"
+	"testing"
+)
+
+// gadget is a widget updated with optimistic locking.
+type gadget struct {
+	entity.VersionedDomain
+	Name     string `json:"name"`
+	Quantity int    `json:"quantity"`
+}
+
+func (g *gadget) GetTable() entity.DomainName {
+	return "gadgets"
+}
+
+func (g *gadget) ToDto() interface{} {
+	return g
+}
+
+func (g *gadget) FromDto(dto interface{}) (entity.Base, error) {
+	return dto.(*gadget), nil
+}
+
+func (g *gadget) Merge(other interface{}) {
+	o := other.(*gadget)
+	if o.Name != "" {
+		g.Name = o.Name
+	}
+	if o.Quantity != 0 {
+		g.Quantity = o.Quantity
+	}
+}
+
+func (g *gadget) FromSqlRow(rows *sql.Rows) (entity.Base, error) {
+	return g, errors.New("not supported")
+}
+
+func (g *gadget) ToJson() (string, error) {
+	return "", nil
+}
+
+func (g *gadget) String() string {
+	return g.Name
+}
+
+func newVersionedTestRepo(t *testing.T, opts ...GORMRepositoryOption) (*gorm.DB, *GORMRepository) {
+	t.Helper()
+	db := newTestDb(t)
+	if err := db.Table("gadgets").AutoMigrate(&gadget{}); err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]GORMRepositoryOption{WithDb(db), WithCreator(func() entity.Base {
+		return &gadget{}
+	})}, opts...)
+	return db, NewGORMRepository(opts...)
+}
+
+// storedGadget reads the gadget bypassing the repository.
+func storedGadget(t *testing.T, db *gorm.DB, externalId string) *gadget {
+	t.Helper()
+	stored := &gadget{}
+	if err := db.Table("gadgets").Where("external_id = ?", externalId).First(stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+// onFirstUpdate runs fn once, before the first update statement is executed.
+func onFirstUpdate(t *testing.T, db *gorm.DB, fn func(tx *gorm.DB)) {
+	t.Helper()
+	done := false
+	err := db.Callback().Update().Before("gorm:update").Register("test:first_update", func(tx *gorm.DB) {
+		if !done {
+			done = true
+			fn(tx)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionedUpdateIncrementsVersion(t *testing.T) {
+	db, repo := newVersionedTestRepo(t, WithOptimisticLocking(0))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &gadget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version := uint64(1); version <= 2; version++ {
+		err, updated := repo.Update(ctx, created.GetExternalId(), &gadget{Quantity: int(version)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := updated.(*gadget).Version; got != version {
+			t.Fatalf("returned version %v, expected %v", got, version)
+		}
+		if stored := storedGadget(t, db, created.GetExternalId()); stored.Version != version || stored.Quantity != int(version) || stored.Name != "bolt" {
+			t.Fatalf("stored %+v, expected version %v", stored, version)
+		}
+	}
+	// the caller pins the version it read
+	pinned := &gadget{Name: "nut"}
+	pinned.Version = 2
+	if err, updated := repo.Update(ctx, created.GetExternalId(), pinned); err != nil || updated.(*gadget).Version != 3 {
+		t.Fatalf("update of the current version failed: %v", err)
+	}
+}
+
+func TestVersionedUpdateOfStaleVersionConflicts(t *testing.T) {
+	db, repo := newVersionedTestRepo(t, WithOptimisticLocking(3))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &gadget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, _ := repo.Update(ctx, created.GetExternalId(), &gadget{Quantity: 1}); err != nil {
+		t.Fatal(err)
+	}
+	// read at version 1, updated since
+	stale := &gadget{Name: "nut"}
+	stale.Version = 1
+	if err, _ := repo.Update(ctx, created.GetExternalId(), &gadget{Quantity: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	err, _ = repo.Update(ctx, created.GetExternalId(), stale)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("expected a version conflict, got %v", err)
+	}
+	if status := ErrVersionConflict.Status; status != 409 {
+		t.Fatalf("conflicts are reported with status %v, expected 409", status)
+	}
+	if stored := storedGadget(t, db, created.GetExternalId()); stored.Version != 2 || stored.Name != "bolt" {
+		t.Fatalf("stale update was applied: %+v", stored)
+	}
+}
+
+func TestVersionedUpdateRetriesConcurrentUpdate(t *testing.T) {
+	db, repo := newVersionedTestRepo(t, WithOptimisticLocking(1))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &gadget{Name: "bolt", Quantity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another writer updates the row between the read and the write of the first attempt
+	onFirstUpdate(t, db, func(*gorm.DB) {
+		err := db.Exec("UPDATE gadgets SET name = ?, version = version + 1 WHERE external_id = ?", "screw", created.GetExternalId()).Error
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	err, updated := repo.Update(ctx, created.GetExternalId(), &gadget{Quantity: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the update is merged into the row written by the other writer
+	stored := storedGadget(t, db, created.GetExternalId())
+	if stored.Version != 2 || stored.Name != "screw" || stored.Quantity != 5 || updated.(*gadget).Version != 2 {
+		t.Fatalf("stored %+v, expected the concurrent update to be kept", stored)
+	}
+}
+
+func TestVersionedUpdateConflictsWithConcurrentUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		pinned     bool
+	}{
+		{name: "without retries", maxRetries: 0},
+		{name: "pinned version", maxRetries: 3, pinned: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updates := 0
+			db, repo := newVersionedTestRepo(t, WithOptimisticLocking(test.maxRetries), WithRetryPolicy(&RetryPolicy{MaxAttempts: 3}))
+			ctx := context.Background()
+			err, created := repo.Create(ctx, &gadget{Name: "bolt"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err, _ := repo.Update(ctx, created.GetExternalId(), &gadget{Quantity: 1}); err != nil {
+				t.Fatal(err)
+			}
+			onFirstUpdate(t, db, func(*gorm.DB) {
+				if err := db.Exec("UPDATE gadgets SET version = version + 1 WHERE external_id = ?", created.GetExternalId()).Error; err != nil {
+					t.Error(err)
+				}
+			})
+			err = db.Callback().Update().After("gorm:update").Register("test:count_updates", func(*gorm.DB) {
+				updates++
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			update := &gadget{Name: "nut"}
+			if test.pinned {
+				update.Version = 1
+			}
+			err, _ = repo.Update(ctx, created.GetExternalId(), update)
+			if !errors.Is(err, ErrVersionConflict) {
+				t.Fatalf("expected a version conflict, got %v", err)
+			}
+			// a conflict is not transient, the retry policy does not run the update again
+			if updates != 1 {
+				t.Fatalf("ran %v updates, expected 1", updates)
+			}
+			if stored := storedGadget(t, db, created.GetExternalId()); stored.Name != "bolt" || stored.Version != 2 {
+				t.Fatalf("conflicting update was applied: %+v", stored)
+			}
+		})
+	}
+}
+
+func TestVersionedUpdateRetriedOnTransientError(t *testing.T) {
+	db, repo := newVersionedTestRepo(t, WithOptimisticLocking(0), WithRetryPolicy(&RetryPolicy{MaxAttempts: 2}))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &gadget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	onFirstUpdate(t, db, func(tx *gorm.DB) {
+		tx.AddError(errors.New("database is locked"))
+	})
+
+	err, updated := repo.Update(ctx, created.GetExternalId(), &gadget{Name: "nut"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the failed attempt did not write, the retry reads and increments the version once
+	if stored := storedGadget(t, db, created.GetExternalId()); stored.Version != 1 || stored.Name != "nut" || updated.(*gadget).Version != 1 {
+		t.Fatalf("stored %+v, expected a single increment", stored)
+	}
+}
@@ -0,0 +1,289 @@
+package db
+
+import (
+	"context"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/byteintellect/go_commons/util"
+	"gorm.io/gorm"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const loadersCtxKey contextKey = "loaders"
+
+const (
+	defaultLoaderWait     = 2 * time.Millisecond
+	defaultLoaderMaxBatch = 100
+)
+
+type LoaderOption func(l *Loader)
+
+// WithLoaderWait sets how long a batch collects keys before it is fetched.
+func WithLoaderWait(wait time.Duration) LoaderOption {
+	return func(l *Loader) {
+		l.wait = wait
+	}
+}
+
+// WithLoaderMaxBatch caps the number of keys fetched by one MultiGetByExternalId, a full batch is fetched right away.
+// Sizes below one are ignored.
+func WithLoaderMaxBatch(size int) LoaderOption {
+	return func(l *Loader) {
+		if size > 0 {
+			l.maxBatch = size
+		}
+	}
+}
+
+type loadResult struct {
+	done chan struct{}
+	base entity.Base
+	err  error
+}
+
+type loadBatch struct {
+	ctx     context.Context
+	keys    []string
+	results map[string]*loadResult
+	timer   *time.Timer
+}
+
+// loadScope holds what a repository read depends on besides the external id, Loads are only batched and
+// cached together within the same scope.
+type loadScope struct {
+	tenant     string
+	allTenants bool
+	tx         *txState
+	deleted    bool
+	primary    bool
+}
+
+func scopeOf(ctx context.Context) loadScope {
+	tx, _ := ctx.Value(txCtxKey).(*txState)
+	return loadScope{
+		tenant:     util.Tenant(ctx),
+		allTenants: util.AllTenants(ctx),
+		tx:         tx,
+		deleted:    includeDeleted(ctx),
+		primary:    forcePrimary(ctx),
+	}
+}
+
+type scopeState struct {
+	cache map[string]*loadResult
+	batch *loadBatch
+}
+
+// Loader coalesces GetByExternalId calls made within a short window into a single MultiGetByExternalId,
+// and remembers the loaded entities for its own lifetime. Calls are batched and cached per tenant,
+// transaction and read flags of their context. A batch is fetched with the values of the context of its
+// first Load but is not canceled with it, so that one caller giving up does not fail the others. Every Load
+// returns its own shallow copy of the cached entity.
+type Loader struct {
+	repo     BaseRepository
+	wait     time.Duration
+	maxBatch int
+	mu       sync.Mutex
+	scopes   map[loadScope]*scopeState
+}
+
+func NewLoader(repo BaseRepository, opts ...LoaderOption) *Loader {
+	l := &Loader{
+		repo:     repo,
+		wait:     defaultLoaderWait,
+		maxBatch: defaultLoaderMaxBatch,
+		scopes:   map[loadScope]*scopeState{},
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Load returns the entity with the given external id, gorm.ErrRecordNotFound if the repository did not return it.
+func (l *Loader) Load(ctx context.Context, externalId string) (error, entity.Base) {
+	result := l.enqueue(ctx, externalId)
+	select {
+	case <-result.done:
+		if result.err != nil {
+			return result.err, nil
+		}
+		return nil, copyEntity(result.base)
+	case <-ctx.Done():
+		return ctx.Err(), nil
+	}
+}
+
+// LoadMany returns the entities with the given external ids in the same order, failing on the first missing one.
+func (l *Loader) LoadMany(ctx context.Context, externalIds []string) (error, []entity.Base) {
+	results := make([]*loadResult, len(externalIds))
+	for i, externalId := range externalIds {
+		results[i] = l.enqueue(ctx, externalId)
+	}
+	bases := make([]entity.Base, len(externalIds))
+	for i, result := range results {
+		select {
+		case <-result.done:
+		case <-ctx.Done():
+			return ctx.Err(), nil
+		}
+		if result.err != nil {
+			return result.err, nil
+		}
+		bases[i] = copyEntity(result.base)
+	}
+	return nil, bases
+}
+
+// Clear forgets the cached entity in every scope, the next Load fetches it again.
+func (l *Loader) Clear(externalId string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, state := range l.scopes {
+		delete(state.cache, externalId)
+	}
+}
+
+func (l *Loader) enqueue(ctx context.Context, externalId string) *loadResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	scope := scopeOf(ctx)
+	state, ok := l.scopes[scope]
+	if !ok {
+		state = &scopeState{cache: map[string]*loadResult{}}
+		l.scopes[scope] = state
+	}
+	if result, ok := state.cache[externalId]; ok {
+		return result
+	}
+	result := &loadResult{done: make(chan struct{})}
+	state.cache[externalId] = result
+	if state.batch == nil {
+		batch := &loadBatch{ctx: detachedContext{ctx}, results: map[string]*loadResult{}}
+		batch.timer = time.AfterFunc(l.wait, func() {
+			l.dispatch(state, batch)
+		})
+		state.batch = batch
+	}
+	state.batch.keys = append(state.batch.keys, externalId)
+	state.batch.results[externalId] = result
+	if len(state.batch.keys) >= l.maxBatch {
+		batch := state.batch
+		batch.timer.Stop()
+		state.batch = nil
+		go l.fetch(state, batch)
+	}
+	return result
+}
+
+func (l *Loader) dispatch(state *scopeState, batch *loadBatch) {
+	l.mu.Lock()
+	if state.batch != batch {
+		// already dispatched on reaching the max batch size
+		l.mu.Unlock()
+		return
+	}
+	state.batch = nil
+	l.mu.Unlock()
+	l.fetch(state, batch)
+}
+
+func (l *Loader) fetch(state *scopeState, batch *loadBatch) {
+	err, bases := l.repo.MultiGetByExternalId(batch.ctx, batch.keys)
+	found := map[string]entity.Base{}
+	for _, base := range bases {
+		found[base.GetExternalId()] = base
+	}
+	l.mu.Lock()
+	for externalId, result := range batch.results {
+		switch base, ok := found[externalId]; {
+		case err != nil:
+			result.err = err
+		case !ok:
+			result.err = gorm.ErrRecordNotFound
+		default:
+			result.base = base
+		}
+		if result.err != nil && state.cache[externalId] == result {
+			// failures and missing entities are not cached so that a later Load, after a Create, finds them
+			delete(state.cache, externalId)
+		}
+	}
+	l.mu.Unlock()
+	for _, result := range batch.results {
+		close(result.done)
+	}
+}
+
+// copyEntity returns a shallow copy of base, so that callers sharing a Loader do not see each other's changes.
+func copyEntity(base entity.Base) entity.Base {
+	value := reflect.ValueOf(base)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return base
+	}
+	copied := reflect.New(value.Elem().Type())
+	copied.Elem().Set(value.Elem())
+	if copy, ok := copied.Interface().(entity.Base); ok {
+		return copy
+	}
+	return base
+}
+
+// detachedContext keeps the values of its parent but is never canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+type loaderRegistry struct {
+	mu      sync.Mutex
+	opts    []LoaderOption
+	loaders map[BaseRepository]*Loader
+}
+
+// WithLoaders returns a context holding one Loader per repository, typically installed per request so that
+// entities are cached for the lifetime of the request only, see LoaderFor.
+func WithLoaders(ctx context.Context, opts ...LoaderOption) context.Context {
+	return context.WithValue(ctx, loadersCtxKey, &loaderRegistry{opts: opts, loaders: map[BaseRepository]*Loader{}})
+}
+
+// LoaderFor returns the Loader of the repository in the context, nil if the context has no loaders.
+func LoaderFor(ctx context.Context, repo BaseRepository) *Loader {
+	registry, ok := ctx.Value(loadersCtxKey).(*loaderRegistry)
+	if !ok {
+		return nil
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	loader, ok := registry.loaders[repo]
+	if !ok {
+		loader = NewLoader(repo, registry.opts...)
+		registry.loaders[repo] = loader
+	}
+	return loader
+}
+
+// Load gets the entity through the Loader in the context, or straight from the repository when there is none.
+// Reads of soft deleted entities and reads from the primary bypass the loader.
+func Load(ctx context.Context, repo BaseRepository, externalId string) (error, entity.Base) {
+	if loader := LoaderFor(ctx, repo); loader != nil && !includeDeleted(ctx) && !forcePrimary(ctx) {
+		return loader.Load(ctx, externalId)
+	}
+	return repo.GetByExternalId(ctx, externalId)
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestLoaderDoesNotCacheMissingEntities(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	loader := NewLoader(repo)
+	bolt := &widget{Name: "bolt"}
+	bolt.ExternalId = "bolt"
+
+	if err, _ := loader.Load(ctx, bolt.ExternalId); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err, _ := repo.Create(ctx, bolt); err != nil {
+		t.Fatal(err)
+	}
+	if err, base := loader.Load(ctx, bolt.ExternalId); err != nil || base.GetExternalId() != bolt.ExternalId {
+		t.Fatalf("created entity not loaded: %v", err)
+	}
+}
+
+func TestLoaderBatchOutlivesCanceledCaller(t *testing.T) {
+	repo := newTestRepo(t)
+	err, created := repo.Create(context.Background(), &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := NewLoader(repo, WithLoaderWait(20*time.Millisecond))
+
+	first, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		err, _ := loader.Load(first, created.GetExternalId())
+		errs <- err
+	}()
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the canceled caller to give up, got %v", err)
+	}
+	// joins the batch started by the canceled caller
+	if err, _ := loader.Load(context.Background(), created.GetExternalId()); err != nil {
+		t.Fatalf("batch failed with the first caller's cancellation: %v", err)
+	}
+}
+
+func TestLoaderBatchesPerTenant(t *testing.T) {
+	repo := newTestRepo(t, WithTenantColumn("tenant_id"))
+	err, created := repo.Create(tenantCtx("acme"), &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := NewLoader(repo, WithLoaderWait(20*time.Millisecond))
+
+	errs := make(chan error, 1)
+	go func() {
+		err, _ := loader.Load(tenantCtx("globex"), created.GetExternalId())
+		errs <- err
+	}()
+	if err, base := loader.Load(tenantCtx("acme"), created.GetExternalId()); err != nil || base.GetExternalId() != created.GetExternalId() {
+		t.Fatalf("owner could not load its entity: %v", err)
+	}
+	if err := <-errs; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected another tenant not to find the entity, got %v", err)
+	}
+	// cached for the owner only
+	if err, _ := loader.Load(tenantCtx("globex"), created.GetExternalId()); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected another tenant not to find the cached entity, got %v", err)
+	}
+}
+
+func TestLoaderBatchesPerTransaction(t *testing.T) {
+	repo := newTestRepo(t)
+	loader := NewLoader(repo)
+	bolt := &widget{Name: "bolt"}
+	err := RunInTx(context.Background(), repo.db, func(ctx context.Context) error {
+		if err, _ := repo.Create(ctx, bolt); err != nil {
+			return err
+		}
+		if err, _ := loader.Load(ctx, bolt.ExternalId); err != nil {
+			t.Fatalf("transaction could not load its own entity: %v", err)
+		}
+		return errTxTest
+	})
+	if !errors.Is(err, errTxTest) {
+		t.Fatal(err)
+	}
+	if err, _ := loader.Load(context.Background(), bolt.ExternalId); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("loaded an entity of a rolled back transaction: %v", err)
+	}
+}
+
+func TestLoaderReturnsCopies(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := NewLoader(repo)
+	err, first := loader.Load(ctx, created.GetExternalId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.(*widget).Name = "nut"
+	err, bases := loader.LoadMany(ctx, []string{created.GetExternalId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := bases[0].(*widget).Name; name != "bolt" {
+		t.Fatalf("loaded %v, a change made by another caller", name)
+	}
+}
+
+func TestLoaderIgnoresInvalidMaxBatch(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+	var ids []string
+	for _, name := range []string{"bolt", "nut", "washer"} {
+		err, created := repo.Create(ctx, &widget{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, created.GetExternalId())
+	}
+	queries := 0
+	err := repo.db.Callback().Row().After("gorm:row").Register("test:count_queries", func(*gorm.DB) {
+		queries++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loader := NewLoader(repo, WithLoaderMaxBatch(0), WithLoaderMaxBatch(-1))
+	if err, bases := loader.LoadMany(ctx, ids); err != nil || len(bases) != len(ids) {
+		t.Fatalf("loaded %v widgets: %v", len(bases), err)
+	}
+	if queries != 1 {
+		t.Fatalf("ran %v queries, expected the widgets to be fetched in one batch", queries)
+	}
+}
@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const usage = "usage: migrate status | up | down [steps] | validate"
+
+// Command runs a migration subcommand, meant to be called from a service's own main with os.Args[1:]:
+// status lists every migration, up applies pending ones, down reverts the last steps (default 1)
+// and validate checks applied checksums.
+func Command(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = fmt.Sprintf("applied %v", status.AppliedAt.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Fprintf(out, "%v_%v\t%v\n", status.Version, status.Name, state)
+		}
+		return nil
+	case "up":
+		applied, err := m.Up(ctx)
+		fmt.Fprintf(out, "applied %v migrations\n", applied)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			parsed, err := strconv.Atoi(args[1])
+			if err != nil || parsed < 1 {
+				return fmt.Errorf("invalid steps %v", args[1])
+			}
+			steps = parsed
+		}
+		reverted, err := m.Down(ctx, steps)
+		fmt.Fprintf(out, "reverted %v migrations\n", reverted)
+		return err
+	case "validate":
+		if err := m.Validate(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "migrations are valid")
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
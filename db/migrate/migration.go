@@ -0,0 +1,188 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a versioned pair of up and down SQL scripts, e.g. 0001_create_users.up.sql and 0001_create_users.down.sql.
+type Migration struct {
+	Version  uint64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Load reads the migrations in dir of fsys, typically an embed.FS, ordered by version.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := fileNamePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+		version, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		} else if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migration %v has conflicting names %v and %v", version, migration.Name, matches[2])
+		}
+		if matches[3] == "up" {
+			migration.Up = string(content)
+			migration.Checksum = checksum(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %v_%v is missing its up script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	statementBegin = "-- +statement begin"
+	statementEnd   = "-- +statement end"
+)
+
+// statements splits a script on the semicolons ending its statements, so drivers without multi statement
+// support can run it. Semicolons in quoted strings, Postgres dollar quoted bodies and comments do not end a
+// statement. Statements with semicolons of their own, such as MySQL triggers or procedures with BEGIN ... END
+// blocks, are kept whole between -- +statement begin and -- +statement end lines.
+func statements(script string) []string {
+	var result []string
+	var pending strings.Builder
+	var block *strings.Builder
+	for _, line := range strings.SplitAfter(script, "\n") {
+		switch marker := strings.ToLower(strings.TrimSpace(line)); {
+		case marker == statementBegin:
+			result = append(result, splitStatements(pending.String())...)
+			pending.Reset()
+			block = &strings.Builder{}
+		case marker == statementEnd && block != nil:
+			if statement := strings.TrimSpace(block.String()); statement != "" {
+				result = append(result, statement)
+			}
+			block = nil
+		case block != nil:
+			block.WriteString(line)
+		default:
+			pending.WriteString(line)
+		}
+	}
+	if block != nil {
+		pending.WriteString(block.String())
+	}
+	return append(result, splitStatements(pending.String())...)
+}
+
+// splitStatements splits script on semicolons outside of quotes, dollar quotes and comments, dropping
+// statements made of comments only.
+func splitStatements(script string) []string {
+	var result []string
+	start := 0
+	hasCode := false
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			i = skipUntil(script, i+2, "\n") - 1
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			i = skipUntil(script, i+2, "*/") - 1
+		case c == '\'' || c == '"' || c == '`':
+			hasCode = true
+			i = skipQuoted(script, i)
+		case c == '$':
+			hasCode = true
+			if tag := dollarTag(script[i:]); tag != "" {
+				i = skipUntil(script, i+len(tag), tag) - 1
+			}
+		case c == ';':
+			if hasCode {
+				result = append(result, strings.TrimSpace(script[start:i+1]))
+			}
+			start, hasCode = i+1, false
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			hasCode = true
+		}
+	}
+	if hasCode {
+		result = append(result, strings.TrimSpace(script[start:]))
+	}
+	return result
+}
+
+// skipUntil returns the index just past the next end in script from i, or the length of script.
+func skipUntil(script string, i int, end string) int {
+	if i > len(script) {
+		return len(script)
+	}
+	if j := strings.Index(script[i:], end); j >= 0 {
+		return i + j + len(end)
+	}
+	return len(script)
+}
+
+// skipQuoted returns the index of the quote closing the string starting at i, a doubled quote or a
+// backslash escapes it.
+func skipQuoted(script string, i int) int {
+	quote := script[i]
+	for j := i + 1; j < len(script); j++ {
+		switch script[j] {
+		case '\\':
+			j++
+		case quote:
+			if j+1 < len(script) && script[j+1] == quote {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(script)
+}
+
+var dollarTagPattern = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// dollarTag returns the opening tag of a Postgres dollar quoted string, such as $$ or $body$, at the start of s.
+func dollarTag(s string) string {
+	return dollarTagPattern.FindString(s)
+}
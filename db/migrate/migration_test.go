@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected []string
+	}{
+		{
+			name:     "one statement per line",
+			script:   "CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			expected: []string{"CREATE TABLE a (id INT);", "CREATE TABLE b (id INT);"},
+		},
+		{
+			name:     "statement over several lines without a final semicolon",
+			script:   "CREATE TABLE a (\n  id INT\n);\nINSERT INTO a VALUES (1)",
+			expected: []string{"CREATE TABLE a (\n  id INT\n);", "INSERT INTO a VALUES (1)"},
+		},
+		{
+			name:     "semicolons in strings and identifiers",
+			script:   "INSERT INTO a VALUES ('x;\ny', 'it''s;');\nSELECT \"a;b\", `c;d` FROM a;",
+			expected: []string{"INSERT INTO a VALUES ('x;\ny', 'it''s;');", "SELECT \"a;b\", `c;d` FROM a;"},
+		},
+		{
+			name:     "comments",
+			script:   "-- drop it; really\nDROP TABLE a; /* done; */\n-- trailing comment;\n",
+			expected: []string{"-- drop it; really\nDROP TABLE a;"},
+		},
+		{
+			name: "dollar quoted function body",
+			script: "CREATE FUNCTION touch() RETURNS trigger AS $$\nBEGIN\n  NEW.updated_at = now();\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;\n" +
+				"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;",
+			expected: []string{
+				"CREATE FUNCTION touch() RETURNS trigger AS $$\nBEGIN\n  NEW.updated_at = now();\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql;",
+				"CREATE FUNCTION f() RETURNS int AS $body$ SELECT 1; $body$ LANGUAGE sql;",
+			},
+		},
+		{
+			name: "statement block",
+			script: "CREATE TABLE a (id INT);\n-- +statement begin\nCREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW\nBEGIN\n  SET NEW.id = NEW.id + 1;\nEND;\n-- +statement end\n" +
+				"DROP TABLE b;",
+			expected: []string{
+				"CREATE TABLE a (id INT);",
+				"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW\nBEGIN\n  SET NEW.id = NEW.id + 1;\nEND;",
+				"DROP TABLE b;",
+			},
+		},
+		{
+			name:     "empty script",
+			script:   "\n  \n",
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := statements(test.script); !reflect.DeepEqual(got, test.expected) {
+				t.Fatalf("got %q\nexpected %q", got, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"hash/fnv"
+	"io/fs"
+	"time"
+)
+
+const (
+	defaultHistoryTable = "schema_migrations"
+	defaultLockTimeout  = time.Minute
+)
+
+// AppliedMigration is a row of the schema history table.
+type AppliedMigration struct {
+	Version     uint64    `gorm:"primaryKey;autoIncrement:false"`
+	Name        string    `gorm:"type:varchar(255)"`
+	Checksum    string    `gorm:"type:varchar(64)"`
+	AppliedAt   time.Time `gorm:"not null"`
+	ExecutionMs int64
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db          *gorm.DB
+	migrations  []Migration
+	table       string
+	lockTimeout time.Duration
+}
+
+type Option func(m *Migrator)
+
+func WithHistoryTable(table string) Option {
+	return func(m *Migrator) {
+		m.table = table
+	}
+}
+
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTimeout = timeout
+	}
+}
+
+func New(db *gorm.DB, migrations []Migration, opts ...Option) *Migrator {
+	m := &Migrator{
+		db:          db,
+		migrations:  migrations,
+		table:       defaultHistoryTable,
+		lockTimeout: defaultLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// NewFromFS creates a Migrator for the migrations in dir of fsys, see Load.
+func NewFromFS(db *gorm.DB, fsys fs.FS, dir string, opts ...Option) (*Migrator, error) {
+	migrations, err := Load(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return New(db, migrations, opts...), nil
+}
+
+// Up applies every pending migration in version order and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		history, err := m.history(conn)
+		if err != nil {
+			return err
+		}
+		if err := m.validate(history); err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, done := history[migration.Version]; done {
+				continue
+			}
+			if err := m.apply(conn, migration); err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns how many were reverted.
+// Like Up it refuses to run when applied migrations no longer match their scripts.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		history, err := m.history(conn)
+		if err != nil {
+			return err
+		}
+		if err := m.validate(history); err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			migration := m.migrations[i]
+			if _, done := history[migration.Version]; !done {
+				continue
+			}
+			if err := m.revert(conn, migration); err != nil {
+				return err
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	history, err := m.history(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if row, done := history[migration.Version]; done {
+			appliedAt := row.AppliedAt
+			status.Applied, status.AppliedAt = true, &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Validate checks that applied migrations still match their scripts and that none are missing or out of order.
+func (m *Migrator) Validate(ctx context.Context) error {
+	history, err := m.history(m.db.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	return m.validate(history)
+}
+
+func (m *Migrator) validate(history map[uint64]AppliedMigration) error {
+	known := make(map[uint64]bool)
+	var latest uint64
+	for _, row := range history {
+		if row.Version > latest {
+			latest = row.Version
+		}
+	}
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		row, done := history[migration.Version]
+		if !done {
+			if migration.Version < latest {
+				return fmt.Errorf("migration %v_%v is older than the latest applied migration %v", migration.Version, migration.Name, latest)
+			}
+			continue
+		}
+		if row.Checksum != migration.Checksum {
+			return fmt.Errorf("checksum mismatch for applied migration %v_%v", migration.Version, migration.Name)
+		}
+	}
+	for version, row := range history {
+		if !known[version] {
+			return fmt.Errorf("applied migration %v_%v is missing from the migration scripts", version, row.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) apply(conn *gorm.DB, migration Migration) error {
+	start := time.Now()
+	return conn.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements(migration.Up) {
+			if err := tx.Exec(statement).Error; err != nil {
+				return fmt.Errorf("migration %v_%v failed: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return tx.Table(m.table).Create(&AppliedMigration{
+			Version:     migration.Version,
+			Name:        migration.Name,
+			Checksum:    migration.Checksum,
+			AppliedAt:   time.Now(),
+			ExecutionMs: time.Since(start).Milliseconds(),
+		}).Error
+	})
+}
+
+func (m *Migrator) revert(conn *gorm.DB, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("migration %v_%v has no down script", migration.Version, migration.Name)
+	}
+	return conn.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range statements(migration.Down) {
+			if err := tx.Exec(statement).Error; err != nil {
+				return fmt.Errorf("reverting migration %v_%v failed: %w", migration.Version, migration.Name, err)
+			}
+		}
+		return tx.Table(m.table).Where("version = ?", migration.Version).Delete(&AppliedMigration{}).Error
+	})
+}
+
+func (m *Migrator) history(conn *gorm.DB) (map[uint64]AppliedMigration, error) {
+	if err := conn.Table(m.table).AutoMigrate(&AppliedMigration{}); err != nil {
+		return nil, err
+	}
+	var rows []AppliedMigration
+	if err := conn.Table(m.table).Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	history := make(map[uint64]AppliedMigration)
+	for _, row := range rows {
+		history[row.Version] = row
+	}
+	return history, nil
+}
+
+// withLock runs fn on a single connection holding a database level lock, so that only one replica
+// of a service migrates at a time. SQLite has no such lock and relies on its file lock instead.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		lockName := m.table + "_lock"
+		switch conn.Dialector.Name() {
+		case "mysql":
+			var acquired int
+			if err := conn.Raw("SELECT GET_LOCK(?, ?)", lockName, int(m.lockTimeout.Seconds())).Scan(&acquired).Error; err != nil {
+				return err
+			}
+			if acquired != 1 {
+				return errors.New("timed out waiting for the migration lock")
+			}
+			defer conn.Exec("SELECT RELEASE_LOCK(?)", lockName)
+		case "postgres":
+			key := lockKey(lockName)
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", key).Error; err != nil {
+				return err
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", key)
+		}
+		return fn(conn)
+	})
+}
+
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
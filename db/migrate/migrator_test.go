@@ -0,0 +1,46 @@
+package migrate
+
+import (
+	"context"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDownRefusesChangedMigrations(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:migrator?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDb.Close()
+	fsys := fstest.MapFS{
+		"migrations/0001_create_widgets.up.sql":   {Data: []byte("CREATE TABLE widgets (id INT);\n")},
+		"migrations/0001_create_widgets.down.sql": {Data: []byte("DROP TABLE widgets;\n")},
+	}
+	migrator, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if applied, err := migrator.Up(ctx); err != nil || applied != 1 {
+		t.Fatalf("applied %v migrations: %v", applied, err)
+	}
+
+	fsys["migrations/0001_create_widgets.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE widgets (id BIGINT);\n")}
+	changed, err := NewFromFS(db, fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := changed.Down(ctx, 1); err == nil {
+		t.Fatal("reverted a migration whose script changed after it was applied")
+	}
+	if reverted, err := migrator.Down(ctx, 1); err != nil || reverted != 1 {
+		t.Fatalf("reverted %v migrations: %v", reverted, err)
+	}
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"gorm.io/gorm"
+	"time"
+)
+
+const defaultOutboxTable = "outbox_messages"
+
+// OutboxEventFactory builds the event published for a repository write, returning nil skips the write.
+// For deletes base only identifies the entity type, externalId identifies the entity.
+type OutboxEventFactory func(ctx context.Context, action AuditAction, externalId string, base entity.Base) entity.Event
+
+// OutboxMessage is an event waiting in the outbox table to be published by an OutboxRelay.
+type OutboxMessage struct {
+	Id            uint64     `gorm:"primaryKey;AUTO_INCREMENT"`
+	EventId       string     `gorm:"type:varchar(100)"`
+	EntityType    string     `gorm:"type:varchar(100)"`
+	EntityId      string     `gorm:"type:varchar(100)"`
+	Payload       []byte     `gorm:"not null"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string     `gorm:"type:text"`
+	NextAttemptAt time.Time  `gorm:"not null"`
+	CreatedAt     time.Time  `gorm:"not null"`
+	PublishedAt   *time.Time `gorm:"index"`
+}
+
+// Outbox stores events in the same transaction as the entity change they describe, see OutboxRelay for delivery.
+type Outbox struct {
+	db    *gorm.DB
+	table string
+}
+
+type OutboxOption func(o *Outbox)
+
+func WithOutboxTable(table string) OutboxOption {
+	return func(o *Outbox) {
+		o.table = table
+	}
+}
+
+func NewOutbox(db *gorm.DB, opts ...OutboxOption) *Outbox {
+	o := &Outbox{
+		db:    db,
+		table: defaultOutboxTable,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// AutoMigrate creates the outbox table, services managing their schema with db/migrate can create it there instead.
+func (o *Outbox) AutoMigrate() error {
+	return o.db.Table(o.table).AutoMigrate(&OutboxMessage{})
+}
+
+// Add stores event, joining the transaction in ctx so the event is only kept when the transaction commits.
+func (o *Outbox) Add(ctx context.Context, event entity.Event) error {
+	now := time.Now()
+	return conn(ctx, o.db).Table(o.table).Create(&OutboxMessage{
+		EventId:       event.GetId(),
+		EntityType:    event.GetEntityType(),
+		EntityId:      event.GetEntityId(),
+		Payload:       event.ToBytes(),
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}).Error
+}
+
+// pending returns up to limit unpublished messages due at now in insertion order, leaving out messages
+// queued behind an earlier message of the same entity that is waiting for its retry.
+func (o *Outbox) pending(ctx context.Context, now time.Time, limit int) (error, []OutboxMessage) {
+	var messages []OutboxMessage
+	if err := o.db.WithContext(ctx).Table(o.table+" AS message").
+		Where("message.published_at IS NULL AND message.next_attempt_at <= ?", now).
+		Where(fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %v AS earlier WHERE earlier.entity_type = message.entity_type "+
+			"AND earlier.entity_id = message.entity_id AND earlier.id < message.id "+
+			"AND earlier.published_at IS NULL AND earlier.next_attempt_at > ?)", o.table), now).
+		Order("message.id").Limit(limit).Find(&messages).Error; err != nil {
+		return err, nil
+	}
+	return nil, messages
+}
+
+func (o *Outbox) markPublished(ctx context.Context, id uint64) error {
+	return o.db.WithContext(ctx).Table(o.table).Where("id = ?", id).
+		Update("published_at", time.Now()).Error
+}
+
+func (o *Outbox) markFailed(ctx context.Context, id uint64, attempts int, nextAttemptAt time.Time, cause error) error {
+	return o.db.WithContext(ctx).Table(o.table).Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"attempts":        attempts,
+			"next_attempt_at": nextAttemptAt,
+			"last_error":      cause.Error(),
+		}).Error
+}
+
+// purge deletes messages published before the given time.
+func (o *Outbox) purge(ctx context.Context, before time.Time) (error, int64) {
+	tx := o.db.WithContext(ctx).Table(o.table).Where("published_at < ?", before).Delete(&OutboxMessage{})
+	return tx.Error, tx.RowsAffected
+}
+
+// WithOutbox adds the event built by events for every Create, Update and delete of the repository to outbox,
+// in the same transaction as the write.
+func WithOutbox(outbox *Outbox, events OutboxEventFactory) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.outbox = outbox
+		r.events = events
+	}
+}
+
+// outboxEvent replays a stored message as an entity.Event.
+type outboxEvent struct {
+	message OutboxMessage
+}
+
+func (e *outboxEvent) GetEntityId() string {
+	return e.message.EntityId
+}
+
+func (e *outboxEvent) GetEntityType() string {
+	return e.message.EntityType
+}
+
+func (e *outboxEvent) GetId() string {
+	return e.message.EventId
+}
+
+func (e *outboxEvent) ToBytes() []byte {
+	return e.message.Payload
+}
+
+func (e *outboxEvent) FromByte(bytes []byte) {
+	e.message.Payload = bytes
+}
+
+func (e *outboxEvent) Entity() interface{} {
+	return nil
+}
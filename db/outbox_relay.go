@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/event"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRelayInterval  = time.Second
+	defaultRelayBatchSize = 100
+	defaultRelayBackoff   = time.Second
+	defaultRelayMaxDelay  = 5 * time.Minute
+	defaultRelayRetention = 24 * time.Hour
+)
+
+// OutboxRelay polls an Outbox and publishes its messages, at least once and in order per entity:
+// a message is not published while an earlier message of the same entity is pending.
+// Failed messages are retried with exponential backoff, delivered messages are purged after the retention period.
+//
+// Messages are published synchronously and only marked published once the publisher reports their delivery.
+// Run a single relay per outbox table, concurrent relays keep delivery at least once but can reorder an
+// entity's events.
+type OutboxRelay struct {
+	outbox    *Outbox
+	publisher event.ReliablePublisher
+	logger    *logrus.Logger
+
+	interval  time.Duration
+	batchSize int
+	backoff   time.Duration
+	maxDelay  time.Duration
+	retention time.Duration
+
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+type OutboxRelayOption func(r *OutboxRelay)
+
+func WithRelayLogger(logger *logrus.Logger) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		r.logger = logger
+	}
+}
+
+func WithRelayInterval(interval time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		r.interval = interval
+	}
+}
+
+func WithRelayBatchSize(batchSize int) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		r.batchSize = batchSize
+	}
+}
+
+// WithRelayBackoff sets the delay before the first retry of a failed message, doubled on every attempt up to maxDelay.
+func WithRelayBackoff(backoff, maxDelay time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		r.backoff = backoff
+		r.maxDelay = maxDelay
+	}
+}
+
+// WithRelayRetention sets how long delivered messages are kept before being purged.
+func WithRelayRetention(retention time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		r.retention = retention
+	}
+}
+
+func NewOutboxRelay(outbox *Outbox, publisher event.ReliablePublisher, opts ...OutboxRelayOption) *OutboxRelay {
+	r := &OutboxRelay{
+		outbox:    outbox,
+		publisher: publisher,
+		logger:    logrus.StandardLogger(),
+		interval:  defaultRelayInterval,
+		batchSize: defaultRelayBatchSize,
+		backoff:   defaultRelayBackoff,
+		maxDelay:  defaultRelayMaxDelay,
+		retention: defaultRelayRetention,
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Start polls the outbox in the background until Close is called.
+func (r *OutboxRelay) Start(ctx context.Context) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := r.RelayOnce(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				r.logger.WithError(err).Error("outbox relay failed")
+			}
+			select {
+			case <-r.done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (r *OutboxRelay) Close() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+	r.wg.Wait()
+}
+
+// RelayOnce publishes one batch of pending messages and purges expired ones, it returns the number of messages published.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	now := time.Now()
+	err, messages := r.outbox.pending(ctx, now, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	blocked := make(map[string]bool)
+	published := 0
+	for _, message := range messages {
+		key := message.EntityType + "/" + message.EntityId
+		if blocked[key] {
+			continue
+		}
+		if err := r.publisher.PublishSync(ctx, &outboxEvent{message: message}); err != nil {
+			blocked[key] = true
+			attempts := message.Attempts + 1
+			r.logger.WithError(err).WithField("outbox_id", message.Id).WithField("attempts", attempts).Warn("outbox publish failed")
+			if err := r.outbox.markFailed(ctx, message.Id, attempts, now.Add(r.delay(attempts)), err); err != nil {
+				return published, err
+			}
+			continue
+		}
+		if err := r.outbox.markPublished(ctx, message.Id); err != nil {
+			return published, err
+		}
+		published++
+	}
+	err, purged := r.outbox.purge(ctx, now.Add(-r.retention))
+	if err != nil {
+		return published, err
+	}
+	if purged > 0 {
+		r.logger.WithField("count", purged).Debug("purged delivered outbox messages")
+	}
+	return published, nil
+}
+
+func (r *OutboxRelay) delay(attempts int) time.Duration {
+	delay := r.backoff
+	for i := 1; i < attempts && delay < r.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.maxDelay {
+		return r.maxDelay
+	}
+	return delay
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	id, entityId string
+}
+
+func (e *testEvent) GetEntityId() string   { return e.entityId }
+func (e *testEvent) GetEntityType() string { return "widgets" }
+func (e *testEvent) GetId() string         { return e.id }
+func (e *testEvent) ToBytes() []byte       { return []byte(e.id) }
+func (e *testEvent) FromByte(bytes []byte) {}
+func (e *testEvent) Entity() interface{}   { return nil }
+
+type recordingPublisher struct {
+	fail      map[string]bool
+	published []string
+}
+
+func (p *recordingPublisher) PublishSync(ctx context.Context, event entity.Event) error {
+	if p.fail[event.GetId()] {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, event.GetId())
+	return nil
+}
+
+func TestOutboxRelayKeepsEntityOrderAcrossRetries(t *testing.T) {
+	outbox := NewOutbox(newTestDb(t))
+	if err := outbox.AutoMigrate(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, ev := range []*testEvent{{"a1", "a"}, {"b1", "b"}, {"a2", "a"}} {
+		if err := outbox.Add(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publisher := &recordingPublisher{fail: map[string]bool{"a1": true}}
+	relay := NewOutboxRelay(outbox, publisher, WithRelayBackoff(time.Hour, time.Hour))
+
+	if _, err := relay.RelayOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// a2 must wait for a1, which now waits for its retry
+	if err := assertPublished(publisher, "b1"); err != nil {
+		t.Fatal(err)
+	}
+	publisher.fail = nil
+	if _, err := relay.RelayOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertPublished(publisher, "b1"); err != nil {
+		t.Fatalf("message published before its retry was due: %v", err)
+	}
+
+	if err := outbox.db.Table(outbox.table).Where("event_id = ?", "a1").
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := relay.RelayOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertPublished(publisher, "b1", "a1", "a2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertPublished(publisher *recordingPublisher, expected ...string) error {
+	if !reflect.DeepEqual(publisher.published, expected) {
+		return fmt.Errorf("published %v, expected %v", publisher.published, expected)
+	}
+	return nil
+}
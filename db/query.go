@@ -0,0 +1,313 @@
+package db
+
+import (
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"strconv"
+	"strings"
+)
+
+type Operator string
+
+const (
+	OpEq      Operator = "eq"
+	OpNe      Operator = "ne"
+	OpIn      Operator = "in"
+	OpNotIn   Operator = "nin"
+	OpGt      Operator = "gt"
+	OpGte     Operator = "gte"
+	OpLt      Operator = "lt"
+	OpLte     Operator = "lte"
+	OpBetween Operator = "between"
+	OpLike    Operator = "like"
+	OpIsNull  Operator = "isnull"
+	OpNotNull Operator = "notnull"
+	OpAnd     Operator = "and"
+	OpOr      Operator = "or"
+)
+
+const (
+	paramLimit    = "limit"
+	paramOffset   = "offset"
+	paramSort     = "sort"
+	paramOpSep    = "__"
+	defaultLimit  = 100
+	maxQueryLimit = 1000
+)
+
+// Condition is a single predicate on a field, or an AND/OR group of nested conditions
+// when Operator is OpAnd or OpOr.
+type Condition struct {
+	Field      string
+	Operator   Operator
+	Value      interface{}
+	Values     []interface{}
+	Conditions []Condition
+}
+
+func Eq(field string, value interface{}) Condition {
+	return Condition{Field: field, Operator: OpEq, Value: value}
+}
+
+func Ne(field string, value interface{}) Condition {
+	return Condition{Field: field, Operator: OpNe, Value: value}
+}
+
+func In(field string, values ...interface{}) Condition {
+	return Condition{Field: field, Operator: OpIn, Values: values}
+}
+
+func NotIn(field string, values ...interface{}) Condition {
+	return Condition{Field: field, Operator: OpNotIn, Values: values}
+}
+
+func Gt(field string, value interface{}) Condition {
+	return Condition{Field: field, Operator: OpGt, Value: value}
+}
+
+func Gte(field string, value interface{}) Condition {
+	return Condition{Field: field, Operator: OpGte, Value: value}
+}
+
+func Lt(field string, value interface{}) Condition {
+	return Condition{Field: field, Operator: OpLt, Value: value}
+}
+
+func Lte(field string, value interface{}) Condition {
+	return Condition{Field: field, Operator: OpLte, Value: value}
+}
+
+func Between(field string, start, end interface{}) Condition {
+	return Condition{Field: field, Operator: OpBetween, Values: []interface{}{start, end}}
+}
+
+// Like matches field against a SQL LIKE pattern, % and _ are passed through as is.
+func Like(field string, pattern string) Condition {
+	return Condition{Field: field, Operator: OpLike, Value: pattern}
+}
+
+func IsNull(field string) Condition {
+	return Condition{Field: field, Operator: OpIsNull}
+}
+
+func NotNull(field string) Condition {
+	return Condition{Field: field, Operator: OpNotNull}
+}
+
+func And(conditions ...Condition) Condition {
+	return Condition{Operator: OpAnd, Conditions: conditions}
+}
+
+func Or(conditions ...Condition) Condition {
+	return Condition{Operator: OpOr, Conditions: conditions}
+}
+
+func (c Condition) IsGroup() bool {
+	return c.Operator == OpAnd || c.Operator == OpOr
+}
+
+// Fields returns every field referenced by the condition and its nested conditions.
+func (c Condition) Fields() []string {
+	if !c.IsGroup() {
+		return []string{c.Field}
+	}
+	var fields []string
+	for _, nested := range c.Conditions {
+		fields = append(fields, nested.Fields()...)
+	}
+	return fields
+}
+
+func (c Condition) Validate() error {
+	switch c.Operator {
+	case OpAnd, OpOr:
+		if len(c.Conditions) == 0 {
+			return fmt.Errorf("%v group requires at least one condition", c.Operator)
+		}
+		for _, nested := range c.Conditions {
+			if err := nested.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpLike:
+		if c.Value == nil {
+			return fmt.Errorf("operator %v on %v requires a value", c.Operator, c.Field)
+		}
+	case OpIn, OpNotIn:
+		if len(c.Values) == 0 {
+			return fmt.Errorf("operator %v on %v requires at least one value", c.Operator, c.Field)
+		}
+	case OpBetween:
+		if len(c.Values) != 2 {
+			return fmt.Errorf("operator %v on %v requires exactly two values", c.Operator, c.Field)
+		}
+	case OpIsNull, OpNotNull:
+	default:
+		return fmt.Errorf("unsupported operator %v", c.Operator)
+	}
+	if c.Field == "" {
+		return fmt.Errorf("operator %v requires a field", c.Operator)
+	}
+	return nil
+}
+
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Query is a backend agnostic description of a search, all conditions are ANDed.
+type Query struct {
+	Conditions []Condition
+	Sort       []SortField
+	Limit      int
+	Offset     int
+}
+
+func NewQuery() *Query {
+	return &Query{}
+}
+
+func (q *Query) Where(conditions ...Condition) *Query {
+	q.Conditions = append(q.Conditions, conditions...)
+	return q
+}
+
+func (q *Query) OrderBy(field string, desc bool) *Query {
+	q.Sort = append(q.Sort, SortField{Field: field, Desc: desc})
+	return q
+}
+
+func (q *Query) WithLimit(limit int) *Query {
+	q.Limit = limit
+	return q
+}
+
+func (q *Query) WithOffset(offset int) *Query {
+	q.Offset = offset
+	return q
+}
+
+// Fields returns every field referenced by the query conditions and sort fields.
+func (q *Query) Fields() []string {
+	var fields []string
+	for _, condition := range q.Conditions {
+		fields = append(fields, condition.Fields()...)
+	}
+	for _, sort := range q.Sort {
+		fields = append(fields, sort.Field)
+	}
+	return fields
+}
+
+func (q *Query) Validate() error {
+	for _, condition := range q.Conditions {
+		if err := condition.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, sort := range q.Sort {
+		if sort.Field == "" {
+			return fmt.Errorf("sort field cannot be empty")
+		}
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return fmt.Errorf("limit and offset cannot be negative")
+	}
+	return nil
+}
+
+// PageLimit returns the effective page size, applying the default and upper bound.
+func (q *Query) PageLimit() int {
+	if q.Limit <= 0 {
+		return defaultLimit
+	}
+	if q.Limit > maxQueryLimit {
+		return maxQueryLimit
+	}
+	return q.Limit
+}
+
+// QueryFromParams builds a Query from flat request params.
+//
+// Reserved keys are limit, offset and sort (comma separated, prefix a field with - to sort descending).
+// Every other key is a condition, optionally suffixed with an operator, e.g. amount__gte=10,
+// status__in=1,2 or deleted_at__isnull=true, a key without suffix is an equality condition.
+func QueryFromParams(params map[string]string) (error, *Query) {
+	query := NewQuery()
+	for key, value := range params {
+		switch key {
+		case paramLimit:
+			limit, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid limit %v", value), nil
+			}
+			query.Limit = limit
+		case paramOffset:
+			offset, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid offset %v", value), nil
+			}
+			query.Offset = offset
+		case paramSort:
+			for _, field := range strings.Split(value, ",") {
+				field = strings.TrimSpace(field)
+				if field == "" {
+					continue
+				}
+				query.OrderBy(strings.TrimPrefix(field, "-"), strings.HasPrefix(field, "-"))
+			}
+		default:
+			err, condition := conditionFromParam(key, value)
+			if err != nil {
+				return err, nil
+			}
+			query.Where(condition)
+		}
+	}
+	if err := query.Validate(); err != nil {
+		return err, nil
+	}
+	return nil, query
+}
+
+func conditionFromParam(key, value string) (error, Condition) {
+	field, op := key, OpEq
+	if idx := strings.LastIndex(key, paramOpSep); idx > 0 {
+		field, op = key[:idx], Operator(key[idx+len(paramOpSep):])
+	}
+	switch op {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpLike:
+		return nil, Condition{Field: field, Operator: op, Value: value}
+	case OpIn, OpNotIn:
+		return nil, Condition{Field: field, Operator: op, Values: splitValues(value)}
+	case OpBetween:
+		return nil, Condition{Field: field, Operator: op, Values: splitValues(value)}
+	case OpIsNull:
+		isNull, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %v for %v", value, key), Condition{}
+		}
+		if isNull {
+			return nil, IsNull(field)
+		}
+		return nil, NotNull(field)
+	default:
+		return fmt.Errorf("unsupported operator %v in %v", op, key), Condition{}
+	}
+}
+
+func splitValues(value string) []interface{} {
+	var values []interface{}
+	for _, v := range strings.Split(value, ",") {
+		values = append(values, strings.TrimSpace(v))
+	}
+	return values
+}
+
+// SearchResult is a single page of a search along with the total number of matches.
+type SearchResult struct {
+	Items []entity.Base
+	Total int64
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"reflect"
+	"testing"
+)
+
+// dryRunDb renders statements with the MySQL dialect without connecting to a server.
+func dryRunDb(t *testing.T) *gorm.DB {
+	t.Helper()
+	dialector := mysql.New(mysql.Config{DSN: "test@tcp(localhost:3306)/test", SkipInitializeWithVersion: true})
+	db, err := gorm.Open(dialector, &gorm.Config{DryRun: true, DisableAutomaticPing: true, Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestQueryFromParams(t *testing.T) {
+	err, query := QueryFromParams(map[string]string{
+		"name":                "bolt",
+		"quantity__gte":       "10",
+		"status__in":          "1, 2",
+		"deleted_at__isnull":  "false",
+		"created_at__between": "2022-01-01,2022-02-01",
+		"sort":                "-quantity,name",
+		"limit":               "20",
+		"offset":              "40",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditions := map[string]Condition{}
+	for _, condition := range query.Conditions {
+		conditions[condition.Field] = condition
+	}
+	expected := map[string]Condition{
+		"name":       Eq("name", "bolt"),
+		"quantity":   Gte("quantity", "10"),
+		"status":     In("status", "1", "2"),
+		"deleted_at": NotNull("deleted_at"),
+		"created_at": Between("created_at", "2022-01-01", "2022-02-01"),
+	}
+	if !reflect.DeepEqual(conditions, expected) {
+		t.Fatalf("got conditions %+v\nexpected %+v", conditions, expected)
+	}
+	if sort := []SortField{{Field: "quantity", Desc: true}, {Field: "name"}}; !reflect.DeepEqual(query.Sort, sort) {
+		t.Fatalf("got sort %+v, expected %+v", query.Sort, sort)
+	}
+	if query.Limit != 20 || query.Offset != 40 {
+		t.Fatalf("got limit %v and offset %v", query.Limit, query.Offset)
+	}
+
+	for _, params := range []map[string]string{
+		{"name__regex": "b.*"},
+		{"created_at__between": "2022-01-01"},
+		{"deleted_at__isnull": "maybe"},
+		{"limit": "ten"},
+	} {
+		if err, _ := QueryFromParams(params); err == nil {
+			t.Errorf("accepted invalid params %v", params)
+		}
+	}
+}
+
+func TestConditionToClause(t *testing.T) {
+	db := dryRunDb(t)
+	tests := []struct {
+		name      string
+		condition Condition
+		sql       string
+		vars      []interface{}
+	}{
+		{name: "eq", condition: Eq("name", "bolt"), sql: "`name` = ?", vars: []interface{}{"bolt"}},
+		{name: "ne", condition: Ne("name", "bolt"), sql: "`name` <> ?", vars: []interface{}{"bolt"}},
+		{name: "in", condition: In("quantity", 1, 2), sql: "`quantity` IN (?,?)", vars: []interface{}{1, 2}},
+		{name: "not in", condition: NotIn("quantity", 1, 2), sql: "`quantity` NOT IN (?,?)", vars: []interface{}{1, 2}},
+		{name: "gt", condition: Gt("quantity", 1), sql: "`quantity` > ?", vars: []interface{}{1}},
+		{name: "gte", condition: Gte("quantity", 1), sql: "`quantity` >= ?", vars: []interface{}{1}},
+		{name: "lt", condition: Lt("quantity", 1), sql: "`quantity` < ?", vars: []interface{}{1}},
+		{name: "lte", condition: Lte("quantity", 1), sql: "`quantity` <= ?", vars: []interface{}{1}},
+		{name: "between", condition: Between("quantity", 1, 5), sql: "`quantity` BETWEEN ? AND ?", vars: []interface{}{1, 5}},
+		{name: "like", condition: Like("name", "bo%"), sql: "`name` LIKE ?", vars: []interface{}{"bo%"}},
+		{name: "is null", condition: IsNull("deleted_at"), sql: "`deleted_at` IS NULL", vars: []interface{}{}},
+		{name: "not null", condition: NotNull("deleted_at"), sql: "`deleted_at` IS NOT NULL", vars: []interface{}{}},
+		{
+			name:      "nested groups",
+			condition: Or(Eq("name", "bolt"), And(Gt("quantity", 1), Lt("quantity", 5))),
+			sql:       "(`name` = ? OR (`quantity` > ? AND `quantity` < ?))",
+			vars:      []interface{}{"bolt", 1, 5},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stmt := db.Table("widgets").
+				Clauses(clause.Where{Exprs: conditionsToClauses([]Condition{test.condition})}).
+				Find(&[]map[string]interface{}{}).Statement
+			sql := "SELECT * FROM `widgets` WHERE " + test.sql
+			if got := stmt.SQL.String(); got != sql {
+				t.Fatalf("got %v\nexpected %v", got, sql)
+			}
+			if !reflect.DeepEqual(stmt.Vars, test.vars) {
+				t.Fatalf("got vars %v, expected %v", stmt.Vars, test.vars)
+			}
+		})
+	}
+}
+
+func TestConditionsAreANDed(t *testing.T) {
+	db := dryRunDb(t)
+	tests := []struct {
+		name       string
+		conditions []Condition
+		sql        string
+	}{
+		{name: "single or condition", conditions: []Condition{Eq("name", "bolt"), Or(Gt("quantity", 1))}, sql: "`name` = ? AND `quantity` > ?"},
+		{name: "or group", conditions: []Condition{Eq("name", "bolt"), Or(Gt("quantity", 5), Lt("quantity", 1))}, sql: "`name` = ? AND (`quantity` > ? OR `quantity` < ?)"},
+		{name: "and group", conditions: []Condition{Or(Eq("name", "bolt"), Eq("name", "nut")), And(Gt("quantity", 1))}, sql: "(`name` = ? OR `name` = ?) AND `quantity` > ?"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stmt := db.Table("widgets").
+				Clauses(clause.Where{Exprs: conditionsToClauses(test.conditions)}).
+				Find(&[]map[string]interface{}{}).Statement
+			sql := "SELECT * FROM `widgets` WHERE " + test.sql
+			if got := stmt.SQL.String(); got != sql {
+				t.Fatalf("got %v\nexpected %v", got, sql)
+			}
+		})
+	}
+}
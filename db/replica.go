@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	forcePrimaryCtxKey         contextKey = "force-primary"
+	defaultHealthCheckInterval            = 10 * time.Second
+)
+
+// WithPrimary returns a context under which repository reads go to the primary, e.g. to read your own writes.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryCtxKey, true)
+}
+
+func forcePrimary(ctx context.Context) bool {
+	forced, _ := ctx.Value(forcePrimaryCtxKey).(bool)
+	return forced
+}
+
+type replica struct {
+	db      *gorm.DB
+	healthy int32
+}
+
+// ReplicaSet routes reads round robin across healthy read replicas, falling back to the primary
+// when none is healthy. Replicas are pinged in the background as soon as the set is created and then every
+// health check interval, reads go to the primary until a replica has answered.
+type ReplicaSet struct {
+	primary  *gorm.DB
+	replicas []*replica
+	next     uint32
+	interval time.Duration
+	done     chan struct{}
+	once     sync.Once
+}
+
+func NewReplicaSet(primary *gorm.DB, replicas []*gorm.DB, interval time.Duration) *ReplicaSet {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	rs := &ReplicaSet{
+		primary:  primary,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	for _, db := range replicas {
+		rs.replicas = append(rs.replicas, &replica{db: db})
+	}
+	if len(rs.replicas) > 0 {
+		go rs.watch()
+	}
+	return rs
+}
+
+func (rs *ReplicaSet) Primary() *gorm.DB {
+	return rs.primary
+}
+
+// Reader returns the connection reads for ctx should use.
+func (rs *ReplicaSet) Reader(ctx context.Context) *gorm.DB {
+	if forcePrimary(ctx) || InTx(ctx) || len(rs.replicas) == 0 {
+		return rs.primary
+	}
+	start := atomic.AddUint32(&rs.next, 1)
+	for i := 0; i < len(rs.replicas); i++ {
+		candidate := rs.replicas[(int(start)+i)%len(rs.replicas)]
+		if atomic.LoadInt32(&candidate.healthy) == 1 {
+			return candidate.db
+		}
+	}
+	return rs.primary
+}
+
+func (rs *ReplicaSet) Close() {
+	rs.once.Do(func() {
+		close(rs.done)
+	})
+}
+
+func (rs *ReplicaSet) watch() {
+	rs.checkHealth()
+	ticker := time.NewTicker(rs.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rs.done:
+			return
+		case <-ticker.C:
+			rs.checkHealth()
+		}
+	}
+}
+
+// checkHealth pings the replicas concurrently, so that an unreachable replica does not delay the others.
+func (rs *ReplicaSet) checkHealth() {
+	var wg sync.WaitGroup
+	for _, r := range rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			healthy := int32(1)
+			ctx, cancel := context.WithTimeout(context.Background(), rs.interval)
+			defer cancel()
+			sqlDb, err := r.db.DB()
+			if err != nil || sqlDb.PingContext(ctx) != nil {
+				healthy = 0
+			}
+			atomic.StoreInt32(&r.healthy, healthy)
+		}(r)
+	}
+	wg.Wait()
+}
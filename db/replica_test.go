@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/config"
+	traceSdk "go.opentelemetry.io/otel/sdk/trace"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func closeTestDb(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb.Close()
+}
+
+func TestReplicaSetRoutesReadsAcrossReplicas(t *testing.T) {
+	primary := openTestDb(t, t.Name()+"_primary")
+	replicas := []*gorm.DB{openTestDb(t, t.Name()+"_replica_1"), openTestDb(t, t.Name()+"_replica_2")}
+	rs := NewReplicaSet(primary, replicas, time.Hour)
+	defer rs.Close()
+	rs.checkHealth()
+
+	ctx := context.Background()
+	seen := map[*gorm.DB]int{}
+	for i := 0; i < 4; i++ {
+		seen[rs.Reader(ctx)]++
+	}
+	if seen[replicas[0]] != 2 || seen[replicas[1]] != 2 {
+		t.Fatalf("expected reads to alternate between the replicas, got %v", seen)
+	}
+	if rs.Reader(WithPrimary(ctx)) != primary {
+		t.Fatal("WithPrimary read from a replica")
+	}
+	err := RunInTx(ctx, primary, func(ctx context.Context) error {
+		if rs.Reader(ctx) != primary {
+			return errors.New("read of a transaction went to a replica")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplicaSetFallsBackToPrimary(t *testing.T) {
+	primary := openTestDb(t, t.Name()+"_primary")
+	down, up := openTestDb(t, t.Name()+"_down"), openTestDb(t, t.Name()+"_up")
+	closeTestDb(t, down)
+
+	// a replica down at startup is never handed out, not even before the first health check
+	rs := NewReplicaSet(primary, []*gorm.DB{down, up}, time.Hour)
+	defer rs.Close()
+	ctx := context.Background()
+	if reader := rs.Reader(ctx); reader == down {
+		t.Fatal("read went to a replica before it answered")
+	}
+	rs.checkHealth()
+	for i := 0; i < 4; i++ {
+		if reader := rs.Reader(ctx); reader != up {
+			t.Fatalf("read %v went to %p, expected the healthy replica", i, reader)
+		}
+	}
+
+	closeTestDb(t, up)
+	rs.checkHealth()
+	if rs.Reader(ctx) != primary {
+		t.Fatal("expected reads to fall back to the primary without a healthy replica")
+	}
+}
+
+func TestRepositoryReadsFromReplicas(t *testing.T) {
+	primary := openTestDb(t, t.Name()+"_primary")
+	// the replica lags behind and has not seen the widget yet
+	replica := openTestDb(t, t.Name()+"_replica")
+	rs := NewReplicaSet(primary, []*gorm.DB{replica}, time.Hour)
+	defer rs.Close()
+	rs.checkHealth()
+	repo := NewGORMRepository(WithDb(primary), WithCreator(newWidget), WithReplicaSet(rs))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err, _ := repo.GetByExternalId(ctx, created.GetExternalId()); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected the read to go to the replica, got %v", err)
+	}
+	if err, _ := repo.GetByExternalId(WithPrimary(ctx), created.GetExternalId()); err != nil {
+		t.Fatalf("read from the primary failed: %v", err)
+	}
+	err = RunInTx(ctx, primary, func(ctx context.Context) error {
+		err, _ := repo.GetByExternalId(ctx, created.GetExternalId())
+		return err
+	})
+	if err != nil {
+		t.Fatalf("read inside a transaction failed: %v", err)
+	}
+	// updates read the entity from the primary
+	if err, _ := repo.Update(ctx, created.GetExternalId(), &widget{Name: "nut"}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+}
+
+func TestReplicaSetStartsWithUnreachableReplica(t *testing.T) {
+	cfg := config.DatabaseConfig{Type: "mysql", HostName: "127.0.0.1", Port: "1", DatabaseName: "widgets", ConnectTimeout: 100 * time.Millisecond}
+	unreachable, err := NewGormReplicaConn(cfg, traceSdk.NewTracerProvider())
+	if err != nil {
+		t.Fatalf("opening an unreachable replica failed: %v", err)
+	}
+	defer closeTestDb(t, unreachable)
+	primary := openTestDb(t, t.Name()+"_primary")
+
+	start := time.Now()
+	rs := NewReplicaSet(primary, []*gorm.DB{unreachable}, time.Hour)
+	defer rs.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("creating the replica set took %v", elapsed)
+	}
+	ctx := context.Background()
+	if rs.Reader(ctx) != primary {
+		t.Fatal("read went to the unreachable replica before it answered")
+	}
+	rs.checkHealth()
+	if rs.Reader(ctx) != primary {
+		t.Fatal("read went to the unreachable replica")
+	}
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"time"
+)
+
+// Repository is a typed view over a BaseRepository, it returns the concrete model T so callers no
+// longer assert entity.Base, and follows the usual (value, error) return order.
+type Repository[T entity.Base] struct {
+	base BaseRepository
+}
+
+func NewRepository[T entity.Base](base BaseRepository) *Repository[T] {
+	return &Repository[T]{base: base}
+}
+
+// NewTypedGORMRepository creates a GORMRepository for *E, the entity creator allocates a new E,
+// e.g. NewTypedGORMRepository[Widget]() returns a *Repository[*Widget].
+func NewTypedGORMRepository[E any, T interface {
+	*E
+	entity.Base
+}](opts ...GORMRepositoryOption) *Repository[T] {
+	opts = append([]GORMRepositoryOption{WithCreator(CreatorOf[E, T]())}, opts...)
+	return NewRepository[T](NewGORMRepository(opts...))
+}
+
+// NewTypedElasticsearchRepo creates an ElasticsearchRepo for *E, documents are decoded into a new E.
+func NewTypedElasticsearchRepo[E any, T interface {
+	*E
+	entity.Base
+}](opts ...ElasticsearchRepoOption) *Repository[T] {
+	opts = append([]ElasticsearchRepoOption{WithEntityCreator(CreatorOf[E, T]())}, opts...)
+	return NewRepository[T](NewElasticsearchRepo(opts...))
+}
+
+// CreatorOf returns an entity.EntityCreator allocating a new E, the entity is the pointer to E so that GORM and
+// FromSqlRow can scan into it, e.g. CreatorOf[Widget]() creates *Widget.
+func CreatorOf[E any, T interface {
+	*E
+	entity.Base
+}]() entity.EntityCreator {
+	return func() entity.Base {
+		return T(new(E))
+	}
+}
+
+// Base returns the untyped repository, for callers still written against BaseRepository.
+func (r *Repository[T]) Base() BaseRepository {
+	return r.base
+}
+
+func (r *Repository[T]) GetById(ctx context.Context, id uint64) (T, error) {
+	err, base := r.base.GetById(ctx, id)
+	return typed[T](base, err)
+}
+
+func (r *Repository[T]) GetByExternalId(ctx context.Context, externalId string) (T, error) {
+	err, base := r.base.GetByExternalId(ctx, externalId)
+	return typed[T](base, err)
+}
+
+// Load gets the entity through the request's loader when the context has one, see WithLoaders.
+func (r *Repository[T]) Load(ctx context.Context, externalId string) (T, error) {
+	err, base := Load(ctx, r.base, externalId)
+	return typed[T](base, err)
+}
+
+func (r *Repository[T]) MultiGetByExternalId(ctx context.Context, externalIds []string) ([]T, error) {
+	err, bases := r.base.MultiGetByExternalId(ctx, externalIds)
+	return typedSlice[T](bases, err)
+}
+
+func (r *Repository[T]) Create(ctx context.Context, model T) (T, error) {
+	err, base := r.base.Create(ctx, model)
+	return typed[T](base, err)
+}
+
+func (r *Repository[T]) Update(ctx context.Context, externalId string, model T) (T, error) {
+	err, base := r.base.Update(ctx, externalId, model)
+	return typed[T](base, err)
+}
+
+// UpdateFields sets exactly the fields in paths to their values on model.
+func (r *Repository[T]) UpdateFields(ctx context.Context, externalId string, model T, paths []string) (T, error) {
+	err, base := r.base.UpdateFields(ctx, externalId, model, paths)
+	return typed[T](base, err)
+}
+
+// UpdateMask sets exactly the fields in mask to their values on model.
+func (r *Repository[T]) UpdateMask(ctx context.Context, externalId string, model T, mask *fieldmaskpb.FieldMask) (T, error) {
+	return r.UpdateFields(ctx, externalId, model, PathsFromFieldMask(mask))
+}
+
+func (r *Repository[T]) Search(ctx context.Context, params map[string]string) ([]T, error) {
+	err, bases := r.base.Search(ctx, params)
+	return typedSlice[T](bases, err)
+}
+
+// SearchQuery returns a single page of matches along with the total number of matches.
+func (r *Repository[T]) SearchQuery(ctx context.Context, query *Query) ([]T, int64, error) {
+	err, result := r.base.SearchQuery(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, err := typedSlice[T](result.Items, nil)
+	return items, result.Total, err
+}
+
+// SearchAfter returns a single page of matches and the token of the next page, empty on the last page.
+func (r *Repository[T]) SearchAfter(ctx context.Context, query *Query, token string) ([]T, string, error) {
+	err, page := r.base.SearchAfter(ctx, query, token)
+	if err != nil {
+		return nil, "", err
+	}
+	items, err := typedSlice[T](page.Items, nil)
+	return items, page.NextToken, err
+}
+
+func (r *Repository[T]) Count(ctx context.Context, conditions ...Condition) (int64, error) {
+	err, count := r.base.Count(ctx, conditions...)
+	return count, err
+}
+
+func (r *Repository[T]) Exists(ctx context.Context, conditions ...Condition) (bool, error) {
+	err, exists := r.base.Exists(ctx, conditions...)
+	return exists, err
+}
+
+func (r *Repository[T]) Aggregate(ctx context.Context, query *AggregateQuery) ([]AggregateRow, error) {
+	err, rows := r.base.Aggregate(ctx, query)
+	return rows, err
+}
+
+// AsOf returns the entity as it was at the given time, the underlying repository must be a TemporalRepository.
+func (r *Repository[T]) AsOf(ctx context.Context, externalId string, at time.Time) (T, error) {
+	temporal, ok := r.base.(TemporalRepository)
+	if !ok {
+		var zero T
+		return zero, errors.New("repository does not keep entity history")
+	}
+	err, base := temporal.AsOf(ctx, externalId, at)
+	return typed[T](base, err)
+}
+
+func (r *Repository[T]) Delete(ctx context.Context, externalId string) error {
+	return r.base.Delete(ctx, externalId)
+}
+
+func (r *Repository[T]) SoftDelete(ctx context.Context, externalId string) error {
+	return r.base.SoftDelete(ctx, externalId)
+}
+
+func (r *Repository[T]) Restore(ctx context.Context, externalId string) error {
+	return r.base.Restore(ctx, externalId)
+}
+
+func (r *Repository[T]) HardDelete(ctx context.Context, externalId string) error {
+	return r.base.HardDelete(ctx, externalId)
+}
+
+func typed[T entity.Base](base entity.Base, err error) (T, error) {
+	var zero T
+	if err != nil || base == nil {
+		return zero, err
+	}
+	model, ok := base.(T)
+	if !ok {
+		return zero, fmt.Errorf("unexpected entity type %T, expected %T", base, zero)
+	}
+	return model, nil
+}
+
+func typedSlice[T entity.Base](bases []entity.Base, err error) ([]T, error) {
+	if err != nil {
+		return nil, err
+	}
+	models := make([]T, 0, len(bases))
+	for _, base := range bases {
+		model, err := typed[T](base, nil)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, model)
+	}
+	return models, nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestTypedGORMRepository(t *testing.T) {
+	repo := NewTypedGORMRepository[widget](WithDb(newTestDb(t)))
+	ctx := context.Background()
+
+	created, err := repo.Create(ctx, &widget{Name: "bolt", Quantity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetByExternalId(ctx, created.ExternalId)
+	if err != nil || got.Name != "bolt" || got.Quantity != 2 {
+		t.Fatalf("got %+v: %v", got, err)
+	}
+	if got, err := repo.GetById(ctx, created.Id); err != nil || got.ExternalId != created.ExternalId {
+		t.Fatalf("got %+v by id: %v", got, err)
+	}
+	updated, err := repo.Update(ctx, created.ExternalId, &widget{Quantity: 5})
+	if err != nil || updated.Name != "bolt" || updated.Quantity != 5 {
+		t.Fatalf("updated %+v: %v", updated, err)
+	}
+	if updated, err := repo.UpdateFields(ctx, created.ExternalId, &widget{Name: "nut"}, []string{"name"}); err != nil || updated.Name != "nut" {
+		t.Fatalf("updated fields %+v: %v", updated, err)
+	}
+	other, err := repo.Create(ctx, &widget{Name: "washer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := repo.MultiGetByExternalId(ctx, []string{created.ExternalId, other.ExternalId})
+	if err != nil || len(all) != 2 {
+		t.Fatalf("multi get returned %v: %v", all, err)
+	}
+	items, total, err := repo.SearchQuery(ctx, NewQuery().Where(Eq("name", "nut")))
+	if err != nil || total != 1 || len(items) != 1 || items[0].Quantity != 5 {
+		t.Fatalf("searched %v of %v: %v", items, total, err)
+	}
+	if count, err := repo.Count(ctx); err != nil || count != 2 {
+		t.Fatalf("counted %v: %v", count, err)
+	}
+
+	if err := repo.Delete(ctx, other.ExternalId); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := repo.GetByExternalId(ctx, other.ExternalId)
+	if err == nil || missing != nil {
+		t.Fatalf("got deleted widget %+v: %v", missing, err)
+	}
+
+	// the untyped repository keeps the (error, entity.Base) order of BaseRepository
+	err, base := repo.Base().GetByExternalId(ctx, created.ExternalId)
+	if err != nil || base.(*widget).Name != "nut" {
+		t.Fatalf("untyped get returned %+v: %v", base, err)
+	}
+}
+
+func TestTypedRepositoryOfAnotherEntity(t *testing.T) {
+	base := newTestRepo(t)
+	ctx := context.Background()
+	err, created := base.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[*gadget](base)
+	got, err := repo.GetByExternalId(ctx, created.GetExternalId())
+	if err == nil || !strings.Contains(err.Error(), "unexpected entity type") || got != nil {
+		t.Fatalf("expected an entity type error, got %+v: %v", got, err)
+	}
+	if items, err := repo.MultiGetByExternalId(ctx, []string{created.GetExternalId()}); err == nil || items != nil {
+		t.Fatalf("expected an entity type error, got %v", items)
+	}
+}
+
+func TestCreatorOf(t *testing.T) {
+	first, second := CreatorOf[widget](), CreatorOf[widget]()
+	created, ok := first().(*widget)
+	if !ok || created == nil {
+		t.Fatalf("created %#v, expected a new widget", created)
+	}
+	if created == second().(*widget) || created == first().(*widget) {
+		t.Fatal("creator returned the same widget twice")
+	}
+}
+
+func TestTypedElasticsearchRepo(t *testing.T) {
+	server := &documentServer{docs: map[string]map[string]interface{}{}}
+	repo := NewTypedElasticsearchRepo[widget](documentServerOptions(t, server)...)
+	ctx := context.Background()
+
+	bolt := &widget{Name: "bolt", Quantity: 2}
+	bolt.ExternalId = "w1"
+	created, err := repo.Create(ctx, bolt)
+	if err != nil || created != bolt {
+		t.Fatalf("created %+v: %v", created, err)
+	}
+	// documents are decoded into new widgets by the creator
+	got, err := repo.GetByExternalId(ctx, "w1")
+	if err != nil || got == bolt || got.ExternalId != "w1" || got.Name != "bolt" || got.Quantity != 2 {
+		t.Fatalf("got %+v: %v", got, err)
+	}
+	if _, err := repo.Update(ctx, "w1", &widget{Name: "nut", Quantity: 5}); err != nil {
+		t.Fatal(err)
+	}
+	all, err := repo.MultiGetByExternalId(ctx, []string{"w1"})
+	if err != nil || len(all) != 1 || all[0].Name != "nut" || all[0].Quantity != 5 {
+		t.Fatalf("multi get returned %+v: %v", all, err)
+	}
+
+	if err := repo.HardDelete(ctx, "w1"); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := repo.GetByExternalId(ctx, "w1")
+	if err == nil || missing != nil {
+		t.Fatalf("got removed widget %+v: %v", missing, err)
+	}
+	if err, base := repo.Base().GetByExternalId(ctx, "w1"); err == nil || base != nil {
+		t.Fatalf("untyped get returned removed widget %+v", base)
+	}
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/byteintellect/go_commons/monitoring"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const retryingCtxKey contextKey = "retrying"
+
+// transient MySQL error numbers, deadlocks, lock wait timeouts and writes hitting a read only server during failover
+var transientMySQLErrors = map[uint16]bool{
+	1205: true, // lock wait timeout exceeded
+	1213: true, // deadlock found
+	1290: true, // server running with --read-only
+	1792: true, // read only transaction
+	1836: true, // read only mode
+}
+
+// transient PostgreSQL SQLSTATE codes
+var transientPgErrors = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"55P03": true, // lock_not_available
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+	"08000": true, // connection_exception
+	"08003": true, // connection_does_not_exist
+	"08006": true, // connection_failure
+	"25006": true, // read_only_sql_transaction
+}
+
+// IsTransient reports whether err is a database error worth retrying: deadlocks, lock timeouts, failovers and
+// connections that failed before the statement was sent. A connection dropped or timing out after the statement
+// was sent is not transient as a write may have been applied, see IsTransientRead. Context cancellation is never
+// transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, errRevisionTaken) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return transientMySQLErrors[mysqlErr.Number]
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return transientPgErrors[pgErr.Code]
+	}
+	// database/sql only returns ErrBadConn when the driver did not send the statement
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	// sqlite errors are matched on their message to avoid depending on the sqlite driver's error types
+	message := err.Error()
+	return strings.Contains(message, "database is locked") || strings.Contains(message, "database table is locked")
+}
+
+// IsTransientRead reports whether a read failing with err is worth retrying, reads are idempotent so connections
+// dropped or timing out after the statement was sent are retried as well.
+func IsTransientRead(err error) bool {
+	if IsTransient(err) {
+		return true
+	}
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isDuplicateKey reports whether err is a unique constraint violation.
+func isDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// RetryPolicy retries operations failing with a transient error with exponential backoff and jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Classifier decides which errors are retried, IsTransient for writes and IsTransientRead for reads when nil
+	Classifier func(err error) bool
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   50 * time.Millisecond,
+		MaxDelay:    time.Second,
+	}
+}
+
+// WithRetryPolicy retries the repository's idempotent operations, reads, updates and deletes, on transient errors,
+// reads also on connections lost after the query was sent, see IsTransientRead. Create is not retried. Operations running inside a transaction are not retried individually, see RunInTxWithRetry.
+func WithRetryPolicy(policy *RetryPolicy) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.retryPolicy = policy
+	}
+}
+
+// Do runs fn until it succeeds, fails with a permanent error, or MaxAttempts is reached. Calls nested in a Do
+// or in a transaction run once, the outer operation is the one retried.
+func (p *RetryPolicy) Do(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	return p.do(ctx, operation, IsTransient, fn)
+}
+
+// doRead is Do for operations that do not write, retrying errors classified by IsTransientRead.
+func (p *RetryPolicy) doRead(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	return p.do(ctx, operation, IsTransientRead, fn)
+}
+
+func (p *RetryPolicy) do(ctx context.Context, operation string, classify func(err error) bool, fn func(ctx context.Context) error) error {
+	if p == nil || InTx(ctx) || ctx.Value(retryingCtxKey) != nil {
+		return fn(ctx)
+	}
+	attempt := new(int)
+	ctx = context.WithValue(ctx, retryingCtxKey, attempt)
+	if p.Classifier != nil {
+		classify = p.Classifier
+	}
+	for *attempt = 1; ; *attempt++ {
+		err := fn(ctx)
+		if err == nil || !classify(err) {
+			if *attempt > 1 {
+				monitoring.DbRetryOutcomes.WithLabelValues(operation, outcome(err)).Inc()
+			}
+			return err
+		}
+		if *attempt >= p.MaxAttempts {
+			monitoring.DbRetryOutcomes.WithLabelValues(operation, "exhausted").Inc()
+			return err
+		}
+		monitoring.DbRetries.WithLabelValues(operation).Inc()
+		select {
+		case <-time.After(p.delay(*attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// isRetry reports whether ctx belongs to a second or later attempt of a Do.
+func isRetry(ctx context.Context) bool {
+	attempt, ok := ctx.Value(retryingCtxKey).(*int)
+	return ok && *attempt > 1
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "succeeded"
+}
+
+// backoff returns the exponential delay after the given attempt, BaseDelay doubled for every attempt and capped
+// at MaxDelay when set.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay > 0 && delay <= math.MaxInt64/2; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// delay returns the backoff after the given attempt, a random value between half and all of the exponential delay.
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.backoff(attempt)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func retryValue[V any](ctx context.Context, policy *RetryPolicy, operation string, fn func(ctx context.Context) (error, V)) (error, V) {
+	var value V
+	err := policy.Do(ctx, operation, func(ctx context.Context) error {
+		var err error
+		err, value = fn(ctx)
+		return err
+	})
+	return err, value
+}
+
+// retryRead is retryValue for operations that do not write, see IsTransientRead.
+func retryRead[V any](ctx context.Context, policy *RetryPolicy, operation string, fn func(ctx context.Context) (error, V)) (error, V) {
+	var value V
+	err := policy.doRead(ctx, operation, func(ctx context.Context) error {
+		var err error
+		err, value = fn(ctx)
+		return err
+	})
+	return err, value
+}
+
+// RunInTxWithRetry runs fn in a transaction like RunInTx, running the whole transaction again when it fails
+// with a transient error. fn must be safe to run more than once. Within an existing transaction fn runs once
+// in a savepoint, the outermost transaction is the one to retry.
+func RunInTxWithRetry(ctx context.Context, db *gorm.DB, policy *RetryPolicy, fn func(ctx context.Context) error) error {
+	return policy.Do(ctx, "transaction", func(ctx context.Context) error {
+		return RunInTx(ctx, db, fn)
+	})
+}
+
+func (u *UnitOfWork) RunInTxWithRetry(ctx context.Context, policy *RetryPolicy, fn func(ctx context.Context) error) error {
+	return RunInTxWithRetry(ctx, u.db, policy, fn)
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransient(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	tests := []struct {
+		name      string
+		err       error
+		transient bool
+		read      bool
+	}{
+		{name: "no error", err: nil},
+		{name: "canceled", err: context.Canceled},
+		{name: "wrapped deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded)},
+		{name: "not found", err: gorm.ErrRecordNotFound},
+		{name: "mysql deadlock", err: &mysql.MySQLError{Number: 1213}, transient: true, read: true},
+		{name: "mysql read only", err: fmt.Errorf("update: %w", &mysql.MySQLError{Number: 1290}), transient: true, read: true},
+		{name: "mysql duplicate key", err: &mysql.MySQLError{Number: 1062}},
+		{name: "postgres serialization failure", err: &pgconn.PgError{Code: "40001"}, transient: true, read: true},
+		{name: "postgres unique violation", err: &pgconn.PgError{Code: "23505"}},
+		{name: "bad connection", err: fmt.Errorf("exec: %w", driver.ErrBadConn), transient: true, read: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, transient: true, read: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, read: true},
+		{name: "invalid connection", err: mysql.ErrInvalidConn, read: true},
+		{name: "read timeout", err: fmt.Errorf("exec: %w", timeout), read: true},
+		{name: "sqlite busy", err: errors.New("database is locked"), transient: true, read: true},
+		{name: "history revision taken", err: fmt.Errorf("%w: duplicate", errRevisionTaken), transient: true, read: true},
+		{name: "syntax error", err: errors.New("near \"SELEC\": syntax error")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if transient := IsTransient(test.err); transient != test.transient {
+				t.Fatalf("IsTransient(%v) = %v, expected %v", test.err, transient, test.transient)
+			}
+			if read := IsTransientRead(test.err); read != test.read {
+				t.Fatalf("IsTransientRead(%v) = %v, expected %v", test.err, read, test.read)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		delays []time.Duration
+	}{
+		{
+			name:   "no max delay",
+			policy: RetryPolicy{BaseDelay: 10 * time.Millisecond},
+			delays: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond},
+		},
+		{
+			name:   "capped",
+			policy: RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 30 * time.Millisecond},
+			delays: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond},
+		},
+		{
+			name:   "no base delay",
+			policy: RetryPolicy{},
+			delays: []time.Duration{0, 0, 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i, expected := range test.delays {
+				if delay := test.policy.backoff(i + 1); delay != expected {
+					t.Fatalf("backoff after attempt %v = %v, expected %v", i+1, delay, expected)
+				}
+				if delay := test.policy.delay(i + 1); delay < expected/2 || delay > expected {
+					t.Fatalf("delay after attempt %v = %v, expected between %v and %v", i+1, delay, expected/2, expected)
+				}
+			}
+		})
+	}
+	if delay := (&RetryPolicy{BaseDelay: time.Second}).backoff(100); delay <= 0 {
+		t.Fatalf("backoff overflowed to %v", delay)
+	}
+}
+
+func TestWriteTimeoutIsNotRetried(t *testing.T) {
+	db := newTestDb(t)
+	inserts := 0
+	// every insert is stored and then times out waiting for the reply
+	err := db.Callback().Create().After("gorm:create").Register("test:timeout", func(tx *gorm.DB) {
+		inserts++
+		tx.AddError(&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &RetryPolicy{MaxAttempts: 3}
+	repo := NewGORMRepository(WithDb(db), WithCreator(newWidget), WithRetryPolicy(policy))
+	ctx := context.Background()
+
+	if err, _ := repo.Create(ctx, &widget{Name: "bolt"}); err == nil {
+		t.Fatal("expected the create to fail")
+	}
+	err = RunInTxWithRetry(ctx, db, policy, func(ctx context.Context) error {
+		err, _ := repo.Create(ctx, &widget{Name: "nut"})
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+	if inserts != 2 {
+		t.Fatalf("inserted %v times, expected each create to run once", inserts)
+	}
+}
+
+func TestRetriedDeleteOfCommittedAttemptSucceeds(t *testing.T) {
+	db := newTestDb(t)
+	failed := false
+	// the first delete commits and then loses its connection
+	err := db.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register("test:drop_connection", func(tx *gorm.DB) {
+		if !failed {
+			failed = true
+			tx.AddError(driver.ErrBadConn)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewGORMRepository(WithDb(db), WithCreator(newWidget), WithRetryPolicy(&RetryPolicy{MaxAttempts: 2}))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.HardDelete(ctx, created.GetExternalId()); err != nil {
+		t.Fatalf("retried delete failed: %v", err)
+	}
+	if !failed {
+		t.Fatal("the first attempt did not fail")
+	}
+	if err := repo.HardDelete(ctx, created.GetExternalId()); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected deleting a missing entity to fail, got %v", err)
+	}
+}
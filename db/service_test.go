@@ -0,0 +1,51 @@
+package db_test
+
+import (
+	"context"
+	"github.com/byteintellect/go_commons/db"
+	"github.com/byteintellect/go_commons/svc"
+	"testing"
+)
+
+func TestServiceOverTypedRepositories(t *testing.T) {
+	repos := map[string]*db.Repository[*db.Widget]{
+		"gorm":          db.NewTypedGORMRepository[db.Widget](db.WithDb(db.NewTestDb(t))),
+		"elasticsearch": db.NewTypedElasticsearchRepo[db.Widget](db.NewDocumentServerOptions(t)...),
+	}
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			service := svc.NewService(repo)
+			ctx := db.WithLoaders(context.Background())
+
+			bolt := &db.Widget{Name: "bolt", Quantity: 2}
+			bolt.ExternalId = "w1"
+			created, err := service.Create(ctx, bolt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found, err := service.FindByExternalId(ctx, created.ExternalId)
+			if err != nil || found.Name != "bolt" || found.Quantity != 2 {
+				t.Fatalf("found %+v: %v", found, err)
+			}
+			// the update drops the widget from the request's loader
+			update := &db.Widget{Name: "nut", Quantity: 2}
+			update.ExternalId = created.ExternalId
+			if _, err := service.Update(ctx, created.ExternalId, update); err != nil {
+				t.Fatal(err)
+			}
+			found, err = service.FindByExternalId(ctx, created.ExternalId)
+			if err != nil || found.Name != "nut" {
+				t.Fatalf("found %+v after the update: %v", found, err)
+			}
+			if err := service.Delete(ctx, created.ExternalId); err != nil {
+				t.Fatal(err)
+			}
+			if found, err := service.FindByExternalId(ctx, created.ExternalId); err == nil || found != nil {
+				t.Fatalf("found deleted widget %+v", found)
+			}
+			if service.GetPersistence() != repo {
+				t.Fatal("service does not persist through its repository")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/byteintellect/go_commons/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const historyTableSuffix = "_history"
+
+var errHistoryDisabled = errors.New("history is not enabled for this repository")
+
+// errRevisionTaken is returned when a concurrent write stored the same revision first, the write is retried
+// as a transient error.
+var errRevisionTaken = errors.New("revision already recorded")
+
+// HistoryRecord is an immutable snapshot of an entity, valid from ValidFrom until ValidTo,
+// the current version of a live entity has no ValidTo.
+type HistoryRecord struct {
+	Id        uint64     `gorm:"primaryKey;AUTO_INCREMENT"`
+	EntityId  string     `gorm:"type:varchar(100);uniqueIndex:idx_history_revision,priority:1"`
+	Tenant    string     `gorm:"type:varchar(100)"`
+	Revision  uint64     `gorm:"not null;uniqueIndex:idx_history_revision,priority:2"`
+	Snapshot  string     `gorm:"type:text"`
+	ValidFrom time.Time  `gorm:"not null;index"`
+	ValidTo   *time.Time `gorm:"index"`
+}
+
+type EntityVersion struct {
+	Revision  uint64
+	ValidFrom time.Time
+	ValidTo   *time.Time
+	Entity    entity.Base
+}
+
+// WithHistory keeps a snapshot of every version of the entity in table, <entity table>_history when empty.
+// Snapshots are the entity's JSON, written in the same transaction as the change.
+func WithHistory(table string) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.history = true
+		r.historyTableName = table
+	}
+}
+
+func (r *GORMRepository) historyTable() string {
+	if r.historyTableName == "" {
+		return string(r.creator().GetTable()) + historyTableSuffix
+	}
+	return r.historyTableName
+}
+
+// MigrateHistory creates the history table, services managing their schema with db/migrate can create it there instead.
+func (r *GORMRepository) MigrateHistory() error {
+	if !r.history {
+		return errHistoryDisabled
+	}
+	return r.db.Table(r.historyTable()).AutoMigrate(&HistoryRecord{})
+}
+
+// recordHistory closes the current version of the entity and, unless it was deleted, opens a new one
+// from the entity as stored by the write.
+func (r *GORMRepository) recordHistory(ctx context.Context, action AuditAction, base entity.Base, externalId string) error {
+	now := time.Now()
+	tx := r.conn(ctx).Table(r.historyTable())
+	if err := tx.Session(&gorm.Session{}).
+		Where("entity_id = ? AND valid_to IS NULL", externalId).
+		Update("valid_to", now).Error; err != nil {
+		return err
+	}
+	if action == AuditSoftDelete || action == AuditHardDelete {
+		return nil
+	}
+	err, current := r.GetByExternalId(ctx, externalId)
+	if err != nil {
+		return err
+	}
+	snapshot, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	err, tenant := r.storedTenant(ctx, current, externalId)
+	if err != nil {
+		return err
+	}
+	// the latest revision is read with a lock so that concurrent writes number their versions in turn,
+	// the unique index on entity and revision rejects a duplicate where the database does not lock rows
+	var latest HistoryRecord
+	if err := tx.Session(&gorm.Session{}).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("entity_id = ?", externalId).Order("revision DESC").Limit(1).Find(&latest).Error; err != nil {
+		return err
+	}
+	err = tx.Session(&gorm.Session{}).Create(&HistoryRecord{
+		EntityId:  externalId,
+		Tenant:    tenant,
+		Revision:  latest.Revision + 1,
+		Snapshot:  string(snapshot),
+		ValidFrom: now,
+	}).Error
+	if isDuplicateKey(err) {
+		return fmt.Errorf("%w: %v", errRevisionTaken, err)
+	}
+	return err
+}
+
+func (r *GORMRepository) historyQuery(ctx context.Context, externalId string) (error, *gorm.DB) {
+	if !r.history {
+		return errHistoryDisabled, nil
+	}
+	return nil, r.reader(ctx).Table(r.historyTable()).Scopes(r.recordTenantScope(ctx)).Where("entity_id = ?", externalId)
+}
+
+// AsOf returns the entity as it was at the given time, gorm.ErrRecordNotFound if it did not exist then.
+func (r *GORMRepository) AsOf(ctx context.Context, externalId string, at time.Time) (error, entity.Base) {
+	err, tx := r.historyQuery(ctx, externalId)
+	if err != nil {
+		return err, nil
+	}
+	var record HistoryRecord
+	if err := tx.Where("valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)", at, at).
+		Order("revision DESC").First(&record).Error; err != nil {
+		return err, nil
+	}
+	return r.fromSnapshot(record)
+}
+
+// Versions returns every version of the entity, oldest first.
+func (r *GORMRepository) Versions(ctx context.Context, externalId string) (error, []EntityVersion) {
+	err, tx := r.historyQuery(ctx, externalId)
+	if err != nil {
+		return err, nil
+	}
+	var records []HistoryRecord
+	if err := tx.Order("revision").Find(&records).Error; err != nil {
+		return err, nil
+	}
+	var versions []EntityVersion
+	for _, record := range records {
+		err, base := r.fromSnapshot(record)
+		if err != nil {
+			return err, nil
+		}
+		versions = append(versions, EntityVersion{
+			Revision:  record.Revision,
+			ValidFrom: record.ValidFrom,
+			ValidTo:   record.ValidTo,
+			Entity:    base,
+		})
+	}
+	return nil, versions
+}
+
+func (r *GORMRepository) fromSnapshot(record HistoryRecord) (error, entity.Base) {
+	base := r.creator()
+	if err := json.Unmarshal([]byte(record.Snapshot), base); err != nil {
+		return err, nil
+	}
+	return nil, base
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/util"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newHistoryRepo(t *testing.T, db *gorm.DB, opts ...GORMRepositoryOption) *GORMRepository {
+	t.Helper()
+	opts = append([]GORMRepositoryOption{WithDb(db), WithCreator(newWidget), WithHistory("")}, opts...)
+	repo := NewGORMRepository(opts...)
+	if err := repo.MigrateHistory(); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// tick returns a time strictly between the writes made before and after it.
+func tick() time.Time {
+	time.Sleep(2 * time.Millisecond)
+	now := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	return now
+}
+
+func TestVersionsAndAsOf(t *testing.T) {
+	repo := newHistoryRepo(t, newTestDb(t))
+	ctx := context.Background()
+	beforeCreate := tick()
+	err, created := repo.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalId := created.GetExternalId()
+	beforeUpdate := tick()
+	if err, _ := repo.Update(ctx, externalId, &widget{Name: "washer"}); err != nil {
+		t.Fatal(err)
+	}
+	beforeDelete := tick()
+	if err := repo.SoftDelete(ctx, externalId); err != nil {
+		t.Fatal(err)
+	}
+	afterDelete := tick()
+
+	err, versions := repo.Versions(ctx, externalId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected two versions, got %+v", versions)
+	}
+	for i, name := range []string{"bolt", "washer"} {
+		version := versions[i]
+		if version.Revision != uint64(i+1) || version.Entity.(*widget).Name != name || version.ValidTo == nil {
+			t.Fatalf("version %v is %+v, expected closed revision %v named %v", i, version, i+1, name)
+		}
+	}
+
+	for at, name := range map[time.Time]string{beforeUpdate: "bolt", beforeDelete: "washer"} {
+		if err, base := repo.AsOf(ctx, externalId, at); err != nil || base.(*widget).Name != name {
+			t.Fatalf("as of %v got %v: %v, expected %v", at, base, err, name)
+		}
+	}
+	for _, at := range []time.Time{beforeCreate, afterDelete} {
+		if err, _ := repo.AsOf(ctx, externalId, at); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("as of %v expected not found, got %v", at, err)
+		}
+	}
+}
+
+func TestHistoryIsRecordedForTheEntityTenant(t *testing.T) {
+	repo := newHistoryRepo(t, newTestDb(t), WithTenantColumn("tenant_id"))
+	err, created := repo.Create(tenantCtx("acme"), &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalId := created.GetExternalId()
+	// a backfill updating every tenant's entities
+	if err, _ := repo.Update(util.WithAllTenants(context.Background()), externalId, &widget{Name: "washer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err, versions := repo.Versions(tenantCtx("acme"), externalId); err != nil || len(versions) != 2 {
+		t.Fatalf("owner got %v versions: %v", len(versions), err)
+	}
+	if err, versions := repo.Versions(tenantCtx("globex"), externalId); err != nil || len(versions) != 0 {
+		t.Fatalf("another tenant got %v versions: %v", len(versions), err)
+	}
+	if err, _ := repo.AsOf(context.Background(), externalId, time.Now()); !errors.Is(err, ErrMissingTenant) {
+		t.Fatalf("expected ErrMissingTenant, got %v", err)
+	}
+}
+
+func TestConcurrentRevisionIsRetried(t *testing.T) {
+	db := newTestDb(t)
+	repo := newHistoryRepo(t, db, WithRetryPolicy(&RetryPolicy{MaxAttempts: 2}))
+	ctx := context.Background()
+	err, created := repo.Create(ctx, &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalId := created.GetExternalId()
+
+	raced := false
+	// another writer stores the next revision between the read of the latest one and the insert
+	err = db.Callback().Create().Before("gorm:create").Register("test:race_revision", func(tx *gorm.DB) {
+		record, ok := tx.Statement.Dest.(*HistoryRecord)
+		if !ok || raced {
+			return
+		}
+		raced = true
+		tx.AddError(tx.Session(&gorm.Session{NewDB: true}).Table(repo.historyTable()).
+			Create(&HistoryRecord{EntityId: record.EntityId, Revision: record.Revision, ValidFrom: time.Now()}).Error)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, _ := repo.Update(ctx, externalId, &widget{Name: "washer"}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if !raced {
+		t.Fatal("the revision was not raced")
+	}
+
+	err, versions := repo.Versions(ctx, externalId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1].Revision != 2 || versions[1].Entity.(*widget).Name != "washer" {
+		t.Fatalf("got versions %+v", versions)
+	}
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/byteintellect/go_commons/entity"
+	cfErrors "github.com/byteintellect/go_commons/errors"
+	"github.com/byteintellect/go_commons/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrMissingTenant is returned by tenant scoped repositories when the context carries no tenant.
+var ErrMissingTenant = cfErrors.NewCFError(
+	cfErrors.WithCode("403"),
+	cfErrors.WithMessage("tenant is required"),
+	cfErrors.WithStatus(403))
+
+// ErrForeignTenant is returned when a write targets an entity of another tenant.
+var ErrForeignTenant = cfErrors.NewCFError(
+	cfErrors.WithCode("403"),
+	cfErrors.WithMessage("entity belongs to another tenant"),
+	cfErrors.WithStatus(403))
+
+// recordTenantColumn holds the tenant of the entity in audit entries and history records.
+const recordTenantColumn = "tenant"
+
+// tenantFor returns the tenant ctx is scoped to, scoped is false under util.WithAllTenants.
+func tenantFor(ctx context.Context) (error, string, bool) {
+	if util.AllTenants(ctx) {
+		return nil, "", false
+	}
+	tenant := util.Tenant(ctx)
+	if tenant == "" {
+		return ErrMissingTenant, "", false
+	}
+	return nil, tenant, true
+}
+
+// WithTenantColumn scopes every read and write of the repository to the tenant in the context,
+// column is set from the context on insert.
+func WithTenantColumn(column string) GORMRepositoryOption {
+	return func(r *GORMRepository) {
+		r.tenantColumn = column
+	}
+}
+
+func (r *GORMRepository) tenantScope(ctx context.Context) func(*gorm.DB) *gorm.DB {
+	return r.scopeToTenant(ctx, r.tenantColumn)
+}
+
+// recordTenantScope restricts audit entries and history records of the repository to the tenant in ctx.
+func (r *GORMRepository) recordTenantScope(ctx context.Context) func(*gorm.DB) *gorm.DB {
+	return r.scopeToTenant(ctx, recordTenantColumn)
+}
+
+// scopeToTenant filters column on the tenant in ctx when the repository is scoped WithTenantColumn.
+func (r *GORMRepository) scopeToTenant(ctx context.Context, column string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if r.tenantColumn == "" {
+			return tx
+		}
+		err, tenant, scoped := tenantFor(ctx)
+		if err != nil {
+			tx.AddError(err)
+			return tx
+		}
+		if !scoped {
+			return tx
+		}
+		return tx.Where(clause.Eq{Column: clause.Column{Name: column}, Value: tenant})
+	}
+}
+
+// storedTenant returns the tenant of the entity a write is recorded for, taken from base, the context or,
+// for writes made under util.WithAllTenants, the stored row. It is empty when the repository is not tenant scoped.
+func (r *GORMRepository) storedTenant(ctx context.Context, base entity.Base, externalId string) (error, string) {
+	if r.tenantColumn == "" {
+		return nil, ""
+	}
+	err, values := r.columnValues(ctx, base, []SortField{{Field: r.tenantColumn}})
+	if err != nil {
+		return err, ""
+	}
+	if tenant, ok := normalizeAuditValue(values[0]).(string); ok && tenant != "" {
+		return nil, tenant
+	}
+	err, tenant, scoped := tenantFor(ctx)
+	if err != nil || scoped {
+		return err, tenant
+	}
+	err = r.conn(ctx).Table(string(base.GetTable())).Select(r.tenantColumn).
+		Where("external_id = ?", externalId).Scan(&tenant).Error
+	return err, tenant
+}
+
+// setTenant sets the tenant column of base from ctx, keeping the value already set under util.WithAllTenants.
+func (r *GORMRepository) setTenant(ctx context.Context, base entity.Base) error {
+	if r.tenantColumn == "" {
+		return nil
+	}
+	err, tenant, scoped := tenantFor(ctx)
+	if err != nil || !scoped {
+		return err
+	}
+	return r.setColumn(ctx, base, r.tenantColumn, tenant)
+}
+
+// WithESTenantField scopes every search of the repository to the tenant in the context, field is set
+// from the context on index.
+func WithESTenantField(field string) ElasticsearchRepoOption {
+	return func(repo *ElasticsearchRepo) {
+		repo.tenantField = field
+	}
+}
+
+func (esr *ElasticsearchRepo) tenantCondition(ctx context.Context) (error, *Condition) {
+	if esr.tenantField == "" {
+		return nil, nil
+	}
+	err, tenant, scoped := tenantFor(ctx)
+	if err != nil || !scoped {
+		return err, nil
+	}
+	condition := Eq(esr.tenantField, tenant)
+	return nil, &condition
+}
+
+// ownsDocument reports whether source belongs to the tenant in ctx.
+func (esr *ElasticsearchRepo) ownsDocument(ctx context.Context, source map[string]interface{}) (error, bool) {
+	err, condition := esr.tenantCondition(ctx)
+	if err != nil || condition == nil {
+		return err, err == nil
+	}
+	return nil, source[esr.tenantField] == condition.Value
+}
+
+// tenantDocument returns the JSON document of base with the tenant field set from ctx.
+func (esr *ElasticsearchRepo) tenantDocument(ctx context.Context, base entity.Base) (error, string) {
+	jBody, err := base.ToJson()
+	if err != nil {
+		return err, ""
+	}
+	err, condition := esr.tenantCondition(ctx)
+	if err != nil || condition == nil {
+		return err, jBody
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(jBody), &doc); err != nil {
+		return err, ""
+	}
+	doc[esr.tenantField] = condition.Value
+	docBytes, err := json.Marshal(doc)
+	if err != nil {
+		return err, ""
+	}
+	return nil, string(docBytes)
+}
+
+// checkTenant fails unless the document exists and belongs to the tenant in ctx, deleted documents included.
+func (esr *ElasticsearchRepo) checkTenant(ctx context.Context, entityId string) error {
+	if esr.tenantField == "" {
+		return nil
+	}
+	err, _ := esr.GetByExternalId(WithDeleted(ctx), entityId)
+	return err
+}
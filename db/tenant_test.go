@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/byteintellect/go_commons/entity"
+	"github.com/byteintellect/go_commons/util"
+	"testing"
+)
+
+func TestTenantScopedReads(t *testing.T) {
+	repo := newTestRepo(t, WithTenantColumn("tenant_id"))
+	err, created := repo.Create(tenantCtx("acme"), &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant := created.(*widget).TenantId; tenant != "acme" {
+		t.Fatalf("tenant column set to %q, expected acme", tenant)
+	}
+	externalId := created.GetExternalId()
+
+	if err, _ := repo.GetByExternalId(tenantCtx("acme"), externalId); err != nil {
+		t.Fatalf("owner could not read its entity: %v", err)
+	}
+	if err, _ := repo.GetByExternalId(tenantCtx("globex"), externalId); err == nil {
+		t.Fatal("another tenant read the entity")
+	}
+	if err, bases := repo.MultiGetByExternalId(tenantCtx("globex"), []string{externalId}); err != nil || len(bases) != 0 {
+		t.Fatalf("another tenant got %v entities, err %v", len(bases), err)
+	}
+	if err, _ := repo.GetByExternalId(context.Background(), externalId); !errors.Is(err, ErrMissingTenant) {
+		t.Fatalf("read without a tenant returned %v, expected ErrMissingTenant", err)
+	}
+	if err, _ := repo.GetByExternalId(util.WithAllTenants(context.Background()), externalId); err != nil {
+		t.Fatalf("read across tenants failed: %v", err)
+	}
+}
+
+func TestTenantScopedWrites(t *testing.T) {
+	repo := newTestRepo(t, WithTenantColumn("tenant_id"))
+	err, created := repo.Create(tenantCtx("acme"), &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalId := created.GetExternalId()
+
+	if err, _ := repo.Update(tenantCtx("globex"), externalId, &widget{Name: "nut"}); err == nil {
+		t.Fatal("another tenant updated the entity")
+	}
+	if err := repo.SoftDelete(tenantCtx("globex"), externalId); err == nil {
+		t.Fatal("another tenant deleted the entity")
+	}
+	err, base := repo.GetByExternalId(tenantCtx("acme"), externalId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := base.(*widget).Name; name != "bolt" {
+		t.Fatalf("name changed to %q by another tenant", name)
+	}
+}
+
+func TestUpsertKeepsRowsOfOtherTenants(t *testing.T) {
+	repo := newTestRepo(t, WithTenantColumn("tenant_id"))
+	err, created := repo.Create(tenantCtx("acme"), &widget{Name: "bolt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	externalId := created.GetExternalId()
+
+	intruder := &widget{Name: "nut"}
+	intruder.ExternalId = externalId
+	err, result := repo.Upsert(tenantCtx("globex"), []entity.Base{intruder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed) != 1 || !errors.Is(result.Failed[0].Err, ErrForeignTenant) {
+		t.Fatalf("upsert of another tenant's row returned %+v, expected ErrForeignTenant", result.Failed)
+	}
+	err, base := repo.GetByExternalId(tenantCtx("acme"), externalId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := base.(*widget); w.Name != "bolt" || w.TenantId != "acme" {
+		t.Fatalf("row overwritten by another tenant: %+v", w)
+	}
+
+	own := &widget{Name: "washer"}
+	own.ExternalId = externalId
+	if err, result := repo.Upsert(tenantCtx("acme"), []entity.Base{own}); err != nil || len(result.Failed) != 0 {
+		t.Fatalf("owner could not upsert its row: %v %+v", err, result)
+	}
+	if err, base = repo.GetByExternalId(tenantCtx("acme"), externalId); err != nil || base.(*widget).Name != "washer" {
+		t.Fatalf("owner upsert not applied: %v %v", err, base)
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+)
+
+const txCtxKey contextKey = "gorm-tx"
+
+type txState struct {
+	root        *gorm.DB
+	tx          *gorm.DB
+	depth       int
+	afterCommit []func()
+}
+
+// UnitOfWork runs functions inside a database transaction that every GORMRepository sharing the
+// same *gorm.DB joins when called with the function's context.
+type UnitOfWork struct {
+	db *gorm.DB
+}
+
+func NewUnitOfWork(db *gorm.DB) *UnitOfWork {
+	return &UnitOfWork{db: db}
+}
+
+// RunInTx runs fn in a transaction, committing when fn returns nil and rolling back when it returns an
+// error or panics. Calling RunInTx with a context that already carries a transaction creates a savepoint,
+// so a failing inner call only rolls back its own work.
+func (u *UnitOfWork) RunInTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if parent, ok := ctx.Value(txCtxKey).(*txState); ok && parent.root == u.db {
+		return u.runNested(ctx, parent, fn)
+	}
+	tx := u.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	state := &txState{root: u.db, tx: tx}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		if err = tx.Commit().Error; err != nil {
+			return
+		}
+		for _, hook := range state.afterCommit {
+			hook()
+		}
+	}()
+	return fn(context.WithValue(ctx, txCtxKey, state))
+}
+
+func (u *UnitOfWork) runNested(ctx context.Context, parent *txState, fn func(ctx context.Context) error) (err error) {
+	savepoint := fmt.Sprintf("sp_%v", parent.depth+1)
+	if err := parent.tx.SavePoint(savepoint).Error; err != nil {
+		return err
+	}
+	state := &txState{root: parent.root, tx: parent.tx, depth: parent.depth + 1}
+	defer func() {
+		if p := recover(); p != nil {
+			parent.tx.RollbackTo(savepoint)
+			releaseSavePoint(parent.tx, savepoint)
+			panic(p)
+		}
+		if err != nil {
+			parent.tx.RollbackTo(savepoint)
+			releaseSavePoint(parent.tx, savepoint)
+			return
+		}
+		if err = releaseSavePoint(parent.tx, savepoint); err != nil {
+			return
+		}
+		// hooks of a savepoint only run if the outermost transaction commits
+		parent.afterCommit = append(parent.afterCommit, state.afterCommit...)
+	}()
+	return fn(context.WithValue(ctx, txCtxKey, state))
+}
+
+// releaseSavePoint drops a savepoint once its work is kept or rolled back, so that long running transactions
+// calling RunInTx in a loop do not accumulate savepoints.
+func releaseSavePoint(tx *gorm.DB, savepoint string) error {
+	return tx.Exec("RELEASE SAVEPOINT " + savepoint).Error
+}
+
+func RunInTx(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	return NewUnitOfWork(db).RunInTx(ctx, fn)
+}
+
+// AfterCommit registers hook to run once the transaction carried by ctx commits, hooks are dropped
+// on rollback. Without a transaction in ctx the hook runs immediately.
+func AfterCommit(ctx context.Context, hook func()) {
+	if state, ok := ctx.Value(txCtxKey).(*txState); ok {
+		state.afterCommit = append(state.afterCommit, hook)
+		return
+	}
+	hook()
+}
+
+// InTx reports whether ctx carries a transaction.
+func InTx(ctx context.Context) bool {
+	_, ok := ctx.Value(txCtxKey).(*txState)
+	return ok
+}
+
+// conn returns the transaction carried by ctx when it was started on db, otherwise db itself.
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if state, ok := ctx.Value(txCtxKey).(*txState); ok && state.root == db {
+		return state.tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
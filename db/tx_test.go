@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"testing"
+)
+
+var errTxTest = errors.New("failed")
+
+func countWidgets(t *testing.T, repo *GORMRepository) int64 {
+	t.Helper()
+	err, total := repo.Count(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
+
+func TestRunInTxCommits(t *testing.T) {
+	repo := newTestRepo(t)
+	committed := false
+	err := RunInTx(context.Background(), repo.db, func(ctx context.Context) error {
+		if err, _ := repo.Create(ctx, &widget{Name: "bolt"}); err != nil {
+			return err
+		}
+		AfterCommit(ctx, func() {
+			committed = true
+		})
+		if committed {
+			t.Fatal("after commit hook ran before the commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed || countWidgets(t, repo) != 1 {
+		t.Fatalf("hook ran %v, %v widgets stored", committed, countWidgets(t, repo))
+	}
+}
+
+func TestRunInTxRollsBackOnError(t *testing.T) {
+	repo := newTestRepo(t)
+	hooked := false
+	err := RunInTx(context.Background(), repo.db, func(ctx context.Context) error {
+		if err, _ := repo.Create(ctx, &widget{Name: "bolt"}); err != nil {
+			return err
+		}
+		AfterCommit(ctx, func() {
+			hooked = true
+		})
+		return errTxTest
+	})
+	if !errors.Is(err, errTxTest) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	if hooked || countWidgets(t, repo) != 0 {
+		t.Fatalf("hook ran %v, %v widgets stored after a rollback", hooked, countWidgets(t, repo))
+	}
+}
+
+func TestRunInTxRollsBackOnPanic(t *testing.T) {
+	repo := newTestRepo(t)
+	func() {
+		defer func() {
+			if p := recover(); p != errTxTest {
+				t.Fatalf("expected the panic to propagate, got %v", p)
+			}
+		}()
+		RunInTx(context.Background(), repo.db, func(ctx context.Context) error {
+			if err, _ := repo.Create(ctx, &widget{Name: "bolt"}); err != nil {
+				return err
+			}
+			panic(errTxTest)
+		})
+	}()
+	if total := countWidgets(t, repo); total != 0 {
+		t.Fatalf("%v widgets stored after a panic", total)
+	}
+}
+
+func TestNestedRunInTxRollsBackToSavepoint(t *testing.T) {
+	repo := newTestRepo(t)
+	var hooks []string
+	err := RunInTx(context.Background(), repo.db, func(ctx context.Context) error {
+		if err, _ := repo.Create(ctx, &widget{Name: "bolt"}); err != nil {
+			return err
+		}
+		err := RunInTx(ctx, repo.db, func(ctx context.Context) error {
+			if err, _ := repo.Create(ctx, &widget{Name: "nut"}); err != nil {
+				return err
+			}
+			AfterCommit(ctx, func() {
+				hooks = append(hooks, "nut")
+			})
+			return errTxTest
+		})
+		if !errors.Is(err, errTxTest) {
+			t.Fatalf("expected the error of the nested fn, got %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			err := RunInTx(ctx, repo.db, func(ctx context.Context) error {
+				AfterCommit(ctx, func() {
+					hooks = append(hooks, "washer")
+				})
+				err, _ := repo.Create(ctx, &widget{Name: "washer"})
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		// released savepoints are gone
+		if err := txFor(ctx).Exec("RELEASE SAVEPOINT sp_1").Error; err == nil {
+			t.Fatal("savepoint was not released")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := countWidgets(t, repo); total != 4 {
+		t.Fatalf("%v widgets stored, expected bolt and three washers", total)
+	}
+	if len(hooks) != 3 {
+		t.Fatalf("ran hooks %v, expected only those of the committed savepoints", hooks)
+	}
+}
+
+func txFor(ctx context.Context) *gorm.DB {
+	return ctx.Value(txCtxKey).(*txState).tx
+}
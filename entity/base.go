@@ -120,13 +120,31 @@ func (et *CFTime) Scan(value interface{}) error {
 	return nil
 }
 
+// BaseDomain holds the columns shared by every entity. Its json names are the format of the values cached in
+// redis and of the documents indexed in elasticsearch, renaming them requires a reindex and a cache flush.
 type BaseDomain struct {
-	ExternalId string     `json:"external_id" gorm:"type:varchar(100);uniqueIndex"`
-	Id         uint64     `json:"id" gorm:"primaryKey;AUTO_INCREMENT"`
-	CreatedAt  *time.Time `json:"created_at" type:"date"`
-	UpdatedAt  *time.Time `type:"date"`
-	DeletedAt  *time.Time `type:"date"`
-	Status     int        `type:"int"`
+	ExternalId string     `json:"external_id" gorm:"type:varchar(100);uniqueIndex" es:"keyword"`
+	Id         uint64     `json:"id" gorm:"primaryKey;AUTO_INCREMENT" es:"type=long"`
+	CreatedAt  *time.Time `json:"created_at" es:"type=date"`
+	UpdatedAt  *time.Time `es:"type=date"`
+	DeletedAt  *time.Time `es:"type=date"`
+	Status     int        `es:"type=integer"`
+}
+
+// Versioned is implemented by entities carrying a version used for optimistic locking.
+type Versioned interface {
+	GetVersion() uint64
+}
+
+// VersionedDomain is embedded instead of BaseDomain by entities updated with optimistic locking,
+// it adds the version column to the columns of BaseDomain.
+type VersionedDomain struct {
+	BaseDomain
+	Version uint64 `json:"version" gorm:"not null;default:0" es:"type=long"`
+}
+
+func (vd VersionedDomain) GetVersion() uint64 {
+	return vd.Version
 }
 
 func (bd BaseDomain) GetExternalId() string {
@@ -174,7 +192,7 @@ func (bd BaseDomain) MarshalBinary() ([]byte, error) {
 	return json.Marshal(bd)
 }
 
-func (bd BaseDomain) UnmarshalBinary(buffer []byte) error {
+func (bd *BaseDomain) UnmarshalBinary(buffer []byte) error {
 	return json.Unmarshal(buffer, bd)
 }
 
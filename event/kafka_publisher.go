@@ -1,6 +1,7 @@
 package event
 
 import (
+	"context"
 	"fmt"
 	"github.com/byteintellect/go_commons/entity"
 	"gopkg.in/confluentinc/confluent-kafka-go.v1/kafka"
@@ -62,6 +63,30 @@ func (kP *KafkaPublisher) PublishAsync(event entity.Event) {
 	}
 }
 
+// PublishSync produces event keyed by its entity id, keeping an entity's events in one partition,
+// and waits for the broker to acknowledge it.
+func (kP *KafkaPublisher) PublishSync(ctx context.Context, event entity.Event) error {
+	deliveries := make(chan kafka.Event, 1)
+	entityType := event.GetEntityType()
+	err := kP.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &entityType, Partition: kafka.PartitionAny},
+		Key:            []byte(event.GetEntityId()),
+		Value:          event.ToBytes(),
+	}, deliveries)
+	if err != nil {
+		return err
+	}
+	select {
+	case e := <-deliveries:
+		if message, ok := e.(*kafka.Message); ok {
+			return message.TopicPartition.Error
+		}
+		return fmt.Errorf("unexpected delivery report %v", e)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (kP *KafkaPublisher) Flush() {
 	kP.producer.Flush(15 * 1000)
 }
@@ -1,6 +1,7 @@
 package event
 
 import (
+	"context"
 	"github.com/byteintellect/go_commons/entity"
 )
 
@@ -10,3 +11,8 @@ type Publisher interface {
 	Flush()
 	Close()
 }
+
+// ReliablePublisher is implemented by publishers that can report whether an event was delivered.
+type ReliablePublisher interface {
+	PublishSync(ctx context.Context, event entity.Event) error
+}
@@ -24,3 +24,18 @@ func InitHttp() {
 	prometheus.DefaultRegisterer.MustRegister(HttpResponseStatusCode)
 	prometheus.DefaultRegisterer.MustRegister(HttpDuration)
 }
+
+var DbRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "db_retries_total",
+	Help: "number of database operations retried after a transient error",
+}, []string{"operation"})
+
+var DbRetryOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "db_retry_outcomes_total",
+	Help: "outcome of database operations that hit a transient error, succeeded or exhausted",
+}, []string{"operation", "outcome"})
+
+func InitDb() {
+	prometheus.DefaultRegisterer.MustRegister(DbRetries)
+	prometheus.DefaultRegisterer.MustRegister(DbRetryOutcomes)
+}
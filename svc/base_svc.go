@@ -18,8 +18,9 @@ func (b *BaseSvc) FindById(ctx context.Context, id uint64) (error, entity.Base)
 	return b.Persistence.GetById(ctx, id)
 }
 
+// FindByExternalId batches and caches the lookup through the request's loader when the context has one, see db.WithLoaders.
 func (b *BaseSvc) FindByExternalId(ctx context.Context, id string) (error, entity.Base) {
-	return b.Persistence.GetByExternalId(ctx, id)
+	return db.Load(ctx, b.Persistence, id)
 }
 
 func (b *BaseSvc) MultiGetByExternalId(ctx context.Context, ids []string) (error, []entity.Base) {
@@ -31,7 +32,34 @@ func (b *BaseSvc) Create(ctx context.Context, base entity.Base) (error, entity.B
 }
 
 func (b *BaseSvc) Update(ctx context.Context, id string, base entity.Base) (error, entity.Base) {
-	return b.Persistence.Update(ctx, id, base)
+	err, updated := b.Persistence.Update(ctx, id, base)
+	b.forget(ctx, id)
+	return err, updated
+}
+
+func (b *BaseSvc) UpdateFields(ctx context.Context, id string, base entity.Base, paths []string) (error, entity.Base) {
+	err, updated := b.Persistence.UpdateFields(ctx, id, base, paths)
+	b.forget(ctx, id)
+	return err, updated
+}
+
+func (b *BaseSvc) Delete(ctx context.Context, id string) error {
+	err := b.Persistence.Delete(ctx, id)
+	b.forget(ctx, id)
+	return err
+}
+
+func (b *BaseSvc) Restore(ctx context.Context, id string) error {
+	err := b.Persistence.Restore(ctx, id)
+	b.forget(ctx, id)
+	return err
+}
+
+// forget drops the entity from the request's loader once a write returns, so that later reads see the new state.
+func (b *BaseSvc) forget(ctx context.Context, id string) {
+	if loader := db.LoaderFor(ctx, b.Persistence); loader != nil {
+		loader.Clear(id)
+	}
 }
 
 func (b *BaseSvc) GetPersistence() db.BaseRepository {
@@ -0,0 +1,77 @@
+package svc
+
+import (
+	"context"
+	"github.com/byteintellect/go_commons/db"
+	"github.com/byteintellect/go_commons/entity"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// Service is the typed counterpart of BaseSvc, built on db.Repository.
+type Service[T entity.Base] struct {
+	Persistence *db.Repository[T]
+}
+
+func NewService[T entity.Base](persistence *db.Repository[T]) Service[T] {
+	return Service[T]{
+		Persistence: persistence,
+	}
+}
+
+func (s *Service[T]) FindById(ctx context.Context, id uint64) (T, error) {
+	return s.Persistence.GetById(ctx, id)
+}
+
+// FindByExternalId batches and caches the lookup through the request's loader when the context has one, see db.WithLoaders.
+func (s *Service[T]) FindByExternalId(ctx context.Context, id string) (T, error) {
+	return s.Persistence.Load(ctx, id)
+}
+
+func (s *Service[T]) MultiGetByExternalId(ctx context.Context, ids []string) ([]T, error) {
+	return s.Persistence.MultiGetByExternalId(ctx, ids)
+}
+
+func (s *Service[T]) Create(ctx context.Context, model T) (T, error) {
+	return s.Persistence.Create(ctx, model)
+}
+
+func (s *Service[T]) Update(ctx context.Context, id string, model T) (T, error) {
+	updated, err := s.Persistence.Update(ctx, id, model)
+	s.forget(ctx, id)
+	return updated, err
+}
+
+func (s *Service[T]) UpdateFields(ctx context.Context, id string, model T, paths []string) (T, error) {
+	updated, err := s.Persistence.UpdateFields(ctx, id, model, paths)
+	s.forget(ctx, id)
+	return updated, err
+}
+
+func (s *Service[T]) UpdateMask(ctx context.Context, id string, model T, mask *fieldmaskpb.FieldMask) (T, error) {
+	updated, err := s.Persistence.UpdateMask(ctx, id, model, mask)
+	s.forget(ctx, id)
+	return updated, err
+}
+
+func (s *Service[T]) Delete(ctx context.Context, id string) error {
+	err := s.Persistence.Delete(ctx, id)
+	s.forget(ctx, id)
+	return err
+}
+
+func (s *Service[T]) Restore(ctx context.Context, id string) error {
+	err := s.Persistence.Restore(ctx, id)
+	s.forget(ctx, id)
+	return err
+}
+
+// forget drops the entity from the request's loader once a write returns, so that later reads see the new state.
+func (s *Service[T]) forget(ctx context.Context, id string) {
+	if loader := db.LoaderFor(ctx, s.Persistence.Base()); loader != nil {
+		loader.Clear(id)
+	}
+}
+
+func (s *Service[T]) GetPersistence() *db.Repository[T] {
+	return s.Persistence
+}
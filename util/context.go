@@ -0,0 +1,59 @@
+package util
+
+import "context"
+
+type contextKey string
+
+// RequestIdCtxKey is the header and context key carrying the correlation id of a request.
+const RequestIdCtxKey = "X-CO-RELATION-ID"
+
+const actorCtxKey contextKey = "actor"
+
+// WithRequestId returns a context carrying the correlation id of the request.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, RequestIdCtxKey, requestId)
+}
+
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(RequestIdCtxKey).(string)
+	return requestId
+}
+
+// WithActor returns a context carrying the user or service acting in the request, typically set by auth middleware.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorCtxKey, actor)
+}
+
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorCtxKey).(string)
+	return actor
+}
+
+// TenantHeader is the header a trusted upstream uses to pass the tenant of a request.
+const TenantHeader = "X-TENANT-ID"
+
+const (
+	tenantCtxKey     contextKey = "tenant"
+	allTenantsCtxKey contextKey = "all-tenants"
+)
+
+// WithTenant returns a context scoping repository, cache and search operations to tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey, tenant)
+}
+
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantCtxKey).(string)
+	return tenant
+}
+
+// WithAllTenants returns a context under which tenant scoped repositories read and write across tenants,
+// meant for admin and maintenance operations only.
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsCtxKey, true)
+}
+
+func AllTenants(ctx context.Context) bool {
+	all, _ := ctx.Value(allTenantsCtxKey).(bool)
+	return all
+}
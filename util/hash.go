@@ -10,6 +10,10 @@ type Signer struct {
 	key string
 }
 
+func NewSigner(key string) *Signer {
+	return &Signer{key: key}
+}
+
 func (h *Signer) GenerateSignature(payload string) string {
 	signer := hmac.New(sha256.New, []byte(h.key))
 	signer.Write([]byte(payload))
@@ -18,5 +22,5 @@ func (h *Signer) GenerateSignature(payload string) string {
 
 func (h *Signer) ValidateSignature(payload string, eSignature string) bool {
 	cSignature := h.GenerateSignature(payload)
-	return cSignature == eSignature
+	return hmac.Equal([]byte(cSignature), []byte(eSignature))
 }
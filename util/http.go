@@ -132,10 +132,10 @@ func (hul *Http) DoGet(uri string, factoryFunc Factory, reqOptions ...ReqOption)
 	}
 	defer hul.recordSpan(req)
 	resp, err := hul.client.Do(req)
-	defer resp.Body.Close()
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	return hul.unmarshal(factoryFunc, resp)
 }
 
@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"github.com/byteintellect/protos_go/users/v1"
 	"github.com/dgrijalva/jwt-go"
+	"google.golang.org/protobuf/proto"
 	"io"
 	"time"
 )
@@ -14,6 +15,7 @@ import (
 type Claims struct {
 	jwt.StandardClaims
 	usersv1.UserDto
+	TenantId string `json:"tenant_id,omitempty"`
 }
 
 func GenerateAccessRefreshKeyPair(accessTokenDuration, refreshTokenDuration string, secretKey string, dto *usersv1.UserDto) (map[string]string, error) {
@@ -42,11 +44,11 @@ func GenerateAccessRefreshKeyPair(accessTokenDuration, refreshTokenDuration stri
 func CreateToken(dto *usersv1.UserDto, secretKey string, expirationTime time.Time) (string, error) {
 	var err error
 	claims := &Claims{
-		UserDto: *dto,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
+	proto.Merge(&claims.UserDto, dto)
 	at := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	token, err := at.SignedString([]byte(secretKey))
 	if err != nil {